@@ -16,7 +16,6 @@ import (
 )
 
 func main() {
-	jwtSecret := "test"
 	logger := logrus.New()
 	logger.SetFormatter(&logrus.JSONFormatter{})
 	logger.SetLevel(logrus.InfoLevel)
@@ -26,7 +25,10 @@ func main() {
 	if err != nil {
 		logger.Fatal(err)
 	}
-	service := service.NewService(storage, logger, jwtSecret, cfg.AccSysAddr)
+	service, err := service.NewService(storage, logger, cfg)
+	if err != nil {
+		logger.Fatal(err)
+	}
 	server := api.NewServer(cfg, service, logger)
 	ctx, cancel := context.WithCancel(context.Background())
 
@@ -45,6 +47,8 @@ func main() {
 		}
 	}()
 
+	go service.ListenEvents(ctx)
+
 	go func() {
 		if err := server.Start(); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("listen: %s\n", err)
@@ -0,0 +1,204 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/chestorix/gophermart/internal/api/middleware"
+	e "github.com/chestorix/gophermart/internal/errors"
+	"github.com/chestorix/gophermart/internal/models"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type adjustmentResponse struct {
+	ID         int                     `json:"id"`
+	UserID     int                     `json:"user_id"`
+	Amount     float64                 `json:"amount"`
+	ReasonCode models.AdjustmentReason `json:"reason_code"`
+	Comment    string                  `json:"comment"`
+	CreatedBy  int                     `json:"created_by"`
+	Status     models.AdjustmentStatus `json:"status"`
+	ReviewedBy *int                    `json:"reviewed_by,omitempty"`
+	ReviewedAt *time.Time              `json:"reviewed_at,omitempty"`
+	CreatedAt  time.Time               `json:"created_at"`
+}
+
+func newAdjustmentResponse(adj models.BalanceAdjustment) adjustmentResponse {
+	return adjustmentResponse{
+		ID:         adj.ID,
+		UserID:     adj.UserID,
+		Amount:     adj.Amount,
+		ReasonCode: adj.Reason,
+		Comment:    adj.Comment,
+		CreatedBy:  adj.CreatedBy,
+		Status:     adj.Status,
+		ReviewedBy: adj.ReviewedBy,
+		ReviewedAt: adj.ReviewedAt,
+		CreatedAt:  adj.CreatedAt,
+	}
+}
+
+// CreateAdjustment credits or debits a user's balance. It answers 201 when
+// the adjustment is applied and 202 when it waits for a second operator.
+func (h *Handler) CreateAdjustment(w http.ResponseWriter, r *http.Request) {
+	operatorID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		h.fail(w, r, e.ErrUnauthorized)
+		return
+	}
+	userID, ok := h.targetUserID(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Amount     float64                 `json:"amount"`
+		ReasonCode models.AdjustmentReason `json:"reason_code"`
+		Comment    string                  `json:"comment"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.fail(w, r, e.ErrInvalidRequest)
+		return
+	}
+
+	adj, err := h.service.CreateAdjustment(r.Context(), models.BalanceAdjustment{
+		UserID:    userID,
+		Amount:    req.Amount,
+		Reason:    req.ReasonCode,
+		Comment:   req.Comment,
+		CreatedBy: operatorID,
+	})
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+
+	status := http.StatusCreated
+	if adj.Status == models.AdjustmentStatusPendingApproval {
+		status = http.StatusAccepted
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(newAdjustmentResponse(adj)); err != nil {
+		h.logger.Errorf("encode response failed: %v", err)
+	}
+}
+
+func (h *Handler) GetUserAdjustments(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.targetUserID(w, r)
+	if !ok {
+		return
+	}
+	adjustments, err := h.service.GetUserAdjustments(r.Context(), userID)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	h.writeAdjustments(w, adjustments)
+}
+
+func (h *Handler) GetPendingAdjustments(w http.ResponseWriter, r *http.Request) {
+	adjustments, err := h.service.GetPendingAdjustments(r.Context())
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	h.writeAdjustments(w, adjustments)
+}
+
+func (h *Handler) writeAdjustments(w http.ResponseWriter, adjustments []models.BalanceAdjustment) {
+	if len(adjustments) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	response := make([]adjustmentResponse, 0, len(adjustments))
+	for _, adj := range adjustments {
+		response = append(response, newAdjustmentResponse(adj))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		h.logger.Errorf("encode response failed: %v", err)
+	}
+}
+
+func (h *Handler) ApproveAdjustment(w http.ResponseWriter, r *http.Request) {
+	h.reviewAdjustment(w, r, models.ReviewDecisionApproved)
+}
+
+func (h *Handler) RejectAdjustment(w http.ResponseWriter, r *http.Request) {
+	h.reviewAdjustment(w, r, models.ReviewDecisionRejected)
+}
+
+func (h *Handler) reviewAdjustment(w http.ResponseWriter, r *http.Request, decision models.ReviewDecision) {
+	reviewerID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		h.fail(w, r, e.ErrUnauthorized)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		h.fail(w, r, e.InvalidField("id", "format", "invalid adjustment id"))
+		return
+	}
+
+	adj, err := h.service.ReviewAdjustment(r.Context(), id, reviewerID, decision)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	middleware.SetAuditTarget(r.Context(), adj.UserID)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(newAdjustmentResponse(adj)); err != nil {
+		h.logger.Errorf("encode response failed: %v", err)
+	}
+}
+
+// GetUserHistory lists every balance change of the caller. Adjustments show
+// their reason code; the operator's comment stays internal.
+func (h *Handler) GetUserHistory(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		h.fail(w, r, e.ErrUnauthorized)
+		return
+	}
+
+	history, err := h.service.GetUserHistory(r.Context(), userID)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+
+	if len(history) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	type historyResponse struct {
+		Type      models.HistoryEntryType `json:"type"`
+		Amount    float64                 `json:"amount"`
+		Reference string                  `json:"reference"`
+		Status    string                  `json:"status,omitempty"`
+		At        time.Time               `json:"at"`
+	}
+
+	response := make([]historyResponse, 0, len(history))
+	for _, entry := range history {
+		response = append(response, historyResponse{
+			Type:      entry.Type,
+			Amount:    entry.Amount,
+			Reference: entry.Reference,
+			Status:    entry.Status,
+			At:        entry.At,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		h.logger.Errorf("encode response failed: %v", err)
+	}
+}
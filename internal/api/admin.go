@@ -0,0 +1,197 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/chestorix/gophermart/internal/api/middleware"
+	e "github.com/chestorix/gophermart/internal/errors"
+	"github.com/chestorix/gophermart/internal/models"
+	"github.com/go-chi/chi/v5"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+func (h *Handler) GetPendingWithdrawals(w http.ResponseWriter, r *http.Request) {
+	withdrawals, err := h.service.GetPendingWithdrawals(r.Context())
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+
+	if len(withdrawals) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	type pendingWithdrawalResponse struct {
+		Order       string    `json:"order"`
+		UserID      int       `json:"user_id"`
+		Sum         float64   `json:"sum"`
+		ProcessedAt time.Time `json:"processed_at"`
+	}
+
+	response := make([]pendingWithdrawalResponse, 0, len(withdrawals))
+	for _, w := range withdrawals {
+		response = append(response, pendingWithdrawalResponse{
+			Order:       w.Order,
+			UserID:      w.UserID,
+			Sum:         w.Sum,
+			ProcessedAt: w.ProcessedAt,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		h.logger.Errorf("encode response failed: %v", err)
+	}
+}
+
+func (h *Handler) ApproveWithdrawal(w http.ResponseWriter, r *http.Request) {
+	h.reviewWithdrawal(w, r, models.ReviewDecisionApproved)
+}
+
+func (h *Handler) RejectWithdrawal(w http.ResponseWriter, r *http.Request) {
+	h.reviewWithdrawal(w, r, models.ReviewDecisionRejected)
+}
+
+func (h *Handler) reviewWithdrawal(w http.ResponseWriter, r *http.Request, decision models.ReviewDecision) {
+	reviewerID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		h.fail(w, r, e.ErrUnauthorized)
+		return
+	}
+
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	// The body is optional when approving, so an empty one is no error.
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		h.fail(w, r, e.ErrInvalidRequest)
+		return
+	}
+	if decision == models.ReviewDecisionRejected && req.Reason == "" {
+		h.fail(w, r, e.InvalidField("reason", "required", "reason is required"))
+		return
+	}
+
+	err := h.service.ReviewWithdrawal(r.Context(), reviewerID, chi.URLParam(r, "order"), decision, req.Reason)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) GetFraudSignals(w http.ResponseWriter, r *http.Request) {
+	userID := 0
+	if value := r.URL.Query().Get("user_id"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			h.fail(w, r, e.InvalidField("user_id", "format", "invalid user_id"))
+			return
+		}
+		userID = parsed
+	}
+	unresolvedOnly := r.URL.Query().Get("all") != "true"
+
+	signals, err := h.service.GetFraudSignals(r.Context(), userID, unresolvedOnly)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+
+	if len(signals) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	type fraudSignalResponse struct {
+		ID         int                `json:"id"`
+		UserID     int                `json:"user_id"`
+		IP         string             `json:"ip"`
+		Rule       string             `json:"rule"`
+		Action     models.FraudAction `json:"action"`
+		Details    string             `json:"details"`
+		CreatedAt  time.Time          `json:"created_at"`
+		ResolvedAt *time.Time         `json:"resolved_at,omitempty"`
+		ResolvedBy *int               `json:"resolved_by,omitempty"`
+		Resolution string             `json:"resolution,omitempty"`
+	}
+
+	response := make([]fraudSignalResponse, 0, len(signals))
+	for _, s := range signals {
+		response = append(response, fraudSignalResponse{
+			ID:         s.ID,
+			UserID:     s.UserID,
+			IP:         s.IP,
+			Rule:       s.Rule,
+			Action:     s.Action,
+			Details:    s.Details,
+			CreatedAt:  s.CreatedAt,
+			ResolvedAt: s.ResolvedAt,
+			ResolvedBy: s.ResolvedBy,
+			Resolution: s.Resolution,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		h.logger.Errorf("encode response failed: %v", err)
+	}
+}
+
+// ResolveFraudSignal closes a signal. Resolving a THROTTLE or BLOCK signal
+// lifts the restriction it placed on the account.
+func (h *Handler) ResolveFraudSignal(w http.ResponseWriter, r *http.Request) {
+	reviewerID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		h.fail(w, r, e.ErrUnauthorized)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		h.fail(w, r, e.InvalidField("id", "format", "invalid signal id"))
+		return
+	}
+
+	var req struct {
+		Resolution string `json:"resolution"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.fail(w, r, e.ErrInvalidRequest)
+		return
+	}
+
+	err = h.service.ResolveFraudSignal(r.Context(), id, reviewerID, req.Resolution)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// UnlockLogin lifts a brute-force lockout for a login, an IP or both.
+func (h *Handler) UnlockLogin(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Login string `json:"login"`
+		IP    string `json:"ip"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.fail(w, r, e.ErrInvalidRequest)
+		return
+	}
+	if req.Login == "" && req.IP == "" {
+		h.fail(w, r, e.InvalidField("login", "required", "login or ip is required"))
+		return
+	}
+
+	if err := h.service.UnlockLogin(r.Context(), req.Login, req.IP); err != nil {
+		h.fail(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,285 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/chestorix/gophermart/internal/api/middleware"
+	e "github.com/chestorix/gophermart/internal/errors"
+	"github.com/chestorix/gophermart/internal/models"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultAdminListLimit = 50
+	maxAdminListLimit     = 500
+)
+
+type adminUserResponse struct {
+	ID         int         `json:"id"`
+	Login      string      `json:"login"`
+	Role       models.Role `json:"role"`
+	DisabledAt *time.Time  `json:"disabled_at,omitempty"`
+	CreatedAt  time.Time   `json:"created_at"`
+}
+
+func newAdminUserResponse(user models.User) adminUserResponse {
+	return adminUserResponse{
+		ID:         user.ID,
+		Login:      user.Login,
+		Role:       user.Role,
+		DisabledAt: user.DisabledAt,
+		CreatedAt:  user.CreatedAt,
+	}
+}
+
+// listLimit reads the limit query parameter, capped at maxAdminListLimit.
+func listLimit(r *http.Request) (int, bool) {
+	value := r.URL.Query().Get("limit")
+	if value == "" {
+		return defaultAdminListLimit, true
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit <= 0 {
+		return 0, false
+	}
+	if limit > maxAdminListLimit {
+		limit = maxAdminListLimit
+	}
+	return limit, true
+}
+
+// targetUserID reads the {id} URL parameter and checks the user exists in
+// the current merchant.
+func (h *Handler) targetUserID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		h.fail(w, r, e.InvalidField("id", "format", "invalid user id"))
+		return 0, false
+	}
+	if _, err = h.service.GetUser(r.Context(), userID); err != nil {
+		h.fail(w, r, err)
+		return 0, false
+	}
+	return userID, true
+}
+
+func (h *Handler) SearchUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+	limit, ok := listLimit(r)
+	if query == "" || !ok {
+		h.fail(w, r, e.ErrInvalidRequest)
+		return
+	}
+
+	users, err := h.service.SearchUsers(r.Context(), query, limit)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+
+	if len(users) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	response := make([]adminUserResponse, 0, len(users))
+	for _, user := range users {
+		response = append(response, newAdminUserResponse(user))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		h.logger.Errorf("encode response failed: %v", err)
+	}
+}
+
+func (h *Handler) GetUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		h.fail(w, r, e.InvalidField("id", "format", "invalid user id"))
+		return
+	}
+
+	user, err := h.service.GetUser(r.Context(), userID)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(newAdminUserResponse(user)); err != nil {
+		h.logger.Errorf("encode response failed: %v", err)
+	}
+}
+
+func (h *Handler) GetUserOrdersAdmin(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.targetUserID(w, r)
+	if !ok {
+		return
+	}
+	h.listOrders(w, r, userID)
+}
+
+func (h *Handler) GetUserWithdrawalsAdmin(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.targetUserID(w, r)
+	if !ok {
+		return
+	}
+	h.listWithdrawals(w, r, userID)
+}
+
+func (h *Handler) GetUserBalanceAdmin(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.targetUserID(w, r)
+	if !ok {
+		return
+	}
+	current, withdrawn, err := h.service.GetUserBalance(r.Context(), userID)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	h.writeBalance(w, current, withdrawn)
+}
+
+func (h *Handler) DisableUser(w http.ResponseWriter, r *http.Request) {
+	h.setUserDisabled(w, r, true)
+}
+
+func (h *Handler) EnableUser(w http.ResponseWriter, r *http.Request) {
+	h.setUserDisabled(w, r, false)
+}
+
+func (h *Handler) setUserDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		h.fail(w, r, e.InvalidField("id", "format", "invalid user id"))
+		return
+	}
+
+	err = h.service.SetUserDisabled(r.Context(), userID, disabled)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) SetUserRole(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		h.fail(w, r, e.InvalidField("id", "format", "invalid user id"))
+		return
+	}
+
+	var req struct {
+		Role models.Role `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.fail(w, r, e.ErrInvalidRequest)
+		return
+	}
+
+	err = h.service.SetUserRole(r.Context(), userID, req.Role)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// RecheckOrder queries the accrual system for one order and answers with
+// the updated order.
+func (h *Handler) RecheckOrder(w http.ResponseWriter, r *http.Request) {
+	order, err := h.service.RecheckOrder(r.Context(), chi.URLParam(r, "number"))
+	switch err {
+	case nil:
+	case e.ErrOrderNotFound, e.ErrOrderNotRegistered:
+		h.fail(w, r, err)
+		return
+	default:
+		h.logger.Errorf("recheck order failed: %v", err)
+		h.fail(w, r, e.ErrAccrualUnavailable)
+		return
+	}
+	middleware.SetAuditTarget(r.Context(), order.UserID)
+
+	response := struct {
+		Number     string             `json:"number"`
+		UserID     int                `json:"user_id"`
+		Status     models.OrderStatus `json:"status"`
+		Accrual    float64            `json:"accrual,omitempty"`
+		UploadedAt time.Time          `json:"uploaded_at"`
+	}{
+		Number:     order.Number,
+		UserID:     order.UserID,
+		Status:     order.Status,
+		Accrual:    order.Accrual,
+		UploadedAt: order.UploadedAt,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		h.logger.Errorf("encode response failed: %v", err)
+	}
+}
+
+func (h *Handler) GetAuditLog(w http.ResponseWriter, r *http.Request) {
+	limit, ok := listLimit(r)
+	if !ok {
+		h.fail(w, r, e.ErrInvalidRequest)
+		return
+	}
+	var actorID int
+	if value := r.URL.Query().Get("actor"); value != "" {
+		var err error
+		if actorID, err = strconv.Atoi(value); err != nil {
+			h.fail(w, r, e.InvalidField("actor", "format", "invalid actor id"))
+			return
+		}
+	}
+
+	entries, err := h.service.GetAuditLog(r.Context(), actorID, limit)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+
+	if len(entries) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	type auditResponse struct {
+		ID        int       `json:"id"`
+		ActorID   int       `json:"actor_id"`
+		Method    string    `json:"method"`
+		Route     string    `json:"route"`
+		Path      string    `json:"path"`
+		Status    int       `json:"status"`
+		IP        string    `json:"ip"`
+		RequestID string    `json:"request_id,omitempty"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+
+	response := make([]auditResponse, 0, len(entries))
+	for _, entry := range entries {
+		response = append(response, auditResponse{
+			ID:        entry.ID,
+			ActorID:   entry.ActorID,
+			Method:    entry.Method,
+			Route:     entry.Route,
+			Path:      entry.Path,
+			Status:    entry.Status,
+			IP:        entry.IP,
+			RequestID: entry.RequestID,
+			CreatedAt: entry.CreatedAt,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		h.logger.Errorf("encode response failed: %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/chestorix/gophermart/internal/api/middleware"
+	"github.com/chestorix/gophermart/internal/api/problem"
+	e "github.com/chestorix/gophermart/internal/errors"
+	"github.com/chestorix/gophermart/internal/models"
+	"net/http"
+	"time"
+)
+
+const (
+	// eventKeepAlive is how often an idle stream gets a comment line, so
+	// that proxies do not close it.
+	eventKeepAlive = 30 * time.Second
+	// eventWriteTimeout bounds one write and flush, so a client that stops
+	// reading does not hold its stream open.
+	eventWriteTimeout = 10 * time.Second
+)
+
+// StreamEvents sends the caller's order status changes and balance updates
+// as Server-Sent Events until the client goes away or its access token
+// expires or is revoked.
+func (h *Handler) StreamEvents(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.TokenClaimsKey).(models.TokenClaims)
+	if !ok {
+		h.fail(w, r, e.ErrUnauthorized)
+		return
+	}
+	if _, ok := w.(http.Flusher); !ok {
+		h.fail(w, r, fmt.Errorf("response writer %T cannot stream", w))
+		return
+	}
+
+	events, unsubscribe := h.service.SubscribeEvents(r.Context(), claims.UserID)
+	defer unsubscribe()
+
+	rc := http.NewResponseController(w)
+	// send writes one chunk within eventWriteTimeout. Writers that cannot
+	// set deadlines, such as test recorders, still stream.
+	send := func(format string, args ...any) bool {
+		if err := rc.SetWriteDeadline(time.Now().Add(eventWriteTimeout)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return false
+		}
+		if _, err := fmt.Fprintf(w, format, args...); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if !send("") {
+		return
+	}
+
+	expiry := time.NewTimer(time.Until(claims.ExpiresAt))
+	defer expiry.Stop()
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-expiry.C:
+			return
+		case <-keepAlive.C:
+			if !h.streamAuthorized(r.Context(), claims) || !send(": keep-alive\n\n") {
+				return
+			}
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			var data any = event.Order
+			if event.Balance != nil {
+				data = event.Balance
+			}
+			payload, err := json.Marshal(data)
+			if err != nil {
+				h.logger.Errorf("encode event failed: %v", err)
+				continue
+			}
+			if !send("event: %s\ndata: %s\n\n", event.Type, payload) {
+				return
+			}
+		}
+	}
+}
+
+// streamAuthorized re-checks the access token of an open stream, so that
+// logout, a revoked session or a disabled or closed account ends it.
+func (h *Handler) streamAuthorized(ctx context.Context, claims models.TokenClaims) bool {
+	err := h.service.RevalidateToken(ctx, claims)
+	if err != nil && !problem.Known(err) {
+		h.logger.Errorf("revalidate stream token failed: %v", err)
+	}
+	return err == nil
+}
@@ -1,15 +1,22 @@
 package api
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"github.com/chestorix/gophermart/internal/api/middleware"
+	"github.com/chestorix/gophermart/internal/api/problem"
 	e "github.com/chestorix/gophermart/internal/errors"
 	"github.com/chestorix/gophermart/internal/interfaces"
 	"github.com/chestorix/gophermart/internal/models"
+	"github.com/go-chi/chi/v5"
+	chimw "github.com/go-chi/chi/v5/middleware"
 	"github.com/sirupsen/logrus"
 	"io"
+	"net"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -17,12 +24,14 @@ type Handler struct {
 	service interfaces.Service
 	logger  *logrus.Logger
 	dbURL   string
+	session middleware.Session
 }
 
 func NewHandler(service interfaces.Service, logger *logrus.Logger, dbURL string) *Handler {
 	return &Handler{service: service,
-		logger: logger,
-		dbURL:  dbURL,
+		logger:  logger,
+		dbURL:   dbURL,
+		session: middleware.Session{Mode: middleware.AuthModeHeader},
 	}
 }
 
@@ -39,28 +48,21 @@ func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid request format", http.StatusBadRequest)
+		h.fail(w, r, e.ErrInvalidRequest)
 		return
 	}
 	if req.Login == "" || req.Password == "" {
-		http.Error(w, "invalid request format", http.StatusBadRequest)
+		h.fail(w, r, e.ErrInvalidRequest)
 		return
 	}
 
-	token, err := h.service.Register(r.Context(), req.Login, req.Password)
+	tokens, err := h.service.Register(r.Context(), req.Login, req.Password)
 	if err != nil {
-		switch err {
-		case e.ErrUserAlreadyExists:
-			http.Error(w, err.Error(), http.StatusConflict)
-		default:
-			h.logger.Errorf("registration failed: %v", err)
-			http.Error(w, "internal server error", http.StatusInternalServerError)
-		}
+		h.fail(w, r, err)
 		return
 	}
 
-	w.Header().Set("Authorization", "Bearer "+token)
-	w.WriteHeader(http.StatusOK)
+	h.writeTokens(w, r, tokens)
 }
 
 func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
@@ -70,80 +72,225 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid request format", http.StatusBadRequest)
+		h.fail(w, r, e.ErrInvalidRequest)
 		return
 	}
 	if req.Login == "" || req.Password == "" {
-		http.Error(w, "invalid request format", http.StatusBadRequest)
+		h.fail(w, r, e.ErrInvalidRequest)
 		return
 	}
 
-	token, err := h.service.Login(r.Context(), req.Login, req.Password)
+	// A correct password of a user with 2FA enabled is answered with 401
+	// and an mfa_token; the client repeats the login at
+	// /api/user/login/mfa with it and a code.
+	tokens, err := h.service.Login(r.Context(), req.Login, req.Password, clientIP(r))
 	if err != nil {
-		switch err {
-		case e.ErrInvalidCredentials:
-			http.Error(w, err.Error(), http.StatusUnauthorized)
-		default:
-			h.logger.Errorf("login failed: %v", err)
-			http.Error(w, "internal server error", http.StatusInternalServerError)
+		h.fail(w, r, err)
+		return
+	}
+
+	h.writeTokens(w, r, tokens)
+}
+
+func (h *Handler) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			h.fail(w, r, e.ErrInvalidRequest)
+			return
 		}
+	}
+	if req.RefreshToken == "" {
+		if req.RefreshToken = h.session.RefreshToken(r); req.RefreshToken != "" && !middleware.ValidCSRF(r) {
+			h.fail(w, r, e.ErrInvalidCSRFToken)
+			return
+		}
+	}
+	if req.RefreshToken == "" {
+		h.fail(w, r, e.ErrInvalidRequest)
+		return
+	}
+
+	tokens, err := h.service.RefreshToken(r.Context(), req.RefreshToken)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+
+	h.writeTokens(w, r, tokens)
+}
+
+func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.TokenClaimsKey).(models.TokenClaims)
+	if !ok {
+		h.fail(w, r, e.ErrUnauthorized)
+		return
+	}
+
+	if err := h.service.Logout(r.Context(), claims); err != nil {
+		h.fail(w, r, err)
 		return
 	}
 
-	w.Header().Set("Authorization", "Bearer "+token)
+	h.session.Clear(w)
 	w.WriteHeader(http.StatusOK)
 }
 
+// writeTokens keeps the access token in the Authorization header, as the
+// original API did, and returns both tokens in the body. In cookie mode
+// the tokens are also set as HttpOnly cookies and the body carries the
+// CSRF token that state-changing requests must echo.
+func (h *Handler) writeTokens(w http.ResponseWriter, r *http.Request, tokens models.TokenPair) {
+	csrf, err := h.session.SetTokens(w, tokens.AccessToken, tokens.RefreshToken, tokens.ExpiresIn, tokens.RefreshExpiresIn)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+
+	response := struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		ExpiresIn    int    `json:"expires_in"`
+		CSRFToken    string `json:"csrf_token,omitempty"`
+	}{
+		AccessToken:  tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresIn:    int(tokens.ExpiresIn.Seconds()),
+		CSRFToken:    csrf,
+	}
+
+	w.Header().Set("Authorization", "Bearer "+tokens.AccessToken)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		h.logger.Errorf("encode response failed: %v", err)
+	}
+}
+
 func (h *Handler) UploadOrder(w http.ResponseWriter, r *http.Request) {
 	if r.Header.Get("Content-Type") != "text/plain" {
-		http.Error(w, "invalid request format", http.StatusBadRequest)
+		h.fail(w, r, e.ErrInvalidRequest)
+		return
 	}
 	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
 	if !ok {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		h.fail(w, r, e.ErrUnauthorized)
 		return
 	}
 
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, "cannot read request body", http.StatusBadRequest)
+		h.fail(w, r, e.ErrInvalidRequest)
 		return
 	}
 	defer r.Body.Close()
 
 	orderNumber := string(body)
 	if orderNumber == "" {
-		http.Error(w, "empty order number", http.StatusBadRequest)
+		h.fail(w, r, e.InvalidField("number", "required", "empty order number"))
 		return
 	}
 
-	err = h.service.UploadOrder(r.Context(), userID, orderNumber)
+	err = h.service.UploadOrder(r.Context(), userID, orderNumber, clientIP(r))
 	switch err {
 	case nil:
 		w.WriteHeader(http.StatusAccepted)
 	case e.ErrOrderAlreadyUploadedByUser:
 		w.WriteHeader(http.StatusOK)
-	case e.ErrOrderAlreadyUploadedByAnotherUser:
-		http.Error(w, err.Error(), http.StatusConflict)
-	case e.ErrInvalidOrderNumber:
-		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
 	default:
-		h.logger.Errorf("upload order failed: %v", err)
-		http.Error(w, "internal server error", http.StatusInternalServerError)
+		h.fail(w, r, err)
 	}
 }
 func (h *Handler) GetUserOrders(w http.ResponseWriter, r *http.Request) {
 	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
 	if !ok {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		h.fail(w, r, e.ErrUnauthorized)
+		return
+	}
+	h.listOrders(w, r, userID)
+}
+
+// GetUserOrder returns one of the caller's orders. The weak ETag covers
+// status and accrual, so clients polling with If-None-Match get 304 until
+// the order changes.
+func (h *Handler) GetUserOrder(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		h.fail(w, r, e.ErrUnauthorized)
+		return
+	}
+
+	order, err := h.service.GetUserOrder(r.Context(), userID, chi.URLParam(r, "number"))
+	if err != nil {
+		h.fail(w, r, err)
 		return
 	}
-	orders, err := h.service.GetUserOrders(r.Context(), userID)
+
+	etag := orderETag(order)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	response := struct {
+		Number     string             `json:"number"`
+		Status     models.OrderStatus `json:"status"`
+		Accrual    float64            `json:"accrual,omitempty"`
+		UploadedAt time.Time          `json:"uploaded_at"`
+		UpdatedAt  time.Time          `json:"updated_at"`
+	}{
+		Number:     order.Number,
+		Status:     order.Status,
+		Accrual:    order.Accrual,
+		UploadedAt: order.UploadedAt,
+		UpdatedAt:  order.UpdatedAt,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		h.logger.Errorf("encode response failed: %v", err)
+	}
+}
+
+func orderETag(order models.Order) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%.2f", order.Number, order.Status, order.Accrual)))
+	return `W/"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// etagMatches reports whether an If-None-Match header lists etag. Weak
+// comparison is used, as RFC 9110 requires for If-None-Match.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// listOrders answers with the page of the user's orders the request asks for.
+func (h *Handler) listOrders(w http.ResponseWriter, r *http.Request, userID int) {
+	q, err := parsePageQuery(r, orderStatuses...)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	orders, next, err := h.service.GetUserOrders(r.Context(), userID, q)
 	if err != nil {
-		h.logger.Errorf("get user orders failed: %v", err)
-		http.Error(w, "internal server error", http.StatusInternalServerError)
+		h.fail(w, r, err)
 		return
 	}
+	writeNextCursor(w, next)
+	h.writeOrders(w, orders)
+}
+
+func (h *Handler) writeOrders(w http.ResponseWriter, orders []models.Order) {
 	if len(orders) == 0 {
 		w.WriteHeader(http.StatusNoContent)
 		return
@@ -168,24 +315,25 @@ func (h *Handler) GetUserOrders(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(response); err != nil {
 		h.logger.Errorf("encode response failed: %v", err)
-		http.Error(w, "internal server error", http.StatusInternalServerError)
 	}
 }
 
 func (h *Handler) GetUserBalance(w http.ResponseWriter, r *http.Request) {
 	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
 	if !ok {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		h.fail(w, r, e.ErrUnauthorized)
 		return
 	}
 
 	current, withdrawn, err := h.service.GetUserBalance(r.Context(), userID)
 	if err != nil {
-		h.logger.Errorf("get user balance failed: %v", err)
-		http.Error(w, "internal server error", http.StatusInternalServerError)
+		h.fail(w, r, err)
 		return
 	}
+	h.writeBalance(w, current, withdrawn)
+}
 
+func (h *Handler) writeBalance(w http.ResponseWriter, current, withdrawn float64) {
 	response := struct {
 		Current   float64 `json:"current"`
 		Withdrawn float64 `json:"withdrawn"`
@@ -197,62 +345,76 @@ func (h *Handler) GetUserBalance(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(response); err != nil {
 		h.logger.Errorf("encode response failed: %v", err)
-		http.Error(w, "internal server error", http.StatusInternalServerError)
 	}
 }
 
 func (h *Handler) Withdraw(w http.ResponseWriter, r *http.Request) {
 	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
 	if !ok {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		h.fail(w, r, e.ErrUnauthorized)
 		return
 	}
 
 	var req struct {
 		Order string  `json:"order"`
 		Sum   float64 `json:"sum"`
+		// MFACode is a TOTP or recovery code, needed for large withdrawals.
+		MFACode string `json:"mfa_code"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid request format", http.StatusBadRequest)
+		h.fail(w, r, e.ErrInvalidRequest)
 		return
 	}
 
-	err := h.service.Withdraw(r.Context(), userID, req.Order, req.Sum)
-	switch err {
-	case nil:
-		w.WriteHeader(http.StatusOK)
-	case e.ErrInsufficientFunds:
-		http.Error(w, err.Error(), http.StatusPaymentRequired)
-	case e.ErrInvalidOrderNumber:
-		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
-	default:
-		h.logger.Errorf("withdraw failed: %v", err)
-		http.Error(w, "internal server error", http.StatusInternalServerError)
+	status, err := h.service.Withdraw(r.Context(), userID, req.Order, req.Sum, req.MFACode)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	if status == models.WithdrawalStatusPendingReview {
+		w.WriteHeader(http.StatusAccepted)
+		return
 	}
+	w.WriteHeader(http.StatusOK)
 }
 func (h *Handler) GetUserWithdrawals(w http.ResponseWriter, r *http.Request) {
 	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
 	if !ok {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		h.fail(w, r, e.ErrUnauthorized)
 		return
 	}
 
-	withdrawals, err := h.service.GetUserWithdrawals(r.Context(), userID)
+	h.listWithdrawals(w, r, userID)
+}
+
+// listWithdrawals answers with the page of the user's withdrawals the
+// request asks for.
+func (h *Handler) listWithdrawals(w http.ResponseWriter, r *http.Request, userID int) {
+	q, err := parsePageQuery(r, withdrawalStatuses...)
 	if err != nil {
-		h.logger.Errorf("get user withdrawals failed: %v", err)
-		http.Error(w, "internal server error", http.StatusInternalServerError)
+		h.fail(w, r, err)
 		return
 	}
+	withdrawals, next, err := h.service.GetUserWithdrawals(r.Context(), userID, q)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	writeNextCursor(w, next)
+	h.writeWithdrawals(w, withdrawals)
+}
 
+func (h *Handler) writeWithdrawals(w http.ResponseWriter, withdrawals []models.Withdrawal) {
 	if len(withdrawals) == 0 {
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
 
 	type withdrawalResponse struct {
-		Order       string    `json:"order"`
-		Sum         float64   `json:"sum"`
-		ProcessedAt time.Time `json:"processed_at"`
+		Order       string                  `json:"order"`
+		Sum         float64                 `json:"sum"`
+		Status      models.WithdrawalStatus `json:"status"`
+		ProcessedAt time.Time               `json:"processed_at"`
 	}
 
 	response := make([]withdrawalResponse, 0, len(withdrawals))
@@ -260,6 +422,7 @@ func (h *Handler) GetUserWithdrawals(w http.ResponseWriter, r *http.Request) {
 		response = append(response, withdrawalResponse{
 			Order:       w.Order,
 			Sum:         w.Sum,
+			Status:      w.Status,
 			ProcessedAt: w.ProcessedAt,
 		})
 	}
@@ -267,6 +430,25 @@ func (h *Handler) GetUserWithdrawals(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(response); err != nil {
 		h.logger.Errorf("encode response failed: %v", err)
-		http.Error(w, "internal server error", http.StatusInternalServerError)
 	}
 }
+
+// clientIP returns the caller address without the port. RealIP has already
+// replaced RemoteAddr with X-Forwarded-For / X-Real-IP when present.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// fail answers err as a problem. Errors without a mapping are internal:
+// they are logged and answered with a bare 500.
+func (h *Handler) fail(w http.ResponseWriter, r *http.Request, err error) {
+	if !problem.Known(err) {
+		h.logger.WithField("request_id", chimw.GetReqID(r.Context())).
+			Errorf("%s %s failed: %v", r.Method, r.URL.Path, err)
+	}
+	problem.Write(w, r, err)
+}
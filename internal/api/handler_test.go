@@ -1,11 +1,19 @@
 package api
 
 import (
+	"archive/zip"
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/chestorix/gophermart/internal/api/middleware"
+	"github.com/chestorix/gophermart/internal/api/problem"
 	e "github.com/chestorix/gophermart/internal/errors"
 	"github.com/chestorix/gophermart/internal/models"
+	"github.com/go-chi/chi/v5"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/net/websocket"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -15,99 +23,328 @@ import (
 )
 
 type mockService struct {
-	registerFn           func(ctx context.Context, login, password string) (string, error)
-	loginFn              func(ctx context.Context, login, password string) (string, error)
-	uploadOrderFn        func(ctx context.Context, userID int, orderNumber string) error
-	getUserOrdersFn      func(ctx context.Context, userID int) ([]models.Order, error)
+	registerFn           func(ctx context.Context, login, password string) (models.TokenPair, error)
+	loginFn              func(ctx context.Context, login, password, ip string) (models.TokenPair, error)
+	refreshTokenFn       func(ctx context.Context, refreshToken string) (models.TokenPair, error)
+	changePasswordFn     func(ctx context.Context, claims models.TokenClaims, currentPassword, newPassword string) (models.TokenPair, error)
+	uploadOrderFn        func(ctx context.Context, userID int, orderNumber, ip string) error
+	uploadOrdersFn       func(ctx context.Context, userID int, numbers []string, ip string) ([]models.OrderUploadItem, error)
+	subscribeEventsFn    func(ctx context.Context, userID int) (<-chan models.UserEvent, func())
+	connectWebSocketFn   func(ctx context.Context, userID int) (<-chan models.UserEvent, func(), error)
+	getUserOrdersFn      func(ctx context.Context, userID int, q models.PageQuery) ([]models.Order, *models.PageCursor, error)
 	getUserBalanceFn     func(ctx context.Context, userID int) (current, withdrawn float64, err error)
-	withdrawFn           func(ctx context.Context, userID int, orderNumber string, sum float64) error
-	getUserWithdrawalsFn func(ctx context.Context, userID int) ([]models.Withdrawal, error)
-	validateTokenFn      func(tokenString string) (string, error)
+	withdrawFn           func(ctx context.Context, userID int, orderNumber string, sum float64, mfaCode string) (models.WithdrawalStatus, error)
+	getUserWithdrawalsFn func(ctx context.Context, userID int, q models.PageQuery) ([]models.Withdrawal, *models.PageCursor, error)
+	validateTokenFn      func(ctx context.Context, tokenString string) (models.TokenClaims, error)
 	getUserByLoginFn     func(ctx context.Context, login string) (models.User, error)
+	userRoleFn           func(ctx context.Context, userID int) (models.Role, error)
+	setUserDisabledFn    func(ctx context.Context, userID int, disabled bool) error
+	redeemVoucherFn      func(ctx context.Context, userID int, code string) (models.Voucher, error)
+	reviewWithdrawalFn   func(ctx context.Context, reviewerID int, orderNumber string, decision models.ReviewDecision, reason string) error
+	authenticateAPIKeyFn func(ctx context.Context, key string) (models.APIKey, error)
+	createAdjustmentFn   func(ctx context.Context, adj models.BalanceAdjustment) (models.BalanceAdjustment, error)
+	exportUserDataFn     func(ctx context.Context, userID int) (models.UserExport, error)
+	closeAccountFn       func(ctx context.Context, userID int, password, mfaCode string) error
+	touchSessionFn       func(ctx context.Context, claims models.TokenClaims) error
+	revalidateTokenFn    func(ctx context.Context, claims models.TokenClaims) error
+	getUserOrderFn       func(ctx context.Context, userID int, orderNumber string) (models.Order, error)
+
+	audit []models.AuditEntry
 }
 
 func (m *mockService) Test() string {
 	return "test"
 }
 
-func (m *mockService) Register(ctx context.Context, login, password string) (string, error) {
+func (m *mockService) Register(ctx context.Context, login, password string) (models.TokenPair, error) {
 	return m.registerFn(ctx, login, password)
 }
 
-func (m *mockService) Login(ctx context.Context, login, password string) (string, error) {
-	return m.loginFn(ctx, login, password)
+func (m *mockService) Login(ctx context.Context, login, password, ip string) (models.TokenPair, error) {
+	return m.loginFn(ctx, login, password, ip)
+}
+
+func (m *mockService) UnlockLogin(ctx context.Context, login, ip string) error {
+	return nil
+}
+
+func (m *mockService) EnrollTOTP(ctx context.Context, userID int) (models.TOTPEnrollment, error) {
+	return models.TOTPEnrollment{}, nil
+}
+
+func (m *mockService) ConfirmTOTP(ctx context.Context, userID int, code string) ([]string, error) {
+	return nil, nil
+}
+
+func (m *mockService) DisableTOTP(ctx context.Context, userID int, code string) error {
+	return nil
+}
+
+func (m *mockService) RegenerateRecoveryCodes(ctx context.Context, userID int, code string) ([]string, error) {
+	return nil, nil
+}
+
+func (m *mockService) CompleteMFALogin(ctx context.Context, mfaToken, code, ip string) (models.TokenPair, error) {
+	return models.TokenPair{}, nil
+}
+
+func (m *mockService) CreateAPIKey(ctx context.Context, creatorID int, name string, scopes []string) (string, models.APIKey, error) {
+	return "", models.APIKey{}, nil
+}
+
+func (m *mockService) GetAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	return nil, nil
+}
+
+func (m *mockService) RevokeAPIKey(ctx context.Context, id int) error {
+	return nil
+}
+
+func (m *mockService) AuthenticateAPIKey(ctx context.Context, key string) (models.APIKey, error) {
+	return m.authenticateAPIKeyFn(ctx, key)
+}
+
+func (m *mockService) RefreshToken(ctx context.Context, refreshToken string) (models.TokenPair, error) {
+	return m.refreshTokenFn(ctx, refreshToken)
+}
+
+func (m *mockService) Logout(ctx context.Context, claims models.TokenClaims) error {
+	return nil
+}
+
+func (m *mockService) ChangePassword(ctx context.Context, claims models.TokenClaims, currentPassword, newPassword string) (models.TokenPair, error) {
+	return m.changePasswordFn(ctx, claims, currentPassword, newPassword)
+}
+
+func (m *mockService) RequestPasswordReset(ctx context.Context, login string) error {
+	return nil
+}
+
+func (m *mockService) ResetPassword(ctx context.Context, token, newPassword string) error {
+	return nil
+}
+
+func (m *mockService) UploadOrder(ctx context.Context, userID int, orderNumber, ip string) error {
+	return m.uploadOrderFn(ctx, userID, orderNumber, ip)
+}
+
+func (m *mockService) UploadOrders(ctx context.Context, userID int, numbers []string, ip string) ([]models.OrderUploadItem, error) {
+	return m.uploadOrdersFn(ctx, userID, numbers, ip)
+}
+
+func (m *mockService) SubscribeEvents(ctx context.Context, userID int) (<-chan models.UserEvent, func()) {
+	return m.subscribeEventsFn(ctx, userID)
+}
+
+func (m *mockService) ConnectWebSocket(ctx context.Context, userID int) (<-chan models.UserEvent, func(), error) {
+	return m.connectWebSocketFn(ctx, userID)
 }
 
-func (m *mockService) UploadOrder(ctx context.Context, userID int, orderNumber string) error {
-	return m.uploadOrderFn(ctx, userID, orderNumber)
+func (m *mockService) GetUserOrder(ctx context.Context, userID int, orderNumber string) (models.Order, error) {
+	return m.getUserOrderFn(ctx, userID, orderNumber)
 }
 
-func (m *mockService) GetUserOrders(ctx context.Context, userID int) ([]models.Order, error) {
-	return m.getUserOrdersFn(ctx, userID)
+func (m *mockService) GetUserOrders(ctx context.Context, userID int, q models.PageQuery) ([]models.Order, *models.PageCursor, error) {
+	return m.getUserOrdersFn(ctx, userID, q)
 }
 
 func (m *mockService) GetUserBalance(ctx context.Context, userID int) (current, withdrawn float64, err error) {
 	return m.getUserBalanceFn(ctx, userID)
 }
 
-func (m *mockService) Withdraw(ctx context.Context, userID int, orderNumber string, sum float64) error {
-	return m.withdrawFn(ctx, userID, orderNumber, sum)
+func (m *mockService) Withdraw(ctx context.Context, userID int, orderNumber string, sum float64, mfaCode string) (models.WithdrawalStatus, error) {
+	return m.withdrawFn(ctx, userID, orderNumber, sum, mfaCode)
+}
+
+func (m *mockService) GetUserWithdrawals(ctx context.Context, userID int, q models.PageQuery) ([]models.Withdrawal, *models.PageCursor, error) {
+	return m.getUserWithdrawalsFn(ctx, userID, q)
+}
+
+func (m *mockService) ValidateToken(ctx context.Context, tokenString string) (models.TokenClaims, error) {
+	return m.validateTokenFn(ctx, tokenString)
+}
+
+func (m *mockService) TouchSession(ctx context.Context, claims models.TokenClaims) error {
+	if m.touchSessionFn == nil {
+		return nil
+	}
+	return m.touchSessionFn(ctx, claims)
+}
+
+func (m *mockService) RevalidateToken(ctx context.Context, claims models.TokenClaims) error {
+	if m.revalidateTokenFn == nil {
+		return nil
+	}
+	return m.revalidateTokenFn(ctx, claims)
+}
+
+func (m *mockService) GetSessions(ctx context.Context, userID int) ([]models.Session, error) {
+	return nil, nil
 }
 
-func (m *mockService) GetUserWithdrawals(ctx context.Context, userID int) ([]models.Withdrawal, error) {
-	return m.getUserWithdrawalsFn(ctx, userID)
+func (m *mockService) RevokeSession(ctx context.Context, userID int, id string) error {
+	return nil
 }
 
-func (m *mockService) ValidateToken(tokenString string) (string, error) {
-	return m.validateTokenFn(tokenString)
+func (m *mockService) ResolveMerchant(ctx context.Context, code, host string) (models.Merchant, error) {
+	return models.Merchant{ID: 1, Code: "default"}, nil
 }
 
 func (m *mockService) GetUserByLogin(ctx context.Context, login string) (models.User, error) {
 	return m.getUserByLoginFn(ctx, login)
 }
 
+func (m *mockService) ExportUserData(ctx context.Context, userID int) (models.UserExport, error) {
+	return m.exportUserDataFn(ctx, userID)
+}
+
+func (m *mockService) CloseAccount(ctx context.Context, userID int, password, mfaCode string) error {
+	return m.closeAccountFn(ctx, userID, password, mfaCode)
+}
+
+func (m *mockService) UserRole(ctx context.Context, userID int) (models.Role, error) {
+	return m.userRoleFn(ctx, userID)
+}
+
+func (m *mockService) GetUser(ctx context.Context, userID int) (models.User, error) {
+	return models.User{ID: userID}, nil
+}
+
+func (m *mockService) SearchUsers(ctx context.Context, query string, limit int) ([]models.User, error) {
+	return nil, nil
+}
+
+func (m *mockService) SetUserRole(ctx context.Context, userID int, role models.Role) error {
+	return nil
+}
+
+func (m *mockService) SetUserDisabled(ctx context.Context, userID int, disabled bool) error {
+	return m.setUserDisabledFn(ctx, userID, disabled)
+}
+
+func (m *mockService) RecheckOrder(ctx context.Context, orderNumber string) (models.Order, error) {
+	return models.Order{}, nil
+}
+
+func (m *mockService) RecordAudit(ctx context.Context, entry models.AuditEntry) error {
+	m.audit = append(m.audit, entry)
+	return nil
+}
+
+func (m *mockService) GetAuditLog(ctx context.Context, actorID, limit int) ([]models.AuditEntry, error) {
+	return nil, nil
+}
+
+func (m *mockService) GetPendingWithdrawals(ctx context.Context) ([]models.Withdrawal, error) {
+	return nil, nil
+}
+
+func (m *mockService) ReviewWithdrawal(ctx context.Context, reviewerID int, orderNumber string, decision models.ReviewDecision, reason string) error {
+	return m.reviewWithdrawalFn(ctx, reviewerID, orderNumber, decision, reason)
+}
+
+func (m *mockService) GetFraudSignals(ctx context.Context, userID int, unresolvedOnly bool) ([]models.FraudSignal, error) {
+	return nil, nil
+}
+
+func (m *mockService) ResolveFraudSignal(ctx context.Context, id, reviewerID int, resolution string) error {
+	return nil
+}
+
+func (m *mockService) CreateAdjustment(ctx context.Context, adj models.BalanceAdjustment) (models.BalanceAdjustment, error) {
+	return m.createAdjustmentFn(ctx, adj)
+}
+
+func (m *mockService) GetUserAdjustments(ctx context.Context, userID int) ([]models.BalanceAdjustment, error) {
+	return nil, nil
+}
+
+func (m *mockService) GetPendingAdjustments(ctx context.Context) ([]models.BalanceAdjustment, error) {
+	return nil, nil
+}
+
+func (m *mockService) ReviewAdjustment(ctx context.Context, id, reviewerID int, decision models.ReviewDecision) (models.BalanceAdjustment, error) {
+	return models.BalanceAdjustment{}, nil
+}
+
+func (m *mockService) GetUserHistory(ctx context.Context, userID int) ([]models.HistoryEntry, error) {
+	return nil, nil
+}
+
+func (m *mockService) GenerateVouchers(ctx context.Context, creatorID, count int, amount float64, expiresAt *time.Time) (string, []models.Voucher, error) {
+	return "", nil, nil
+}
+
+func (m *mockService) GetVoucherBatch(ctx context.Context, batchID string) ([]models.Voucher, error) {
+	return nil, nil
+}
+
+func (m *mockService) RedeemVoucher(ctx context.Context, userID int, code string) (models.Voucher, error) {
+	return m.redeemVoucherFn(ctx, userID, code)
+}
+
+func (m *mockService) GetUserVouchers(ctx context.Context, userID int) ([]models.Voucher, error) {
+	return nil, nil
+}
+
 func TestHandler_Register(t *testing.T) {
 	tests := []struct {
 		name           string
 		requestBody    string
-		mockRegister   func(ctx context.Context, login, password string) (string, error)
+		mockRegister   func(ctx context.Context, login, password string) (models.TokenPair, error)
 		expectedStatus int
 		expectedBody   string
 	}{
 		{
 			name:        "successful registration",
 			requestBody: `{"login": "user1", "password": "pass123"}`,
-			mockRegister: func(ctx context.Context, login, password string) (string, error) {
-				return "token123", nil
+			mockRegister: func(ctx context.Context, login, password string) (models.TokenPair, error) {
+				return models.TokenPair{AccessToken: "token123", RefreshToken: "refresh123"}, nil
 			},
 			expectedStatus: http.StatusOK,
 		},
 		{
 			name:        "user already exists",
 			requestBody: `{"login": "user1", "password": "pass123"}`,
-			mockRegister: func(ctx context.Context, login, password string) (string, error) {
-				return "", e.ErrUserAlreadyExists
+			mockRegister: func(ctx context.Context, login, password string) (models.TokenPair, error) {
+				return models.TokenPair{}, e.ErrUserAlreadyExists
 			},
 			expectedStatus: http.StatusConflict,
-			expectedBody:   e.ErrUserAlreadyExists.Error() + "\n",
+			expectedBody:   `{"type":"about:blank","title":"Conflict","status":409,"detail":"user already exists","instance":"/api/user/register","code":"user_already_exists"}` + "\n",
 		},
 		{
 			name:        "invalid request body",
 			requestBody: `invalid json`,
-			mockRegister: func(ctx context.Context, login, password string) (string, error) {
-				return "", nil
+			mockRegister: func(ctx context.Context, login, password string) (models.TokenPair, error) {
+				return models.TokenPair{}, nil
 			},
 			expectedStatus: http.StatusBadRequest,
-			expectedBody:   "invalid request format\n",
+			expectedBody:   `{"type":"about:blank","title":"Bad Request","status":400,"detail":"invalid request format","instance":"/api/user/register","code":"invalid_request"}` + "\n",
 		},
 		{
 			name:        "empty login or password",
 			requestBody: `{"login": "", "password": "pass123"}`,
-			mockRegister: func(ctx context.Context, login, password string) (string, error) {
-				return "", nil
+			mockRegister: func(ctx context.Context, login, password string) (models.TokenPair, error) {
+				return models.TokenPair{}, nil
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"type":"about:blank","title":"Bad Request","status":400,"detail":"invalid request format","instance":"/api/user/register","code":"invalid_request"}` + "\n",
+		},
+		{
+			name:        "policy violations",
+			requestBody: `{"login": "a", "password": "a"}`,
+			mockRegister: func(ctx context.Context, login, password string) (models.TokenPair, error) {
+				return models.TokenPair{}, &e.PolicyError{Violations: []e.PolicyViolation{
+					{Field: "login", Rule: "login_length", Message: "too short"},
+					{Field: "password", Rule: "password_length", Message: "too short"},
+				}}
 			},
 			expectedStatus: http.StatusBadRequest,
-			expectedBody:   "invalid request format\n",
+			expectedBody: `{"type":"about:blank","title":"Bad Request","status":400,` +
+				`"detail":"credentials do not meet the policy: login_length, password_length",` +
+				`"instance":"/api/user/register","code":"policy_violation","errors":[` +
+				`{"field":"login","rule":"login_length","message":"too short"},` +
+				`{"field":"password","rule":"password_length","message":"too short"}]}` + "\n",
 		},
 	}
 
@@ -131,6 +368,9 @@ func TestHandler_Register(t *testing.T) {
 			if resp.StatusCode != tt.expectedStatus {
 				t.Errorf("expected status %d, got %d", tt.expectedStatus, resp.StatusCode)
 			}
+			if ct := resp.Header.Get("Content-Type"); tt.expectedStatus >= 400 && ct != problem.ContentType {
+				t.Errorf("expected Content-Type %q, got %q", problem.ContentType, ct)
+			}
 
 			if tt.expectedBody != "" {
 				body, _ := io.ReadAll(resp.Body)
@@ -151,28 +391,48 @@ func TestHandler_Register(t *testing.T) {
 
 func TestHandler_Login(t *testing.T) {
 	tests := []struct {
-		name           string
-		requestBody    string
-		mockLogin      func(ctx context.Context, login, password string) (string, error)
-		expectedStatus int
-		expectedBody   string
+		name               string
+		requestBody        string
+		mockLogin          func(ctx context.Context, login, password, ip string) (models.TokenPair, error)
+		expectedStatus     int
+		expectedBody       string
+		expectedRetryAfter string
 	}{
 		{
 			name:        "successful login",
 			requestBody: `{"login": "user1", "password": "pass123"}`,
-			mockLogin: func(ctx context.Context, login, password string) (string, error) {
-				return "token123", nil
+			mockLogin: func(ctx context.Context, login, password, ip string) (models.TokenPair, error) {
+				return models.TokenPair{AccessToken: "token123", RefreshToken: "refresh123"}, nil
 			},
 			expectedStatus: http.StatusOK,
 		},
 		{
 			name:        "invalid credentials",
 			requestBody: `{"login": "user1", "password": "wrongpass"}`,
-			mockLogin: func(ctx context.Context, login, password string) (string, error) {
-				return "", e.ErrInvalidCredentials
+			mockLogin: func(ctx context.Context, login, password, ip string) (models.TokenPair, error) {
+				return models.TokenPair{}, e.ErrInvalidCredentials
+			},
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   `{"type":"about:blank","title":"Unauthorized","status":401,"detail":"invalid credentials","instance":"/api/user/login","code":"invalid_credentials"}` + "\n",
+		},
+		{
+			name:        "locked out",
+			requestBody: `{"login": "user1", "password": "wrongpass"}`,
+			mockLogin: func(ctx context.Context, login, password, ip string) (models.TokenPair, error) {
+				return models.TokenPair{}, &e.RetryAfterError{Err: e.ErrTooManyLoginAttempts, RetryAfter: 1500 * time.Millisecond}
+			},
+			expectedStatus:     http.StatusTooManyRequests,
+			expectedRetryAfter: "2",
+		},
+		{
+			name:        "two-factor challenge",
+			requestBody: `{"login": "user1", "password": "pass123"}`,
+			mockLogin: func(ctx context.Context, login, password, ip string) (models.TokenPair, error) {
+				return models.TokenPair{}, &e.MFAChallengeError{Token: "challenge", ExpiresIn: 5 * time.Minute}
 			},
 			expectedStatus: http.StatusUnauthorized,
-			expectedBody:   e.ErrInvalidCredentials.Error() + "\n",
+			expectedBody: `{"type":"about:blank","title":"Unauthorized","status":401,"detail":"two-factor code required",` +
+				`"instance":"/api/user/login","code":"mfa_required","mfa_token":"challenge","expires_in":300}` + "\n",
 		},
 	}
 
@@ -197,6 +457,10 @@ func TestHandler_Login(t *testing.T) {
 				t.Errorf("expected status %d, got %d", tt.expectedStatus, resp.StatusCode)
 			}
 
+			if retryAfter := resp.Header.Get("Retry-After"); retryAfter != tt.expectedRetryAfter {
+				t.Errorf("expected Retry-After %q, got %q", tt.expectedRetryAfter, retryAfter)
+			}
+
 			if tt.expectedBody != "" {
 				body, _ := io.ReadAll(resp.Body)
 				if string(body) != tt.expectedBody {
@@ -219,7 +483,7 @@ func TestHandler_UploadOrder(t *testing.T) {
 		name            string
 		contentType     string
 		orderNumber     string
-		mockUploadOrder func(ctx context.Context, userID int, orderNumber string) error
+		mockUploadOrder func(ctx context.Context, userID int, orderNumber, ip string) error
 		expectedStatus  int
 		expectedBody    string
 	}{
@@ -227,7 +491,7 @@ func TestHandler_UploadOrder(t *testing.T) {
 			name:        "successful upload",
 			contentType: "text/plain",
 			orderNumber: "1234567890",
-			mockUploadOrder: func(ctx context.Context, userID int, orderNumber string) error {
+			mockUploadOrder: func(ctx context.Context, userID int, orderNumber, ip string) error {
 				return nil
 			},
 			expectedStatus: http.StatusAccepted,
@@ -236,17 +500,17 @@ func TestHandler_UploadOrder(t *testing.T) {
 			name:        "invalid content type",
 			contentType: "application/json",
 			orderNumber: "1234567890",
-			mockUploadOrder: func(ctx context.Context, userID int, orderNumber string) error {
+			mockUploadOrder: func(ctx context.Context, userID int, orderNumber, ip string) error {
 				return nil
 			},
 			expectedStatus: http.StatusBadRequest,
-			expectedBody:   "invalid request format\n",
+			expectedBody:   `{"type":"about:blank","title":"Bad Request","status":400,"detail":"invalid request format","instance":"/api/user/orders","code":"invalid_request"}` + "\n",
 		},
 		{
 			name:        "order already uploaded by user",
 			contentType: "text/plain",
 			orderNumber: "1234567890",
-			mockUploadOrder: func(ctx context.Context, userID int, orderNumber string) error {
+			mockUploadOrder: func(ctx context.Context, userID int, orderNumber, ip string) error {
 				return e.ErrOrderAlreadyUploadedByUser
 			},
 			expectedStatus: http.StatusOK,
@@ -255,21 +519,39 @@ func TestHandler_UploadOrder(t *testing.T) {
 			name:        "order already uploaded by another user",
 			contentType: "text/plain",
 			orderNumber: "1234567890",
-			mockUploadOrder: func(ctx context.Context, userID int, orderNumber string) error {
+			mockUploadOrder: func(ctx context.Context, userID int, orderNumber, ip string) error {
 				return e.ErrOrderAlreadyUploadedByAnotherUser
 			},
 			expectedStatus: http.StatusConflict,
-			expectedBody:   e.ErrOrderAlreadyUploadedByAnotherUser.Error() + "\n",
+			expectedBody:   `{"type":"about:blank","title":"Conflict","status":409,"detail":"order already uploaded by another user","instance":"/api/user/orders","code":"order_already_uploaded_by_another_user"}` + "\n",
 		},
 		{
 			name:        "invalid order number",
 			contentType: "text/plain",
 			orderNumber: "invalid",
-			mockUploadOrder: func(ctx context.Context, userID int, orderNumber string) error {
+			mockUploadOrder: func(ctx context.Context, userID int, orderNumber, ip string) error {
 				return e.ErrInvalidOrderNumber
 			},
 			expectedStatus: http.StatusUnprocessableEntity,
-			expectedBody:   e.ErrInvalidOrderNumber.Error() + "\n",
+			expectedBody:   `{"type":"about:blank","title":"Unprocessable Entity","status":422,"detail":"invalid order number","instance":"/api/user/orders","code":"invalid_order_number"}` + "\n",
+		},
+		{
+			name:        "throttled by fraud rules",
+			contentType: "text/plain",
+			orderNumber: "1234567890",
+			mockUploadOrder: func(ctx context.Context, userID int, orderNumber, ip string) error {
+				return e.ErrTooManyRequests
+			},
+			expectedStatus: http.StatusTooManyRequests,
+		},
+		{
+			name:        "blocked by fraud rules",
+			contentType: "text/plain",
+			orderNumber: "1234567890",
+			mockUploadOrder: func(ctx context.Context, userID int, orderNumber, ip string) error {
+				return e.ErrAccountBlocked
+			},
+			expectedStatus: http.StatusForbidden,
 		},
 	}
 
@@ -277,8 +559,8 @@ func TestHandler_UploadOrder(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			service := &mockService{
 				uploadOrderFn: tt.mockUploadOrder,
-				validateTokenFn: func(tokenString string) (string, error) {
-					return "user1", nil
+				validateTokenFn: func(ctx context.Context, tokenString string) (models.TokenClaims, error) {
+					return models.TokenClaims{Login: "user1"}, nil
 				},
 				getUserByLoginFn: func(ctx context.Context, login string) (models.User, error) {
 					return models.User{ID: 1}, nil
@@ -318,13 +600,15 @@ func TestHandler_GetUserOrders(t *testing.T) {
 	now := time.Now()
 	tests := []struct {
 		name              string
-		mockGetUserOrders func(ctx context.Context, userID int) ([]models.Order, error)
+		query             string
+		mockGetUserOrders func(ctx context.Context, userID int, q models.PageQuery) ([]models.Order, *models.PageCursor, error)
 		expectedStatus    int
 		expectedBody      string
+		expectedCursor    string
 	}{
 		{
 			name: "successful get orders",
-			mockGetUserOrders: func(ctx context.Context, userID int) ([]models.Order, error) {
+			mockGetUserOrders: func(ctx context.Context, userID int, q models.PageQuery) ([]models.Order, *models.PageCursor, error) {
 				return []models.Order{
 					{
 						Number:     "1234567890",
@@ -332,7 +616,7 @@ func TestHandler_GetUserOrders(t *testing.T) {
 						Accrual:    100.5,
 						UploadedAt: now,
 					},
-				}, nil
+				}, nil, nil
 			},
 			expectedStatus: http.StatusOK,
 			expectedBody: `[{"number":"1234567890","status":"PROCESSED","accrual":100.5,"uploaded_at":"` + now.Format(time.RFC3339Nano) + `"}]
@@ -340,19 +624,57 @@ func TestHandler_GetUserOrders(t *testing.T) {
 		},
 		{
 			name: "no orders",
-			mockGetUserOrders: func(ctx context.Context, userID int) ([]models.Order, error) {
-				return []models.Order{}, nil
+			mockGetUserOrders: func(ctx context.Context, userID int, q models.PageQuery) ([]models.Order, *models.PageCursor, error) {
+				return []models.Order{}, nil, nil
 			},
 			expectedStatus: http.StatusNoContent,
 		},
+		{
+			name: "default page size",
+			mockGetUserOrders: func(ctx context.Context, userID int, q models.PageQuery) ([]models.Order, *models.PageCursor, error) {
+				if q.Limit != defaultPageLimit {
+					t.Errorf("expected limit %d, got %d", defaultPageLimit, q.Limit)
+				}
+				return []models.Order{{Number: "2", Status: models.OrderStatusNew, UploadedAt: now}},
+					&models.PageCursor{At: now, Number: "2"}, nil
+			},
+			expectedStatus: http.StatusOK,
+			expectedCursor: models.PageCursor{At: now, Number: "2"}.Encode(),
+		},
+		{
+			name:  "page with filters",
+			query: "?limit=1&status=processed,NEW&from=2024-01-01T00:00:00Z&sort=asc&cursor=" + models.PageCursor{At: now, Number: "1"}.Encode(),
+			mockGetUserOrders: func(ctx context.Context, userID int, q models.PageQuery) ([]models.Order, *models.PageCursor, error) {
+				if q.Limit != 1 || len(q.Statuses) != 2 || q.From == nil || q.To != nil || !q.Ascending {
+					t.Errorf("unexpected page query: %+v", q)
+				}
+				if q.After == nil || q.After.Number != "1" || !q.After.At.Equal(now.Truncate(time.Microsecond)) {
+					t.Errorf("unexpected cursor: %+v", q.After)
+				}
+				return []models.Order{{Number: "2", Status: models.OrderStatusNew, UploadedAt: now}},
+					&models.PageCursor{At: now, Number: "2"}, nil
+			},
+			expectedStatus: http.StatusOK,
+			expectedCursor: models.PageCursor{At: now, Number: "2"}.Encode(),
+		},
+		{
+			name:           "unknown status",
+			query:          "?status=LOST",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "bad cursor",
+			query:          "?cursor=%21%21",
+			expectedStatus: http.StatusBadRequest,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			service := &mockService{
 				getUserOrdersFn: tt.mockGetUserOrders,
-				validateTokenFn: func(tokenString string) (string, error) {
-					return "user1", nil
+				validateTokenFn: func(ctx context.Context, tokenString string) (models.TokenClaims, error) {
+					return models.TokenClaims{Login: "user1"}, nil
 				},
 				getUserByLoginFn: func(ctx context.Context, login string) (models.User, error) {
 					return models.User{ID: 1}, nil
@@ -361,7 +683,7 @@ func TestHandler_GetUserOrders(t *testing.T) {
 
 			handler := NewHandler(service, logrus.New(), "")
 
-			req := httptest.NewRequest("GET", "/api/user/orders", nil)
+			req := httptest.NewRequest("GET", "/api/user/orders"+tt.query, nil)
 
 			ctx := context.WithValue(req.Context(), middleware.UserIDKey, 1)
 			req = req.WithContext(ctx)
@@ -383,6 +705,1119 @@ func TestHandler_GetUserOrders(t *testing.T) {
 					t.Errorf("expected body %q, got %q", tt.expectedBody, string(body))
 				}
 			}
+			if cursor := resp.Header.Get(NextCursorHeader); cursor != tt.expectedCursor {
+				t.Errorf("expected cursor %q, got %q", tt.expectedCursor, cursor)
+			}
+		})
+	}
+}
+
+func TestHandler_GetUserOrder(t *testing.T) {
+	order := models.Order{Number: "12345678903", UserID: 1, Status: models.OrderStatusProcessing, UploadedAt: time.Now()}
+	service := &mockService{
+		getUserOrderFn: func(ctx context.Context, userID int, orderNumber string) (models.Order, error) {
+			if orderNumber != order.Number {
+				return models.Order{}, e.ErrOrderNotFound
+			}
+			return order, nil
+		},
+	}
+	handler := NewHandler(service, logrus.New(), "")
+	router := chi.NewRouter()
+	router.Get("/api/user/orders/{number}", handler.GetUserOrder)
+
+	get := func(number, ifNoneMatch string) *http.Response {
+		req := httptest.NewRequest("GET", "/api/user/orders/"+number, nil)
+		req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, 1))
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Result()
+	}
+
+	resp := get(order.Number, "")
+	etag := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || etag == "" {
+		t.Fatalf("expected 200 with an ETag, got %d %q", resp.StatusCode, etag)
+	}
+	if resp := get(order.Number, etag); resp.StatusCode != http.StatusNotModified {
+		t.Errorf("expected 304 for a matching ETag, got %d", resp.StatusCode)
+	}
+
+	order.Status = models.OrderStatusProcessed
+	order.Accrual = 500
+	if resp := get(order.Number, etag); resp.StatusCode != http.StatusOK || resp.Header.Get("ETag") == etag {
+		t.Errorf("expected 200 with a new ETag after a change, got %d", resp.StatusCode)
+	}
+	if resp := get("79927398713", ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown order, got %d", resp.StatusCode)
+	}
+}
+
+func TestHandler_UploadOrders(t *testing.T) {
+	tests := []struct {
+		name           string
+		contentType    string
+		body           string
+		expectedStatus int
+		expectedItems  string
+	}{
+		{
+			name:           "json array",
+			contentType:    "application/json",
+			body:           `["12345678903", 79927398713]`,
+			expectedStatus: http.StatusOK,
+			expectedItems:  `[{"number":"12345678903","result":"ACCEPTED","status":202},{"number":"79927398713","result":"CONFLICT","status":409}]`,
+		},
+		{
+			name:           "text lines",
+			contentType:    "text/plain; charset=utf-8",
+			body:           "12345678903\n\n79927398713\n",
+			expectedStatus: http.StatusOK,
+			expectedItems:  `[{"number":"12345678903","result":"ACCEPTED","status":202},{"number":"79927398713","result":"CONFLICT","status":409}]`,
+		},
+		{
+			name:           "not an array",
+			contentType:    "application/json",
+			body:           `{"number": "12345678903"}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "empty batch",
+			contentType:    "application/json",
+			body:           `[]`,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &mockService{
+				uploadOrdersFn: func(ctx context.Context, userID int, numbers []string, ip string) ([]models.OrderUploadItem, error) {
+					if len(numbers) == 0 {
+						return nil, e.ErrEmptyBatch
+					}
+					items := make([]models.OrderUploadItem, 0, len(numbers))
+					for _, number := range numbers {
+						result := models.UploadResultAccepted
+						if number == "79927398713" {
+							result = models.UploadResultConflict
+						}
+						items = append(items, models.OrderUploadItem{Number: number, Result: result})
+					}
+					return items, nil
+				},
+			}
+			handler := NewHandler(service, logrus.New(), "")
+
+			req := httptest.NewRequest("POST", "/api/user/orders/batch", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, 1))
+			w := httptest.NewRecorder()
+
+			handler.UploadOrders(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if tt.expectedItems != "" && strings.TrimSpace(w.Body.String()) != tt.expectedItems {
+				t.Errorf("expected body %s, got %s", tt.expectedItems, w.Body.String())
+			}
 		})
 	}
 }
+
+func TestHandler_StreamEvents(t *testing.T) {
+	events := make(chan models.UserEvent, 2)
+	events <- models.UserEvent{
+		Type:   models.EventOrder,
+		UserID: 1,
+		Order:  &models.OrderEvent{Number: "12345678903", Status: models.OrderStatusProcessed, Accrual: 500},
+	}
+	events <- models.UserEvent{
+		Type:    models.EventBalance,
+		UserID:  1,
+		Balance: &models.BalanceEvent{Current: 500},
+	}
+	close(events)
+
+	unsubscribed := false
+	service := &mockService{
+		subscribeEventsFn: func(ctx context.Context, userID int) (<-chan models.UserEvent, func()) {
+			return events, func() { unsubscribed = true }
+		},
+	}
+	handler := NewHandler(service, logrus.New(), "")
+
+	claims := models.TokenClaims{UserID: 1, ExpiresAt: time.Now().Add(time.Minute)}
+	req := httptest.NewRequest("GET", "/api/user/events", nil)
+	req = req.WithContext(context.WithValue(req.Context(), middleware.TokenClaimsKey, claims))
+	w := httptest.NewRecorder()
+
+	handler.StreamEvents(w, req)
+
+	if ct := w.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("expected text/event-stream, got %q", ct)
+	}
+	expected := "event: order\ndata: {\"number\":\"12345678903\",\"status\":\"PROCESSED\",\"accrual\":500}\n\n" +
+		"event: balance\ndata: {\"current\":500,\"withdrawn\":0}\n\n"
+	if w.Body.String() != expected {
+		t.Errorf("expected body %q, got %q", expected, w.Body.String())
+	}
+	if !unsubscribed {
+		t.Error("expected the stream to unsubscribe")
+	}
+}
+
+func TestHandler_StreamEventsEndsAtTokenExpiry(t *testing.T) {
+	service := &mockService{
+		subscribeEventsFn: func(ctx context.Context, userID int) (<-chan models.UserEvent, func()) {
+			return make(chan models.UserEvent), func() {}
+		},
+	}
+	handler := NewHandler(service, logrus.New(), "")
+
+	claims := models.TokenClaims{UserID: 1, ExpiresAt: time.Now().Add(50 * time.Millisecond)}
+	req := httptest.NewRequest("GET", "/api/user/events", nil)
+	req = req.WithContext(context.WithValue(req.Context(), middleware.TokenClaimsKey, claims))
+
+	done := make(chan struct{})
+	go func() {
+		handler.StreamEvents(httptest.NewRecorder(), req)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream outlived its access token")
+	}
+}
+
+func TestHandler_WebSocket(t *testing.T) {
+	events := make(chan models.UserEvent, 2)
+	connections := 0
+	service := &mockService{
+		connectWebSocketFn: func(ctx context.Context, userID int) (<-chan models.UserEvent, func(), error) {
+			if connections > 0 {
+				return nil, nil, e.ErrTooManyConnections
+			}
+			connections++
+			return events, func() {}, nil
+		},
+	}
+	handler := NewHandler(service, logrus.New(), "")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims := models.TokenClaims{UserID: 1, ExpiresAt: time.Now().Add(time.Minute)}
+		ctx := context.WithValue(r.Context(), middleware.TokenClaimsKey, claims)
+		handler.WebSocket(w, r.WithContext(ctx))
+	}))
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+	conn, err := websocket.Dial(wsURL, "", server.URL)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	receive := func() wsServerMessage {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var msg wsServerMessage
+		if err := websocket.JSON.Receive(conn, &msg); err != nil {
+			t.Fatalf("receive failed: %v", err)
+		}
+		return msg
+	}
+
+	websocket.JSON.Send(conn, wsClientMessage{Type: "subscribe", Topics: []string{"balance"}})
+	if msg := receive(); msg.Type != "subscribed" || len(msg.Topics) != 1 || msg.Topics[0] != "balance" {
+		t.Fatalf("expected subscription to balance, got %+v", msg)
+	}
+	websocket.JSON.Send(conn, wsClientMessage{Type: "ping"})
+	if msg := receive(); msg.Type != "pong" {
+		t.Fatalf("expected pong, got %+v", msg)
+	}
+
+	events <- models.UserEvent{Type: models.EventOrder, UserID: 1, Order: &models.OrderEvent{Number: "12345678903"}}
+	events <- models.UserEvent{Type: models.EventBalance, UserID: 1, Balance: &models.BalanceEvent{Current: 500}}
+	if msg := receive(); msg.Type != "balance" {
+		t.Errorf("expected only the subscribed balance event, got %+v", msg)
+	}
+
+	if _, err := websocket.Dial(wsURL, "", server.URL); err == nil {
+		t.Error("expected a second connection to be refused")
+	}
+}
+
+func TestHandler_WebSocketEndsAtTokenExpiry(t *testing.T) {
+	service := &mockService{
+		connectWebSocketFn: func(ctx context.Context, userID int) (<-chan models.UserEvent, func(), error) {
+			return make(chan models.UserEvent), func() {}, nil
+		},
+	}
+	handler := NewHandler(service, logrus.New(), "")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims := models.TokenClaims{UserID: 1, ExpiresAt: time.Now().Add(50 * time.Millisecond)}
+		ctx := context.WithValue(r.Context(), middleware.TokenClaimsKey, claims)
+		handler.WebSocket(w, r.WithContext(ctx))
+	}))
+	defer server.Close()
+
+	conn, err := websocket.Dial("ws"+strings.TrimPrefix(server.URL, "http"), "", server.URL)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var msg wsServerMessage
+	if err := websocket.JSON.Receive(conn, &msg); err != nil {
+		t.Fatalf("receive failed: %v", err)
+	}
+	if msg.Type != "error" {
+		t.Errorf("expected an error message, got %+v", msg)
+	}
+	if err := websocket.JSON.Receive(conn, &msg); err == nil {
+		t.Errorf("expected the connection to close, got %+v", msg)
+	}
+}
+
+func TestHandler_Withdraw(t *testing.T) {
+	tests := []struct {
+		name           string
+		requestBody    string
+		mockWithdraw   func(ctx context.Context, userID int, orderNumber string, sum float64, mfaCode string) (models.WithdrawalStatus, error)
+		expectedStatus int
+	}{
+		{
+			name:        "processed immediately",
+			requestBody: `{"order": "2377225624", "sum": 100}`,
+			mockWithdraw: func(ctx context.Context, userID int, orderNumber string, sum float64, mfaCode string) (models.WithdrawalStatus, error) {
+				return models.WithdrawalStatusProcessed, nil
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:        "held for review",
+			requestBody: `{"order": "2377225624", "sum": 10000}`,
+			mockWithdraw: func(ctx context.Context, userID int, orderNumber string, sum float64, mfaCode string) (models.WithdrawalStatus, error) {
+				return models.WithdrawalStatusPendingReview, nil
+			},
+			expectedStatus: http.StatusAccepted,
+		},
+		{
+			name:        "insufficient funds",
+			requestBody: `{"order": "2377225624", "sum": 10000}`,
+			mockWithdraw: func(ctx context.Context, userID int, orderNumber string, sum float64, mfaCode string) (models.WithdrawalStatus, error) {
+				return "", e.ErrInsufficientFunds
+			},
+			expectedStatus: http.StatusPaymentRequired,
+		},
+		{
+			name:        "two-factor code missing",
+			requestBody: `{"order": "2377225624", "sum": 10000}`,
+			mockWithdraw: func(ctx context.Context, userID int, orderNumber string, sum float64, mfaCode string) (models.WithdrawalStatus, error) {
+				if mfaCode == "" {
+					return "", e.ErrMFARequired
+				}
+				return models.WithdrawalStatusProcessed, nil
+			},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:        "two-factor code given",
+			requestBody: `{"order": "2377225624", "sum": 10000, "mfa_code": "123456"}`,
+			mockWithdraw: func(ctx context.Context, userID int, orderNumber string, sum float64, mfaCode string) (models.WithdrawalStatus, error) {
+				if mfaCode != "123456" {
+					return "", e.ErrInvalidMFACode
+				}
+				return models.WithdrawalStatusProcessed, nil
+			},
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &mockService{
+				withdrawFn: tt.mockWithdraw,
+			}
+
+			handler := NewHandler(service, logrus.New(), "")
+
+			req := httptest.NewRequest("POST", "/api/user/balance/withdraw", strings.NewReader(tt.requestBody))
+			req.Header.Set("Content-Type", "application/json")
+			ctx := context.WithValue(req.Context(), middleware.UserIDKey, 1)
+			req = req.WithContext(ctx)
+
+			w := httptest.NewRecorder()
+
+			handler.Withdraw(w, req)
+
+			resp := w.Result()
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestHandler_RejectWithdrawal(t *testing.T) {
+	tests := []struct {
+		name           string
+		requestBody    string
+		mockReview     func(ctx context.Context, reviewerID int, orderNumber string, decision models.ReviewDecision, reason string) error
+		expectedStatus int
+	}{
+		{
+			name:        "rejected with reason",
+			requestBody: `{"reason": "suspicious"}`,
+			mockReview: func(ctx context.Context, reviewerID int, orderNumber string, decision models.ReviewDecision, reason string) error {
+				if reviewerID != 7 || orderNumber != "2377225624" || decision != models.ReviewDecisionRejected {
+					t.Errorf("unexpected review call: %d %s %s", reviewerID, orderNumber, decision)
+				}
+				return nil
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:        "missing reason",
+			requestBody: `{}`,
+			mockReview: func(ctx context.Context, reviewerID int, orderNumber string, decision models.ReviewDecision, reason string) error {
+				return nil
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:        "already reviewed",
+			requestBody: `{"reason": "suspicious"}`,
+			mockReview: func(ctx context.Context, reviewerID int, orderNumber string, decision models.ReviewDecision, reason string) error {
+				return e.ErrWithdrawalNotPending
+			},
+			expectedStatus: http.StatusConflict,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &mockService{
+				reviewWithdrawalFn: tt.mockReview,
+			}
+
+			handler := NewHandler(service, logrus.New(), "")
+			router := chi.NewRouter()
+			router.Post("/api/admin/withdrawals/{order}/reject", handler.RejectWithdrawal)
+
+			req := httptest.NewRequest("POST", "/api/admin/withdrawals/2377225624/reject", strings.NewReader(tt.requestBody))
+			ctx := context.WithValue(req.Context(), middleware.UserIDKey, 7)
+			req = req.WithContext(ctx)
+
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			resp := w.Result()
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestHandler_ApproveWithdrawalEmptyBody(t *testing.T) {
+	service := &mockService{
+		reviewWithdrawalFn: func(ctx context.Context, reviewerID int, orderNumber string, decision models.ReviewDecision, reason string) error {
+			if decision != models.ReviewDecisionApproved || reason != "" {
+				t.Errorf("unexpected review call: %s %q", decision, reason)
+			}
+			return nil
+		},
+	}
+
+	handler := NewHandler(service, logrus.New(), "")
+	router := chi.NewRouter()
+	router.Post("/api/admin/withdrawals/{order}/approve", handler.ApproveWithdrawal)
+
+	req := httptest.NewRequest("POST", "/api/admin/withdrawals/2377225624/approve", nil)
+	req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, 7))
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+}
+
+func TestHandler_CreateAdjustment(t *testing.T) {
+	tests := []struct {
+		name           string
+		requestBody    string
+		mockCreate     func(ctx context.Context, adj models.BalanceAdjustment) (models.BalanceAdjustment, error)
+		expectedStatus int
+	}{
+		{
+			name:        "applied",
+			requestBody: `{"amount": 50, "reason_code": "GOODWILL", "comment": "late delivery"}`,
+			mockCreate: func(ctx context.Context, adj models.BalanceAdjustment) (models.BalanceAdjustment, error) {
+				if adj.UserID != 42 || adj.CreatedBy != 7 || adj.Amount != 50 || adj.Reason != models.AdjustmentReasonGoodwill {
+					t.Errorf("unexpected adjustment: %+v", adj)
+				}
+				adj.Status = models.AdjustmentStatusApplied
+				return adj, nil
+			},
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:        "held for approval",
+			requestBody: `{"amount": -5000, "reason_code": "CORRECTION", "comment": "double accrual"}`,
+			mockCreate: func(ctx context.Context, adj models.BalanceAdjustment) (models.BalanceAdjustment, error) {
+				adj.Status = models.AdjustmentStatusPendingApproval
+				return adj, nil
+			},
+			expectedStatus: http.StatusAccepted,
+		},
+		{
+			name:        "invalid adjustment",
+			requestBody: `{"amount": 50, "reason_code": "BECAUSE"}`,
+			mockCreate: func(ctx context.Context, adj models.BalanceAdjustment) (models.BalanceAdjustment, error) {
+				return models.BalanceAdjustment{}, e.ErrInvalidAdjustment
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:        "debit beyond balance",
+			requestBody: `{"amount": -50, "reason_code": "CORRECTION", "comment": "double accrual"}`,
+			mockCreate: func(ctx context.Context, adj models.BalanceAdjustment) (models.BalanceAdjustment, error) {
+				return models.BalanceAdjustment{}, e.ErrAdjustmentExceedsBalance
+			},
+			expectedStatus: http.StatusConflict,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &mockService{
+				createAdjustmentFn: tt.mockCreate,
+			}
+
+			handler := NewHandler(service, logrus.New(), "")
+			router := chi.NewRouter()
+			router.Post("/api/admin/users/{id}/adjustments", handler.CreateAdjustment)
+
+			req := httptest.NewRequest("POST", "/api/admin/users/42/adjustments", strings.NewReader(tt.requestBody))
+			ctx := context.WithValue(req.Context(), middleware.UserIDKey, 7)
+			req = req.WithContext(ctx)
+
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			resp := w.Result()
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestHandler_RedeemVoucher(t *testing.T) {
+	tests := []struct {
+		name           string
+		requestBody    string
+		mockRedeem     func(ctx context.Context, userID int, code string) (models.Voucher, error)
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:        "redeemed",
+			requestBody: `{"code": "abcd-efgh-jklm"}`,
+			mockRedeem: func(ctx context.Context, userID int, code string) (models.Voucher, error) {
+				return models.Voucher{Code: "ABCDEFGHJKLM", Amount: 500}, nil
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"code":"ABCD-EFGH-JKLM","amount":500}` + "\n",
+		},
+		{
+			name:        "already redeemed",
+			requestBody: `{"code": "ABCDEFGHJKLM"}`,
+			mockRedeem: func(ctx context.Context, userID int, code string) (models.Voucher, error) {
+				return models.Voucher{}, e.ErrVoucherAlreadyRedeemed
+			},
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:        "expired",
+			requestBody: `{"code": "ABCDEFGHJKLM"}`,
+			mockRedeem: func(ctx context.Context, userID int, code string) (models.Voucher, error) {
+				return models.Voucher{}, e.ErrVoucherExpired
+			},
+			expectedStatus: http.StatusGone,
+		},
+		{
+			name:        "missing code",
+			requestBody: `{}`,
+			mockRedeem: func(ctx context.Context, userID int, code string) (models.Voucher, error) {
+				return models.Voucher{}, nil
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &mockService{
+				redeemVoucherFn: tt.mockRedeem,
+			}
+
+			handler := NewHandler(service, logrus.New(), "")
+
+			req := httptest.NewRequest("POST", "/api/user/vouchers/redeem", strings.NewReader(tt.requestBody))
+			ctx := context.WithValue(req.Context(), middleware.UserIDKey, 1)
+			req = req.WithContext(ctx)
+
+			w := httptest.NewRecorder()
+
+			handler.RedeemVoucher(w, req)
+
+			resp := w.Result()
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, resp.StatusCode)
+			}
+
+			if tt.expectedBody != "" {
+				body, _ := io.ReadAll(resp.Body)
+				if string(body) != tt.expectedBody {
+					t.Errorf("expected body %q, got %q", tt.expectedBody, string(body))
+				}
+			}
+		})
+	}
+}
+
+func TestHandler_RefreshToken(t *testing.T) {
+	tests := []struct {
+		name           string
+		requestBody    string
+		mockRefresh    func(ctx context.Context, refreshToken string) (models.TokenPair, error)
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:        "rotated",
+			requestBody: `{"refresh_token": "refresh123"}`,
+			mockRefresh: func(ctx context.Context, refreshToken string) (models.TokenPair, error) {
+				return models.TokenPair{AccessToken: "token456", RefreshToken: "refresh456", ExpiresIn: 15 * time.Minute}, nil
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"access_token":"token456","refresh_token":"refresh456","expires_in":900}` + "\n",
+		},
+		{
+			name:        "reused token",
+			requestBody: `{"refresh_token": "refresh123"}`,
+			mockRefresh: func(ctx context.Context, refreshToken string) (models.TokenPair, error) {
+				return models.TokenPair{}, e.ErrRefreshTokenReused
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:        "missing token",
+			requestBody: `{}`,
+			mockRefresh: func(ctx context.Context, refreshToken string) (models.TokenPair, error) {
+				return models.TokenPair{}, nil
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &mockService{
+				refreshTokenFn: tt.mockRefresh,
+			}
+
+			handler := NewHandler(service, logrus.New(), "")
+
+			req := httptest.NewRequest("POST", "/api/user/token/refresh", strings.NewReader(tt.requestBody))
+			w := httptest.NewRecorder()
+
+			handler.RefreshToken(w, req)
+
+			resp := w.Result()
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, resp.StatusCode)
+			}
+
+			if tt.expectedBody != "" {
+				body, _ := io.ReadAll(resp.Body)
+				if string(body) != tt.expectedBody {
+					t.Errorf("expected body %q, got %q", tt.expectedBody, string(body))
+				}
+			}
+		})
+	}
+}
+
+func TestAuth_CookieSession(t *testing.T) {
+	service := &mockService{
+		validateTokenFn: func(ctx context.Context, tokenString string) (models.TokenClaims, error) {
+			if tokenString != "token123" {
+				return models.TokenClaims{}, e.ErrInvalidToken
+			}
+			return models.TokenClaims{UserID: 1, Login: "user1"}, nil
+		},
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name           string
+		mode           middleware.AuthMode
+		method         string
+		cookies        []*http.Cookie
+		csrfHeader     string
+		bearer         string
+		expectedStatus int
+	}{
+		{
+			name:           "bearer token in cookie mode",
+			mode:           middleware.AuthModeCookie,
+			method:         http.MethodPost,
+			bearer:         "token123",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "cookie on safe method",
+			mode:           middleware.AuthModeCookie,
+			method:         http.MethodGet,
+			cookies:        []*http.Cookie{{Name: middleware.AccessTokenCookie, Value: "token123"}},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:   "cookie with matching csrf token",
+			mode:   middleware.AuthModeCookie,
+			method: http.MethodPost,
+			cookies: []*http.Cookie{
+				{Name: middleware.AccessTokenCookie, Value: "token123"},
+				{Name: middleware.CSRFCookie, Value: "csrf123"},
+			},
+			csrfHeader:     "csrf123",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:   "cookie without csrf header",
+			mode:   middleware.AuthModeCookie,
+			method: http.MethodPost,
+			cookies: []*http.Cookie{
+				{Name: middleware.AccessTokenCookie, Value: "token123"},
+				{Name: middleware.CSRFCookie, Value: "csrf123"},
+			},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "cookie ignored in header mode",
+			mode:           middleware.AuthModeHeader,
+			method:         http.MethodGet,
+			cookies:        []*http.Cookie{{Name: middleware.AccessTokenCookie, Value: "token123"}},
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth := middleware.Auth(service, middleware.Session{Mode: tt.mode}, logrus.New())(next)
+
+			req := httptest.NewRequest(tt.method, "/api/user/orders", nil)
+			for _, c := range tt.cookies {
+				req.AddCookie(c)
+			}
+			if tt.csrfHeader != "" {
+				req.Header.Set(middleware.CSRFHeader, tt.csrfHeader)
+			}
+			if tt.bearer != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.bearer)
+			}
+			w := httptest.NewRecorder()
+
+			auth.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+}
+
+func TestHandler_LoginCookieMode(t *testing.T) {
+	service := &mockService{
+		loginFn: func(ctx context.Context, login, password, ip string) (models.TokenPair, error) {
+			return models.TokenPair{AccessToken: "token123", RefreshToken: "refresh123", ExpiresIn: time.Minute}, nil
+		},
+	}
+	handler := NewHandler(service, logrus.New(), "")
+	handler.session = middleware.Session{Mode: middleware.AuthModeCookie}
+
+	req := httptest.NewRequest("POST", "/api/user/login", strings.NewReader(`{"login": "user1", "password": "pass123"}`))
+	w := httptest.NewRecorder()
+
+	handler.Login(w, req)
+
+	resp := w.Result()
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if header := resp.Header.Get("Authorization"); header != "Bearer token123" {
+		t.Errorf("expected Authorization header %q, got %q", "Bearer token123", header)
+	}
+	cookies := make(map[string]*http.Cookie)
+	for _, c := range resp.Cookies() {
+		cookies[c.Name] = c
+	}
+	if c := cookies[middleware.AccessTokenCookie]; c == nil || c.Value != "token123" || !c.HttpOnly {
+		t.Errorf("expected HttpOnly access token cookie, got %+v", c)
+	}
+	if c := cookies[middleware.RefreshTokenCookie]; c == nil || c.Value != "refresh123" || !c.HttpOnly {
+		t.Errorf("expected HttpOnly refresh token cookie, got %+v", c)
+	}
+	csrf := ""
+	if c := cookies[middleware.CSRFCookie]; c != nil {
+		csrf = c.Value
+	}
+	expected := fmt.Sprintf(`{"access_token":"token123","refresh_token":"refresh123","expires_in":60,"csrf_token":%q}`, csrf) + "\n"
+	if csrf == "" || string(body) != expected {
+		t.Errorf("expected body %q, got %q", expected, body)
+	}
+}
+
+func TestParseAuthMode(t *testing.T) {
+	for value, expected := range map[string]middleware.AuthMode{
+		"":       middleware.AuthModeHeader,
+		"header": middleware.AuthModeHeader,
+		"Cookie": middleware.AuthModeCookie,
+	} {
+		if mode, err := middleware.ParseAuthMode(value); err != nil || mode != expected {
+			t.Errorf("%q: expected %s, got %s (%v)", value, expected, mode, err)
+		}
+	}
+	if _, err := middleware.ParseAuthMode("cookies"); !errors.Is(err, e.ErrInvalidAuthMode) {
+		t.Errorf("expected ErrInvalidAuthMode, got %v", err)
+	}
+}
+
+func TestAuth_RevokedSession(t *testing.T) {
+	service := &mockService{
+		validateTokenFn: func(ctx context.Context, tokenString string) (models.TokenClaims, error) {
+			return models.TokenClaims{UserID: 1, FamilyID: tokenString}, nil
+		},
+		touchSessionFn: func(ctx context.Context, claims models.TokenClaims) error {
+			if claims.FamilyID == "revoked" {
+				return e.ErrSessionRevoked
+			}
+			return nil
+		},
+	}
+	auth := middleware.Auth(service, middleware.Session{}, logrus.New())
+	handler := auth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for family, expectedStatus := range map[string]int{
+		"active":  http.StatusOK,
+		"revoked": http.StatusUnauthorized,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/api/user/orders", nil)
+		req.Header.Set("Authorization", "Bearer "+family)
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, req)
+
+		if w.Code != expectedStatus {
+			t.Errorf("%s session: expected status %d, got %d", family, expectedStatus, w.Code)
+		}
+	}
+}
+
+func TestHandler_ChangePassword(t *testing.T) {
+	tests := []struct {
+		name           string
+		requestBody    string
+		mockChange     func(ctx context.Context, claims models.TokenClaims, currentPassword, newPassword string) (models.TokenPair, error)
+		expectedStatus int
+	}{
+		{
+			name:        "changed",
+			requestBody: `{"current_password": "old", "new_password": "new"}`,
+			mockChange: func(ctx context.Context, claims models.TokenClaims, currentPassword, newPassword string) (models.TokenPair, error) {
+				return models.TokenPair{AccessToken: "token456"}, nil
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:        "wrong current password",
+			requestBody: `{"current_password": "wrong", "new_password": "new"}`,
+			mockChange: func(ctx context.Context, claims models.TokenClaims, currentPassword, newPassword string) (models.TokenPair, error) {
+				return models.TokenPair{}, e.ErrWrongPassword
+			},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:        "missing new password",
+			requestBody: `{"current_password": "old"}`,
+			mockChange: func(ctx context.Context, claims models.TokenClaims, currentPassword, newPassword string) (models.TokenPair, error) {
+				return models.TokenPair{}, nil
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &mockService{
+				changePasswordFn: tt.mockChange,
+			}
+
+			handler := NewHandler(service, logrus.New(), "")
+
+			req := httptest.NewRequest("POST", "/api/user/password", strings.NewReader(tt.requestBody))
+			ctx := context.WithValue(req.Context(), middleware.TokenClaimsKey, models.TokenClaims{UserID: 1})
+			req = req.WithContext(ctx)
+
+			w := httptest.NewRecorder()
+
+			handler.ChangePassword(w, req)
+
+			resp := w.Result()
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, resp.StatusCode)
+			}
+
+			if tt.expectedStatus == http.StatusOK {
+				if authHeader := resp.Header.Get("Authorization"); authHeader != "Bearer token456" {
+					t.Errorf("expected Authorization header 'Bearer token456', got %q", authHeader)
+				}
+			}
+		})
+	}
+}
+
+func TestAuth_APIKey(t *testing.T) {
+	service := &mockService{
+		authenticateAPIKeyFn: func(ctx context.Context, key string) (models.APIKey, error) {
+			if key != "gmk_valid" {
+				return models.APIKey{}, e.ErrInvalidAPIKey
+			}
+			return models.APIKey{ID: 1, Prefix: "gmk_vali", Scopes: []string{models.ScopeOrdersWrite}}, nil
+		},
+		getUserByLoginFn: func(ctx context.Context, login string) (models.User, error) {
+			if login != "user1" {
+				return models.User{}, e.ErrUserNotFound
+			}
+			return models.User{ID: 7, Login: login}, nil
+		},
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if userID, _ := r.Context().Value(middleware.UserIDKey).(int); userID != 7 {
+			t.Errorf("expected user 7 in context, got %d", userID)
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name           string
+		scope          string
+		key            string
+		login          string
+		expectedStatus int
+	}{
+		{name: "scope granted", scope: models.ScopeOrdersWrite, key: "gmk_valid", login: "user1", expectedStatus: http.StatusOK},
+		{name: "scope missing", scope: models.ScopeWithdraw, key: "gmk_valid", login: "user1", expectedStatus: http.StatusForbidden},
+		{name: "route without scope", key: "gmk_valid", login: "user1", expectedStatus: http.StatusForbidden},
+		{name: "unknown key", scope: models.ScopeOrdersWrite, key: "gmk_other", login: "user1", expectedStatus: http.StatusUnauthorized},
+		{name: "no user", scope: models.ScopeOrdersWrite, key: "gmk_valid", expectedStatus: http.StatusBadRequest},
+		{name: "unknown user", scope: models.ScopeOrdersWrite, key: "gmk_valid", login: "user2", expectedStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := middleware.Auth(service, middleware.Session{}, logrus.New())(next)
+			if tt.scope != "" {
+				handler = middleware.Scope(tt.scope)(handler)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/api/user/orders", nil)
+			req.Header.Set(middleware.APIKeyHeader, tt.key)
+			if tt.login != "" {
+				req.Header.Set(middleware.UserLoginHeader, tt.login)
+			}
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+}
+
+func TestAdminRoutes(t *testing.T) {
+	roles := map[string]models.Role{"user": models.RoleUser, "support": models.RoleSupport, "admin": models.RoleAdmin}
+	userIDs := map[string]int{"user": 1, "support": 2, "admin": 3}
+
+	tests := []struct {
+		name           string
+		token          string
+		method         string
+		path           string
+		expectedStatus int
+		expectedRoute  string
+	}{
+		{name: "user denied", token: "user", method: http.MethodGet, path: "/api/admin/users/5/balance", expectedStatus: http.StatusForbidden},
+		{name: "support reads", token: "support", method: http.MethodGet, path: "/api/admin/users/5/balance", expectedStatus: http.StatusOK, expectedRoute: "/api/admin/users/{id}/balance"},
+		{name: "support cannot disable", token: "support", method: http.MethodPost, path: "/api/admin/users/5/disable", expectedStatus: http.StatusForbidden},
+		{name: "admin disables", token: "admin", method: http.MethodPost, path: "/api/admin/users/5/disable", expectedStatus: http.StatusOK, expectedRoute: "/api/admin/users/{id}/disable"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &mockService{
+				validateTokenFn: func(ctx context.Context, tokenString string) (models.TokenClaims, error) {
+					return models.TokenClaims{UserID: userIDs[tokenString], Login: tokenString}, nil
+				},
+				userRoleFn: func(ctx context.Context, userID int) (models.Role, error) {
+					for login, id := range userIDs {
+						if id == userID {
+							return roles[login], nil
+						}
+					}
+					return "", e.ErrUserNotFound
+				},
+				getUserBalanceFn: func(ctx context.Context, userID int) (current, withdrawn float64, err error) {
+					return 10, 0, nil
+				},
+				setUserDisabledFn: func(ctx context.Context, userID int, disabled bool) error {
+					if userID != 5 || !disabled {
+						t.Errorf("unexpected SetUserDisabled(%d, %v)", userID, disabled)
+					}
+					return nil
+				},
+			}
+			router := NewRouter(logrus.New())
+			router.SetupRoutes(NewHandler(service, logrus.New(), ""))
+
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if len(service.audit) != 1 {
+				t.Fatalf("expected one audit entry, got %d", len(service.audit))
+			}
+			entry := service.audit[0]
+			if entry.ActorID != userIDs[tt.token] || entry.Status != tt.expectedStatus || entry.Path != tt.path {
+				t.Errorf("unexpected audit entry %+v", entry)
+			}
+			if tt.expectedRoute != "" && entry.Route != tt.expectedRoute {
+				t.Errorf("expected route %q, got %q", tt.expectedRoute, entry.Route)
+			}
+		})
+	}
+}
+
+func TestHandler_ExportUserData(t *testing.T) {
+	service := &mockService{
+		exportUserDataFn: func(ctx context.Context, userID int) (models.UserExport, error) {
+			return models.UserExport{
+				User:   models.User{ID: userID, Login: "alice"},
+				Orders: []models.Order{{Number: "12345678903", Status: models.OrderStatusProcessed, Accrual: 500}},
+				Adjustments: []models.BalanceAdjustment{
+					{Amount: 50, Reason: models.AdjustmentReasonGoodwill, Comment: "internal note"},
+				},
+				AuditLog: []models.AuditEntry{
+					{ActorID: userID, Method: "GET", Path: "/api/admin/users", IP: "10.0.0.7"},
+					{ActorID: 1, TargetUserID: userID, Method: "POST", Path: "/api/admin/users/7/disable", IP: "10.9.9.9"},
+				},
+			}, nil
+		},
+	}
+	handler := NewHandler(service, logrus.New(), "")
+
+	request := func(target string) *http.Response {
+		req := httptest.NewRequest("GET", target, nil)
+		req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, 7))
+		w := httptest.NewRecorder()
+		handler.ExportUserData(w, req)
+		return w.Result()
+	}
+
+	t.Run("json", func(t *testing.T) {
+		resp := request("/api/user/export")
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+		var export struct {
+			Profile struct {
+				Login string `json:"login"`
+			} `json:"profile"`
+			Orders []json.RawMessage `json:"orders"`
+		}
+		if err := json.Unmarshal(body, &export); err != nil {
+			t.Fatalf("decode export: %v", err)
+		}
+		if export.Profile.Login != "alice" || len(export.Orders) != 1 {
+			t.Errorf("unexpected export: %s", body)
+		}
+		if strings.Contains(string(body), "internal note") {
+			t.Errorf("export leaks the adjustment comment: %s", body)
+		}
+		if strings.Contains(string(body), "10.9.9.9") || !strings.Contains(string(body), `"by":"staff"`) {
+			t.Errorf("expected the staff entry without its IP: %s", body)
+		}
+	})
+
+	t.Run("zip", func(t *testing.T) {
+		resp := request("/api/user/export?format=zip")
+		defer resp.Body.Close()
+
+		if ct := resp.Header.Get("Content-Type"); ct != "application/zip" {
+			t.Fatalf("expected application/zip, got %q", ct)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		archive, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+		if err != nil {
+			t.Fatalf("open archive: %v", err)
+		}
+		files := make(map[string]bool)
+		for _, f := range archive.File {
+			files[f.Name[strings.LastIndex(f.Name, "/")+1:]] = true
+		}
+		for _, name := range []string{"profile.json", "orders.json", "withdrawals.json", "audit_log.json"} {
+			if !files[name] {
+				t.Errorf("archive misses %s", name)
+			}
+		}
+	})
+}
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/chestorix/gophermart/internal/api/middleware"
+	e "github.com/chestorix/gophermart/internal/errors"
+	"net/http"
+)
+
+type mfaCodeRequest struct {
+	Code string `json:"code"`
+}
+
+func (h *Handler) CompleteMFALogin(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.fail(w, r, e.ErrInvalidRequest)
+		return
+	}
+	if req.MFAToken == "" || req.Code == "" {
+		h.fail(w, r, e.ErrInvalidRequest)
+		return
+	}
+
+	tokens, err := h.service.CompleteMFALogin(r.Context(), req.MFAToken, req.Code, clientIP(r))
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+
+	h.writeTokens(w, r, tokens)
+}
+
+// EnrollTOTP returns a new secret and its otpauth URI. 2FA is not active
+// until a code is confirmed.
+func (h *Handler) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		h.fail(w, r, e.ErrUnauthorized)
+		return
+	}
+
+	enrollment, err := h.service.EnrollTOTP(r.Context(), userID)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+
+	response := struct {
+		Secret     string `json:"secret"`
+		OTPAuthURI string `json:"otpauth_uri"`
+	}{
+		Secret:     enrollment.Secret,
+		OTPAuthURI: enrollment.URI,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		h.logger.Errorf("encode response failed: %v", err)
+	}
+}
+
+func (h *Handler) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		h.fail(w, r, e.ErrUnauthorized)
+		return
+	}
+
+	var req mfaCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		h.fail(w, r, e.ErrInvalidRequest)
+		return
+	}
+
+	codes, err := h.service.ConfirmTOTP(r.Context(), userID, req.Code)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	h.writeRecoveryCodes(w, codes)
+}
+
+func (h *Handler) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		h.fail(w, r, e.ErrUnauthorized)
+		return
+	}
+
+	var req mfaCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		h.fail(w, r, e.ErrInvalidRequest)
+		return
+	}
+
+	if err := h.service.DisableTOTP(r.Context(), userID, req.Code); err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		h.fail(w, r, e.ErrUnauthorized)
+		return
+	}
+
+	var req mfaCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		h.fail(w, r, e.ErrInvalidRequest)
+		return
+	}
+
+	codes, err := h.service.RegenerateRecoveryCodes(r.Context(), userID, req.Code)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	h.writeRecoveryCodes(w, codes)
+}
+
+func (h *Handler) writeRecoveryCodes(w http.ResponseWriter, codes []string) {
+	response := struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}{
+		RecoveryCodes: codes,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		h.logger.Errorf("encode response failed: %v", err)
+	}
+}
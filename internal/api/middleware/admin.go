@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"github.com/chestorix/gophermart/internal/api/problem"
+	e "github.com/chestorix/gophermart/internal/errors"
+	"github.com/chestorix/gophermart/internal/interfaces"
+	"github.com/chestorix/gophermart/internal/models"
+	"net/http"
+)
+
+// RequireRole lets through users with one of roles. It must be mounted
+// after Auth: it relies on the user ID that Auth puts into the request
+// context.
+func RequireRole(authService interfaces.Service, roles ...models.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID, ok := r.Context().Value(UserIDKey).(int)
+			if !ok {
+				problem.Write(w, r, e.ErrUnauthorized)
+				return
+			}
+
+			role, err := authService.UserRole(r.Context(), userID)
+			if err != nil {
+				problem.Write(w, r, e.ErrForbidden)
+				return
+			}
+			for _, allowed := range roles {
+				if role == allowed {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			problem.Write(w, r, e.ErrForbidden)
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"context"
+	"github.com/chestorix/gophermart/internal/interfaces"
+	"github.com/chestorix/gophermart/internal/models"
+	"github.com/go-chi/chi/v5"
+	chimw "github.com/go-chi/chi/v5/middleware"
+	"github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const auditTargetKey contextKey = "auditTarget"
+
+// adminUserRoute prefixes the admin routes that act on the user in {id}.
+const adminUserRoute = "/api/admin/users/{id}"
+
+// SetAuditTarget records the user an admin request acted on when the route
+// does not name it, e.g. the owner of a reviewed adjustment. It is a no-op
+// outside Audit.
+func SetAuditTarget(ctx context.Context, userID int) {
+	if target, ok := ctx.Value(auditTargetKey).(*int); ok {
+		*target = userID
+	}
+}
+
+// Audit writes every request to the admin audit log after it has been
+// served, denied ones included. It must be mounted after Auth and before
+// RequireRole.
+func Audit(auditService interfaces.Service, logger *logrus.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var target int
+			ww := chimw.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r.WithContext(context.WithValue(r.Context(), auditTargetKey, &target)))
+
+			actorID, _ := r.Context().Value(UserIDKey).(int)
+			route := r.URL.Path
+			if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+				route = rctx.RoutePattern()
+			}
+			ip, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				ip = r.RemoteAddr
+			}
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+
+			if target == 0 && strings.HasPrefix(route, adminUserRoute) {
+				target, _ = strconv.Atoi(chi.URLParam(r, "id"))
+			}
+
+			entry := models.AuditEntry{
+				ActorID:      actorID,
+				Method:       r.Method,
+				Route:        route,
+				Path:         r.URL.RequestURI(),
+				Status:       status,
+				IP:           ip,
+				RequestID:    chimw.GetReqID(r.Context()),
+				TargetUserID: target,
+			}
+			if err := auditService.RecordAudit(r.Context(), entry); err != nil {
+				logger.Errorf("write audit entry failed: %v", err)
+			}
+		})
+	}
+}
@@ -3,42 +3,131 @@ package middleware
 import (
 	"context"
 	"fmt"
+	"github.com/chestorix/gophermart/internal/api/problem"
+	e "github.com/chestorix/gophermart/internal/errors"
 	"github.com/chestorix/gophermart/internal/interfaces"
+	chimw "github.com/go-chi/chi/v5/middleware"
+	"github.com/sirupsen/logrus"
 	"net/http"
-	"strings"
 )
 
 type contextKey string
 
-const UserIDKey contextKey = "userID"
+const (
+	UserIDKey      contextKey = "userID"
+	TokenClaimsKey contextKey = "tokenClaims"
+	// APIKeyKey holds the models.APIKey of requests made with an API key.
+	APIKeyKey contextKey = "apiKey"
+	scopeKey  contextKey = "scope"
+)
+
+const (
+	APIKeyHeader = "X-Api-Key"
+	// UserLoginHeader names the user an API key call acts for.
+	UserLoginHeader = "X-User-Login"
+)
+
+// Scope marks a route as callable with an API key that holds scope. It must
+// be mounted before Auth, which rejects API keys on routes without a scope.
+func Scope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), scopeKey, scope)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
 
-func Auth(authService interfaces.Service) func(http.Handler) http.Handler {
+// Auth authenticates the request with an access token or, on routes marked
+// with Scope, with an API key from the X-Api-Key header. Access tokens of
+// revoked sessions are refused; others update their session's last-seen time.
+func Auth(authService interfaces.Service, session Session, logger *logrus.Logger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			token := r.Header.Get("Authorization")
+			if key := r.Header.Get(APIKeyHeader); key != "" {
+				apiKeyAuth(authService, logger, key, next, w, r)
+				return
+			}
+
+			token, fromCookie := session.accessToken(r)
 			if token == "" {
-				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				problem.Write(w, r, e.ErrUnauthorized)
 				return
 			}
-			fmt.Println("token ", token)
-			if strings.HasPrefix(token, "Bearer") {
-				token = strings.TrimPrefix(token, "Bearer ")
+			if fromCookie && !ValidCSRF(r) {
+				problem.Write(w, r, e.ErrInvalidCSRFToken)
+				return
 			}
 
-			login, err := authService.ValidateToken(token)
+			claims, err := authService.ValidateToken(r.Context(), token)
 			if err != nil {
-				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				problem.Write(w, r, e.ErrUnauthorized)
 				return
 			}
-
-			user, err := authService.GetUserByLogin(r.Context(), login)
-			if err != nil {
-				http.Error(w, "unauthorized", http.StatusUnauthorized)
+			switch err := authService.TouchSession(r.Context(), claims); err {
+			case nil:
+			case e.ErrSessionRevoked:
+				problem.Write(w, r, err)
 				return
+			default:
+				logger.Errorf("touch session failed: %v", err)
 			}
 
-			ctx := context.WithValue(r.Context(), UserIDKey, user.ID)
+			ctx := context.WithValue(r.Context(), UserIDKey, claims.UserID)
+			ctx = context.WithValue(ctx, TokenClaimsKey, claims)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
+
+func apiKeyAuth(authService interfaces.Service, logger *logrus.Logger, key string, next http.Handler, w http.ResponseWriter, r *http.Request) {
+	scope, ok := r.Context().Value(scopeKey).(string)
+	if !ok {
+		problem.Write(w, r, e.ErrAPIKeyNotAccepted)
+		return
+	}
+
+	apiKey, err := authService.AuthenticateAPIKey(r.Context(), key)
+	if err != nil {
+		if !problem.Known(err) {
+			logger.Errorf("api key authentication failed: %v", err)
+		}
+		problem.Write(w, r, err)
+		return
+	}
+	if !apiKey.HasScope(scope) {
+		problem.Write(w, r, fmt.Errorf("%w %s", e.ErrAPIKeyScope, scope))
+		return
+	}
+
+	login := r.Header.Get(UserLoginHeader)
+	if login == "" {
+		problem.Write(w, r, e.ErrUserLoginRequired)
+		return
+	}
+	user, err := authService.GetUserByLogin(r.Context(), login)
+	switch {
+	case err != nil:
+		if !problem.Known(err) {
+			logger.Errorf("api key user lookup failed: %v", err)
+		}
+		problem.Write(w, r, err)
+		return
+	case user.DisabledAt != nil:
+		problem.Write(w, r, e.ErrUserDisabled)
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"api_key_id": apiKey.ID,
+		"api_key":    apiKey.Prefix,
+		"user_id":    user.ID,
+		"method":     r.Method,
+		"path":       r.URL.Path,
+		"request_id": chimw.GetReqID(r.Context()),
+	}).Info("api key call")
+
+	ctx := context.WithValue(r.Context(), UserIDKey, user.ID)
+	ctx = context.WithValue(ctx, APIKeyKey, apiKey)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
@@ -0,0 +1,20 @@
+package middleware
+
+import (
+	"github.com/chestorix/gophermart/internal/client"
+	"net"
+	"net/http"
+)
+
+// Client stores the caller's IP and User-Agent in the request context. It
+// must run after chi's RealIP.
+func Client(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+		ctx := client.WithInfo(r.Context(), client.Info{IP: ip, UserAgent: r.UserAgent()})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"github.com/chestorix/gophermart/internal/api/problem"
+	"github.com/chestorix/gophermart/internal/interfaces"
+	"github.com/chestorix/gophermart/internal/tenant"
+	"net"
+	"net/http"
+)
+
+const MerchantHeader = "X-Merchant"
+
+// Merchant resolves the merchant from the X-Merchant header or the host name
+// and stores its ID in the request context for the service and repository.
+func Merchant(merchantService interfaces.Service) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			host := r.Host
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				host = h
+			}
+
+			merchant, err := merchantService.ResolveMerchant(r.Context(), r.Header.Get(MerchantHeader), host)
+			if err != nil {
+				problem.Write(w, r, err)
+				return
+			}
+
+			ctx := tenant.WithMerchant(r.Context(), merchant.ID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	e "github.com/chestorix/gophermart/internal/errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type AuthMode string
+
+const (
+	// AuthModeHeader accepts only the Authorization header.
+	AuthModeHeader AuthMode = "header"
+	// AuthModeCookie also sets and accepts HttpOnly session cookies.
+	AuthModeCookie AuthMode = "cookie"
+)
+
+const (
+	AccessTokenCookie  = "access_token"
+	RefreshTokenCookie = "refresh_token"
+	CSRFCookie         = "csrf_token"
+	CSRFHeader         = "X-CSRF-Token"
+
+	refreshTokenPath = "/api/user/token/refresh"
+)
+
+// Session decides how tokens travel between the server and clients. In
+// cookie mode browsers get the tokens in HttpOnly cookies plus a readable
+// CSRF cookie whose value must be echoed in X-CSRF-Token on state-changing
+// requests (double-submit). Bearer tokens keep working in both modes.
+type Session struct {
+	Mode     AuthMode
+	Secure   bool
+	SameSite http.SameSite
+	Domain   string
+}
+
+// ParseAuthMode accepts header, cookie or an empty value, which means
+// header. Anything else is a configuration mistake that would otherwise
+// leave cookie sessions silently disabled.
+func ParseAuthMode(value string) (AuthMode, error) {
+	switch mode := AuthMode(strings.ToLower(value)); mode {
+	case "":
+		return AuthModeHeader, nil
+	case AuthModeHeader, AuthModeCookie:
+		return mode, nil
+	default:
+		return "", e.ErrInvalidAuthMode
+	}
+}
+
+func ParseSameSite(value string) http.SameSite {
+	switch strings.ToLower(value) {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteLaxMode
+	}
+}
+
+func (s Session) cookiesEnabled() bool {
+	return s.Mode == AuthModeCookie
+}
+
+// SetTokens writes the session cookies and returns the CSRF token. It is a
+// no-op in header mode.
+func (s Session) SetTokens(w http.ResponseWriter, accessToken, refreshToken string, accessTTL, refreshTTL time.Duration) (string, error) {
+	if !s.cookiesEnabled() {
+		return "", nil
+	}
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	csrf := base64.RawURLEncoding.EncodeToString(raw)
+
+	http.SetCookie(w, s.cookie(AccessTokenCookie, accessToken, "/", accessTTL, true))
+	http.SetCookie(w, s.cookie(RefreshTokenCookie, refreshToken, refreshTokenPath, refreshTTL, true))
+	http.SetCookie(w, s.cookie(CSRFCookie, csrf, "/", refreshTTL, false))
+	return csrf, nil
+}
+
+// CookiesEnabled reports whether tokens travel in cookies, in which case
+// they must not appear in response bodies or headers.
+func (s Session) CookiesEnabled() bool {
+	return s.cookiesEnabled()
+}
+
+// Clear expires the session cookies.
+func (s Session) Clear(w http.ResponseWriter) {
+	if !s.cookiesEnabled() {
+		return
+	}
+	http.SetCookie(w, s.cookie(AccessTokenCookie, "", "/", -1, true))
+	http.SetCookie(w, s.cookie(RefreshTokenCookie, "", refreshTokenPath, -1, true))
+	http.SetCookie(w, s.cookie(CSRFCookie, "", "/", -1, false))
+}
+
+func (s Session) cookie(name, value, path string, ttl time.Duration, httpOnly bool) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Domain:   s.Domain,
+		MaxAge:   int(ttl.Seconds()),
+		Secure:   s.Secure,
+		HttpOnly: httpOnly,
+		SameSite: s.SameSite,
+	}
+}
+
+// RefreshToken returns the refresh token cookie, if cookies are enabled.
+func (s Session) RefreshToken(r *http.Request) string {
+	if !s.cookiesEnabled() {
+		return ""
+	}
+	if c, err := r.Cookie(RefreshTokenCookie); err == nil {
+		return c.Value
+	}
+	return ""
+}
+
+// accessToken extracts the token from the Authorization header or, in
+// cookie mode, from the access token cookie.
+func (s Session) accessToken(r *http.Request) (token string, fromCookie bool) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		return strings.TrimPrefix(header, "Bearer "), false
+	}
+	if s.cookiesEnabled() {
+		if c, err := r.Cookie(AccessTokenCookie); err == nil && c.Value != "" {
+			return c.Value, true
+		}
+	}
+	return "", false
+}
+
+// ValidCSRF implements the double-submit check for cookie-authenticated
+// requests. Safe methods are always allowed.
+func ValidCSRF(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	c, err := r.Cookie(CSRFCookie)
+	if err != nil || c.Value == "" {
+		return false
+	}
+	header := r.Header.Get(CSRFHeader)
+	return subtle.ConstantTimeCompare([]byte(header), []byte(c.Value)) == 1
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"github.com/chestorix/gophermart/internal/api/middleware"
+	e "github.com/chestorix/gophermart/internal/errors"
+	"github.com/chestorix/gophermart/internal/models"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// maxBatchBodySize bounds the batch upload body; at the default batch
+// limit of 1000 numbers this leaves plenty of room for long numbers.
+const maxBatchBodySize = 1 << 20
+
+type orderUploadItemResponse struct {
+	Number string              `json:"number"`
+	Result models.UploadResult `json:"result"`
+	// Status is the code POST /api/user/orders answers for this number.
+	Status int `json:"status"`
+}
+
+// uploadResultStatus maps a batch item result to the status code of the
+// single upload endpoint.
+func uploadResultStatus(result models.UploadResult) int {
+	switch result {
+	case models.UploadResultAccepted:
+		return http.StatusAccepted
+	case models.UploadResultDuplicate:
+		return http.StatusOK
+	case models.UploadResultConflict:
+		return http.StatusConflict
+	default:
+		return http.StatusUnprocessableEntity
+	}
+}
+
+// parseOrderBatch reads a JSON array of order numbers, given as strings or
+// numbers, or a text body with one number per line.
+func parseOrderBatch(r *http.Request, body []byte) ([]string, bool) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/json":
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+		var raw []any
+		if err := decoder.Decode(&raw); err != nil {
+			return nil, false
+		}
+		numbers := make([]string, 0, len(raw))
+		for _, value := range raw {
+			switch v := value.(type) {
+			case string:
+				numbers = append(numbers, v)
+			case json.Number:
+				numbers = append(numbers, v.String())
+			default:
+				return nil, false
+			}
+		}
+		return numbers, true
+	case "text/plain":
+		var numbers []string
+		scanner := bufio.NewScanner(bytes.NewReader(body))
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				numbers = append(numbers, line)
+			}
+		}
+		return numbers, scanner.Err() == nil
+	default:
+		return nil, false
+	}
+}
+
+// UploadOrders accepts many order numbers at once and answers 200 with a
+// result per number, in request order.
+func (h *Handler) UploadOrders(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		h.fail(w, r, e.ErrUnauthorized)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBatchBodySize))
+	if err != nil {
+		h.fail(w, r, e.ErrRequestTooLarge)
+		return
+	}
+	numbers, ok := parseOrderBatch(r, body)
+	if !ok {
+		h.fail(w, r, e.ErrInvalidRequest)
+		return
+	}
+
+	items, err := h.service.UploadOrders(r.Context(), userID, numbers, clientIP(r))
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+
+	response := make([]orderUploadItemResponse, 0, len(items))
+	for _, item := range items {
+		response = append(response, orderUploadItemResponse{
+			Number: item.Number,
+			Result: item.Result,
+			Status: uploadResultStatus(item.Result),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		h.logger.Errorf("encode response failed: %v", err)
+	}
+}
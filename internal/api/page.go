@@ -0,0 +1,91 @@
+package api
+
+import (
+	e "github.com/chestorix/gophermart/internal/errors"
+	"github.com/chestorix/gophermart/internal/models"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultPageLimit applies when the query has no limit, so a heavy
+	// user's list is loaded a page at a time.
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+	// NextCursorHeader carries the cursor of the next page; it is missing
+	// on the last page.
+	NextCursorHeader = "X-Next-Cursor"
+)
+
+// parsePageQuery reads limit, cursor, status, from, to and sort from the
+// query string. Without limit a page holds defaultPageLimit rows; clients
+// follow X-Next-Cursor for the rest. status may be repeated or
+// comma-separated; from and to are RFC3339.
+func parsePageQuery(r *http.Request, statuses ...string) (models.PageQuery, error) {
+	values := r.URL.Query()
+	q := models.PageQuery{Limit: defaultPageLimit}
+
+	if value := values.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return models.PageQuery{}, e.ErrInvalidPageQuery
+		}
+		q.Limit = min(limit, maxPageLimit)
+	}
+	if value := values.Get("cursor"); value != "" {
+		cursor, ok := models.ParsePageCursor(value)
+		if !ok {
+			return models.PageQuery{}, e.ErrInvalidPageQuery
+		}
+		q.After = &cursor
+	}
+	for _, value := range values["status"] {
+		for _, status := range strings.Split(value, ",") {
+			status = strings.ToUpper(strings.TrimSpace(status))
+			if !slices.Contains(statuses, status) {
+				return models.PageQuery{}, e.ErrInvalidPageQuery
+			}
+			q.Statuses = append(q.Statuses, status)
+		}
+	}
+	for name, dst := range map[string]**time.Time{"from": &q.From, "to": &q.To} {
+		if value := values.Get(name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return models.PageQuery{}, e.ErrInvalidPageQuery
+			}
+			*dst = &t
+		}
+	}
+	switch values.Get("sort") {
+	case "", "desc":
+	case "asc":
+		q.Ascending = true
+	default:
+		return models.PageQuery{}, e.ErrInvalidPageQuery
+	}
+	return q, nil
+}
+
+var (
+	orderStatuses = []string{
+		string(models.OrderStatusNew),
+		string(models.OrderStatusProcessing),
+		string(models.OrderStatusInvalid),
+		string(models.OrderStatusProcessed),
+	}
+	withdrawalStatuses = []string{
+		string(models.WithdrawalStatusProcessed),
+		string(models.WithdrawalStatusPendingReview),
+		string(models.WithdrawalStatusRejected),
+	}
+)
+
+func writeNextCursor(w http.ResponseWriter, next *models.PageCursor) {
+	if next != nil {
+		w.Header().Set(NextCursorHeader, next.Encode())
+	}
+}
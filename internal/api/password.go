@@ -0,0 +1,78 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/chestorix/gophermart/internal/api/middleware"
+	e "github.com/chestorix/gophermart/internal/errors"
+	"github.com/chestorix/gophermart/internal/models"
+	"net/http"
+)
+
+func (h *Handler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.TokenClaimsKey).(models.TokenClaims)
+	if !ok {
+		h.fail(w, r, e.ErrUnauthorized)
+		return
+	}
+
+	var req struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.fail(w, r, e.ErrInvalidRequest)
+		return
+	}
+	if req.CurrentPassword == "" || req.NewPassword == "" {
+		h.fail(w, r, e.ErrInvalidRequest)
+		return
+	}
+
+	tokens, err := h.service.ChangePassword(r.Context(), claims, req.CurrentPassword, req.NewPassword)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+
+	h.writeTokens(w, r, tokens)
+}
+
+// RequestPasswordReset always answers 202 so that callers cannot probe
+// which logins exist.
+func (h *Handler) RequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Login string `json:"login"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Login == "" {
+		h.fail(w, r, e.ErrInvalidRequest)
+		return
+	}
+
+	if err := h.service.RequestPasswordReset(r.Context(), req.Login); err != nil {
+		h.fail(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *Handler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token       string `json:"token"`
+		NewPassword string `json:"new_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.fail(w, r, e.ErrInvalidRequest)
+		return
+	}
+	if req.Token == "" || req.NewPassword == "" {
+		h.fail(w, r, e.ErrInvalidRequest)
+		return
+	}
+
+	if err := h.service.ResetPassword(r.Context(), req.Token, req.NewPassword); err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
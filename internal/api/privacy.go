@@ -0,0 +1,228 @@
+package api
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"github.com/chestorix/gophermart/internal/api/middleware"
+	e "github.com/chestorix/gophermart/internal/errors"
+	"github.com/chestorix/gophermart/internal/models"
+	"net/http"
+	"time"
+)
+
+// userExportResponse is the personal data export. Adjustments carry their
+// reason code only; the operator's comment is internal.
+type userExportResponse struct {
+	ExportedAt  time.Time          `json:"exported_at"`
+	Profile     exportProfile      `json:"profile"`
+	Orders      []exportOrder      `json:"orders"`
+	Withdrawals []exportWithdrawal `json:"withdrawals"`
+	Vouchers    []exportVoucher    `json:"vouchers"`
+	Adjustments []exportAdjustment `json:"adjustments"`
+	AuditLog    []exportAuditEntry `json:"audit_log"`
+}
+
+type exportProfile struct {
+	ID          int         `json:"id"`
+	Login       string      `json:"login"`
+	Role        models.Role `json:"role"`
+	TOTPEnabled bool        `json:"totp_enabled"`
+	DisabledAt  *time.Time  `json:"disabled_at,omitempty"`
+	CreatedAt   time.Time   `json:"created_at"`
+}
+
+type exportOrder struct {
+	Number     string             `json:"number"`
+	Status     models.OrderStatus `json:"status"`
+	Accrual    float64            `json:"accrual,omitempty"`
+	UploadedAt time.Time          `json:"uploaded_at"`
+}
+
+type exportWithdrawal struct {
+	Order       string                  `json:"order"`
+	Sum         float64                 `json:"sum"`
+	Status      models.WithdrawalStatus `json:"status"`
+	ProcessedAt time.Time               `json:"processed_at"`
+}
+
+type exportVoucher struct {
+	Code       string     `json:"code"`
+	Amount     float64    `json:"amount"`
+	RedeemedAt *time.Time `json:"redeemed_at"`
+}
+
+type exportAdjustment struct {
+	Amount     float64                 `json:"amount"`
+	ReasonCode models.AdjustmentReason `json:"reason_code"`
+	CreatedAt  time.Time               `json:"created_at"`
+}
+
+// exportAuditEntry is an admin request the user made ("self") or that staff
+// made on the user's account ("staff"). Staff IP addresses are not
+// disclosed.
+type exportAuditEntry struct {
+	By        string    `json:"by"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Status    int       `json:"status"`
+	IP        string    `json:"ip,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func newUserExportResponse(export models.UserExport) userExportResponse {
+	response := userExportResponse{
+		ExportedAt: export.ExportedAt,
+		Profile: exportProfile{
+			ID:          export.User.ID,
+			Login:       export.User.Login,
+			Role:        export.User.Role,
+			TOTPEnabled: export.TOTPEnabled,
+			DisabledAt:  export.User.DisabledAt,
+			CreatedAt:   export.User.CreatedAt,
+		},
+		Orders:      make([]exportOrder, 0, len(export.Orders)),
+		Withdrawals: make([]exportWithdrawal, 0, len(export.Withdrawals)),
+		Vouchers:    make([]exportVoucher, 0, len(export.Vouchers)),
+		Adjustments: make([]exportAdjustment, 0, len(export.Adjustments)),
+		AuditLog:    make([]exportAuditEntry, 0, len(export.AuditLog)),
+	}
+	for _, o := range export.Orders {
+		response.Orders = append(response.Orders, exportOrder{
+			Number:     o.Number,
+			Status:     o.Status,
+			Accrual:    o.Accrual,
+			UploadedAt: o.UploadedAt,
+		})
+	}
+	for _, w := range export.Withdrawals {
+		response.Withdrawals = append(response.Withdrawals, exportWithdrawal{
+			Order:       w.Order,
+			Sum:         w.Sum,
+			Status:      w.Status,
+			ProcessedAt: w.ProcessedAt,
+		})
+	}
+	for _, v := range export.Vouchers {
+		response.Vouchers = append(response.Vouchers, exportVoucher{
+			Code:       v.DisplayCode(),
+			Amount:     v.Amount,
+			RedeemedAt: v.RedeemedAt,
+		})
+	}
+	for _, a := range export.Adjustments {
+		response.Adjustments = append(response.Adjustments, exportAdjustment{
+			Amount:     a.Amount,
+			ReasonCode: a.Reason,
+			CreatedAt:  a.CreatedAt,
+		})
+	}
+	for _, entry := range export.AuditLog {
+		item := exportAuditEntry{
+			By:        "self",
+			Method:    entry.Method,
+			Path:      entry.Path,
+			Status:    entry.Status,
+			IP:        entry.IP,
+			CreatedAt: entry.CreatedAt,
+		}
+		if entry.ActorID != export.User.ID {
+			item.By, item.IP = "staff", ""
+		}
+		response.AuditLog = append(response.AuditLog, item)
+	}
+	return response
+}
+
+// ExportUserData hands out the caller's personal data as one JSON document,
+// or as a ZIP archive with one JSON file per section when the client asks
+// for application/zip or format=zip.
+func (h *Handler) ExportUserData(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		h.fail(w, r, e.ErrUnauthorized)
+		return
+	}
+
+	export, err := h.service.ExportUserData(r.Context(), userID)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	response := newUserExportResponse(export)
+
+	name := fmt.Sprintf("gophermart-export-%d-%s", userID, export.ExportedAt.UTC().Format("20060102"))
+	if r.Header.Get("Accept") == "application/zip" || r.URL.Query().Get("format") == "zip" {
+		h.writeExportZIP(w, name, response)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.json"`, name))
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		h.logger.Errorf("encode response failed: %v", err)
+	}
+}
+
+func (h *Handler) writeExportZIP(w http.ResponseWriter, name string, response userExportResponse) {
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, name))
+
+	archive := zip.NewWriter(w)
+	for _, file := range []struct {
+		name string
+		data any
+	}{
+		{"profile.json", response.Profile},
+		{"orders.json", response.Orders},
+		{"withdrawals.json", response.Withdrawals},
+		{"vouchers.json", response.Vouchers},
+		{"adjustments.json", response.Adjustments},
+		{"audit_log.json", response.AuditLog},
+	} {
+		f, err := archive.CreateHeader(&zip.FileHeader{
+			Name:     name + "/" + file.name,
+			Method:   zip.Deflate,
+			Modified: response.ExportedAt,
+		})
+		if err != nil {
+			h.logger.Errorf("write export archive failed: %v", err)
+			return
+		}
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(file.data); err != nil {
+			h.logger.Errorf("write export archive failed: %v", err)
+			return
+		}
+	}
+	if err := archive.Close(); err != nil {
+		h.logger.Errorf("write export archive failed: %v", err)
+	}
+}
+
+// CloseAccount closes the caller's account. It asks for the password, and
+// a code when 2FA is on, because the account cannot be restored.
+func (h *Handler) CloseAccount(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		h.fail(w, r, e.ErrUnauthorized)
+		return
+	}
+
+	var req struct {
+		Password string `json:"password"`
+		MFACode  string `json:"mfa_code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Password == "" {
+		h.fail(w, r, e.ErrInvalidRequest)
+		return
+	}
+
+	if err := h.service.CloseAccount(r.Context(), userID, req.Password, req.MFACode); err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	h.session.Clear(w)
+	w.WriteHeader(http.StatusNoContent)
+}
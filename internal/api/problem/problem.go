@@ -0,0 +1,181 @@
+// Package problem writes error responses as RFC 9457 problem details. Every
+// error sentinel has one status and one stable code, which clients use
+// instead of parsing the message.
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	e "github.com/chestorix/gophermart/internal/errors"
+	chimw "github.com/go-chi/chi/v5/middleware"
+	"math"
+	"net/http"
+	"reflect"
+	"strconv"
+)
+
+// ContentType is the media type of every error response.
+const ContentType = "application/problem+json"
+
+// Problem is the body of an error response.
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	Code      string `json:"code"`
+	RequestID string `json:"request_id,omitempty"`
+	// Errors lists the fields or policy rules a request failed.
+	Errors []e.PolicyViolation `json:"errors,omitempty"`
+	// MFAToken and ExpiresIn answer a login that needs a second factor.
+	MFAToken  string `json:"mfa_token,omitempty"`
+	ExpiresIn int    `json:"expires_in,omitempty"`
+}
+
+type mapping struct {
+	status int
+	code   string
+}
+
+var internalError = mapping{http.StatusInternalServerError, "internal_error"}
+
+var mappings = map[error]mapping{
+	e.ErrUserAlreadyExists:                 {http.StatusConflict, "user_already_exists"},
+	e.ErrUserNotFound:                      {http.StatusNotFound, "user_not_found"},
+	e.ErrUserDisabled:                      {http.StatusForbidden, "user_disabled"},
+	e.ErrInvalidCredentials:                {http.StatusUnauthorized, "invalid_credentials"},
+	e.ErrWrongPassword:                     {http.StatusForbidden, "wrong_password"},
+	e.ErrTooManyLoginAttempts:              {http.StatusTooManyRequests, "too_many_login_attempts"},
+	e.ErrOrderAlreadyUploadedByUser:        {http.StatusConflict, "order_already_uploaded_by_user"},
+	e.ErrOrderAlreadyUploadedByAnotherUser: {http.StatusConflict, "order_already_uploaded_by_another_user"},
+	e.ErrInvalidOrderNumber:                {http.StatusUnprocessableEntity, "invalid_order_number"},
+	e.ErrInsufficientFunds:                 {http.StatusPaymentRequired, "insufficient_funds"},
+	e.ErrAdjustmentExceedsBalance:          {http.StatusConflict, "adjustment_exceeds_balance"},
+	e.ErrOrderNotRegistered:                {http.StatusConflict, "order_not_registered"},
+	e.ErrInvalidTokenClaim:                 {http.StatusUnauthorized, "invalid_token_claim"},
+	e.ErrInvalidToken:                      {http.StatusUnauthorized, "invalid_token"},
+	e.ErrUnexpectedSignMethod:              {http.StatusUnauthorized, "unexpected_signing_method"},
+	e.ErrUnknownSigningKey:                 {http.StatusUnauthorized, "unknown_signing_key"},
+	e.ErrTokenRevoked:                      {http.StatusUnauthorized, "token_revoked"},
+	e.ErrInvalidRefreshToken:               {http.StatusUnauthorized, "invalid_refresh_token"},
+	e.ErrRefreshTokenReused:                {http.StatusUnauthorized, "refresh_token_reused"},
+	e.ErrPolicyViolation:                   {http.StatusBadRequest, "policy_violation"},
+	e.ErrInvalidResetToken:                 {http.StatusBadRequest, "invalid_reset_token"},
+	e.ErrWithdrawalNotFound:                {http.StatusNotFound, "withdrawal_not_found"},
+	e.ErrWithdrawalNotPending:              {http.StatusConflict, "withdrawal_not_pending"},
+	e.ErrWithdrawalAlreadyExists:           {http.StatusConflict, "withdrawal_already_exists"},
+	e.ErrForbidden:                         {http.StatusForbidden, "forbidden"},
+	e.ErrAccountBlocked:                    {http.StatusForbidden, "account_blocked"},
+	e.ErrTooManyRequests:                   {http.StatusTooManyRequests, "too_many_requests"},
+	e.ErrUnknownMerchant:                   {http.StatusNotFound, "unknown_merchant"},
+	e.ErrVoucherNotFound:                   {http.StatusNotFound, "voucher_not_found"},
+	e.ErrVoucherAlreadyRedeemed:            {http.StatusConflict, "voucher_already_redeemed"},
+	e.ErrVoucherExpired:                    {http.StatusGone, "voucher_expired"},
+	e.ErrInvalidVoucherBatch:               {http.StatusBadRequest, "invalid_voucher_batch"},
+	e.ErrVoucherBatchNotFound:              {http.StatusNotFound, "voucher_batch_not_found"},
+	e.ErrFraudSignalNotFound:               {http.StatusNotFound, "fraud_signal_not_found"},
+	e.ErrMFARequired:                       {http.StatusForbidden, "mfa_required"},
+	e.ErrInvalidMFACode:                    {http.StatusForbidden, "invalid_mfa_code"},
+	e.ErrInvalidMFALoginCode:               {http.StatusUnauthorized, "invalid_mfa_login_code"},
+	e.ErrInvalidTOTPSetupCode:              {http.StatusUnprocessableEntity, "invalid_totp_setup_code"},
+	e.ErrInvalidMFAChallenge:               {http.StatusUnauthorized, "invalid_mfa_challenge"},
+	e.ErrTOTPAlreadyEnabled:                {http.StatusConflict, "totp_already_enabled"},
+	e.ErrTOTPNotEnrolled:                   {http.StatusNotFound, "totp_not_enrolled"},
+	e.ErrWithdrawalNeedsMFA:                {http.StatusForbidden, "withdrawal_needs_mfa"},
+	e.ErrInvalidAPIKey:                     {http.StatusUnauthorized, "invalid_api_key"},
+	e.ErrAPIKeyNotFound:                    {http.StatusNotFound, "api_key_not_found"},
+	e.ErrInvalidAPIKeyRequest:              {http.StatusBadRequest, "invalid_api_key_request"},
+	e.ErrOrderNotFound:                     {http.StatusNotFound, "order_not_found"},
+	e.ErrInvalidRole:                       {http.StatusBadRequest, "invalid_role"},
+	e.ErrInvalidAdjustment:                 {http.StatusBadRequest, "invalid_adjustment"},
+	e.ErrAdjustmentNotFound:                {http.StatusNotFound, "adjustment_not_found"},
+	e.ErrAdjustmentNotPending:              {http.StatusConflict, "adjustment_not_pending"},
+	e.ErrSelfApproval:                      {http.StatusForbidden, "self_approval"},
+	e.ErrSessionNotFound:                   {http.StatusNotFound, "session_not_found"},
+	e.ErrSessionRevoked:                    {http.StatusUnauthorized, "session_revoked"},
+	e.ErrInvalidPageQuery:                  {http.StatusBadRequest, "invalid_page_query"},
+	e.ErrEmptyBatch:                        {http.StatusBadRequest, "empty_batch"},
+	e.ErrBatchTooLarge:                     {http.StatusRequestEntityTooLarge, "batch_too_large"},
+	e.ErrTooManyConnections:                {http.StatusTooManyRequests, "too_many_connections"},
+	e.ErrInvalidRequest:                    {http.StatusBadRequest, "invalid_request"},
+	e.ErrRequestTooLarge:                   {http.StatusRequestEntityTooLarge, "request_too_large"},
+	e.ErrUnauthorized:                      {http.StatusUnauthorized, "unauthorized"},
+	e.ErrInvalidCSRFToken:                  {http.StatusForbidden, "invalid_csrf_token"},
+	e.ErrAPIKeyNotAccepted:                 {http.StatusForbidden, "api_key_not_accepted"},
+	e.ErrAPIKeyScope:                       {http.StatusForbidden, "api_key_scope"},
+	e.ErrUserLoginRequired:                 {http.StatusBadRequest, "user_login_required"},
+	e.ErrAccrualUnavailable:                {http.StatusBadGateway, "accrual_unavailable"},
+	e.ErrRouteNotFound:                     {http.StatusNotFound, "route_not_found"},
+	e.ErrMethodNotAllowed:                  {http.StatusMethodNotAllowed, "method_not_allowed"},
+}
+
+// lookup finds the mapping of the first sentinel in err's chain.
+func lookup(err error) (mapping, bool) {
+	for ; err != nil; err = errors.Unwrap(err) {
+		// Errors of uncomparable types would panic as map keys.
+		if !reflect.TypeOf(err).Comparable() {
+			continue
+		}
+		if m, ok := mappings[err]; ok {
+			return m, true
+		}
+	}
+	return internalError, false
+}
+
+// Known reports whether err has a mapping. Unknown errors are answered
+// with a bare 500 and should be logged by the caller.
+func Known(err error) bool {
+	_, ok := lookup(err)
+	return ok
+}
+
+// Write answers err with its mapped status.
+func Write(w http.ResponseWriter, r *http.Request, err error) {
+	m, _ := lookup(err)
+	write(w, r, m, err)
+}
+
+func write(w http.ResponseWriter, r *http.Request, m mapping, err error) {
+	p := Problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(m.status),
+		Status:    m.status,
+		Instance:  r.URL.Path,
+		Code:      m.code,
+		RequestID: chimw.GetReqID(r.Context()),
+	}
+	if m != internalError {
+		p.Detail = err.Error()
+	}
+
+	var (
+		fieldErr  *e.FieldError
+		policyErr *e.PolicyError
+		challenge *e.MFAChallengeError
+		retry     *e.RetryAfterError
+	)
+	if errors.As(err, &fieldErr) {
+		p.Errors = fieldErr.Violations
+	}
+	if errors.As(err, &policyErr) {
+		p.Errors = policyErr.Violations
+	}
+	if errors.As(err, &challenge) {
+		p.Status = http.StatusUnauthorized
+		p.Title = http.StatusText(p.Status)
+		p.MFAToken = challenge.Token
+		p.ExpiresIn = int(challenge.ExpiresIn.Seconds())
+	}
+	if errors.As(err, &retry) {
+		p.Status = http.StatusTooManyRequests
+		p.Title = http.StatusText(p.Status)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.RetryAfter.Seconds()))))
+	}
+
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
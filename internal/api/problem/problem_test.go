@@ -0,0 +1,213 @@
+package problem
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	e "github.com/chestorix/gophermart/internal/errors"
+	chimw "github.com/go-chi/chi/v5/middleware"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// unmapped lists the sentinels that only fail configuration at startup and
+// never reach a response.
+var unmapped = map[string]bool{
+	"ErrInvalidClosureBalance": true,
+	"ErrInvalidAuthMode":       true,
+}
+
+// sentinelNames returns the exported Err* variables declared in the errors
+// package.
+func sentinelNames(t *testing.T) []string {
+	t.Helper()
+	file, err := parser.ParseFile(token.NewFileSet(), "../../errors/errors.go", nil, 0)
+	if err != nil {
+		t.Fatalf("parse errors package: %v", err)
+	}
+	var names []string
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.VAR {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			for _, name := range spec.(*ast.ValueSpec).Names {
+				if name.IsExported() && len(name.Name) > 3 && name.Name[:3] == "Err" {
+					names = append(names, name.Name)
+				}
+			}
+		}
+	}
+	return names
+}
+
+// mappedNames returns the sentinels used as keys of the mappings table.
+func mappedNames(t *testing.T) map[string]bool {
+	t.Helper()
+	file, err := parser.ParseFile(token.NewFileSet(), "problem.go", nil, 0)
+	if err != nil {
+		t.Fatalf("parse problem.go: %v", err)
+	}
+	names := make(map[string]bool)
+	ast.Inspect(file, func(n ast.Node) bool {
+		spec, ok := n.(*ast.ValueSpec)
+		if !ok || len(spec.Names) != 1 || spec.Names[0].Name != "mappings" {
+			return true
+		}
+		for _, elt := range spec.Values[0].(*ast.CompositeLit).Elts {
+			if key, ok := elt.(*ast.KeyValueExpr).Key.(*ast.SelectorExpr); ok {
+				names[key.Sel.Name] = true
+			}
+		}
+		return false
+	})
+	return names
+}
+
+func TestMappings_CoverEverySentinel(t *testing.T) {
+	mapped := mappedNames(t)
+	if len(mapped) != len(mappings) {
+		t.Fatalf("found %d keys in problem.go, mappings has %d", len(mapped), len(mappings))
+	}
+	for _, name := range sentinelNames(t) {
+		if unmapped[name] {
+			if mapped[name] {
+				t.Errorf("%s is configuration only but has a mapping", name)
+			}
+			continue
+		}
+		if !mapped[name] {
+			t.Errorf("%s has no mapping", name)
+		}
+	}
+}
+
+func TestMappings_UniqueCodes(t *testing.T) {
+	seen := make(map[string]error, len(mappings))
+	for err, m := range mappings {
+		if m.code == "" {
+			t.Errorf("%q has no code", err)
+		}
+		if other, ok := seen[m.code]; ok {
+			t.Errorf("%q and %q share code %s", err, other, m.code)
+		}
+		seen[m.code] = err
+	}
+}
+
+func TestWrite(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		status     int
+		code       string
+		detail     string
+		violations []e.PolicyViolation
+		mfaToken   string
+		expiresIn  int
+		retryAfter string
+	}{
+		{
+			name:   "sentinel",
+			err:    e.ErrUserNotFound,
+			status: http.StatusNotFound,
+			code:   "user_not_found",
+			detail: "user not found",
+		},
+		{
+			name:   "wrapped sentinel",
+			err:    fmt.Errorf("load order: %w", e.ErrOrderNotFound),
+			status: http.StatusNotFound,
+			code:   "order_not_found",
+			detail: "load order: order not found",
+		},
+		{
+			name:   "unknown error hides its message",
+			err:    errors.New("connection refused"),
+			status: http.StatusInternalServerError,
+			code:   "internal_error",
+		},
+		{
+			name:       "field error",
+			err:        e.InvalidField("sum", "required", "sum is required"),
+			status:     http.StatusBadRequest,
+			code:       "invalid_request",
+			detail:     "sum is required",
+			violations: []e.PolicyViolation{{Field: "sum", Rule: "required", Message: "sum is required"}},
+		},
+		{
+			name:      "mfa challenge",
+			err:       &e.MFAChallengeError{Token: "challenge", ExpiresIn: 5 * time.Minute},
+			status:    http.StatusUnauthorized,
+			code:      "mfa_required",
+			detail:    "two-factor code required",
+			mfaToken:  "challenge",
+			expiresIn: 300,
+		},
+		{
+			name:       "retry after rounds up",
+			err:        &e.RetryAfterError{Err: e.ErrTooManyLoginAttempts, RetryAfter: 1500 * time.Millisecond},
+			status:     http.StatusTooManyRequests,
+			code:       "too_many_login_attempts",
+			detail:     "too many login attempts",
+			retryAfter: "2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/user/orders", nil)
+			r = r.WithContext(context.WithValue(r.Context(), chimw.RequestIDKey, "host/abc-000001"))
+			w := httptest.NewRecorder()
+
+			Write(w, r, tt.err)
+
+			if w.Code != tt.status {
+				t.Errorf("expected status %d, got %d", tt.status, w.Code)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != ContentType {
+				t.Errorf("expected content type %s, got %s", ContentType, ct)
+			}
+			if got := w.Header().Get("Retry-After"); got != tt.retryAfter {
+				t.Errorf("expected Retry-After %q, got %q", tt.retryAfter, got)
+			}
+
+			var p Problem
+			if err := json.NewDecoder(w.Body).Decode(&p); err != nil {
+				t.Fatalf("decode body: %v", err)
+			}
+			want := Problem{
+				Type:      "about:blank",
+				Title:     http.StatusText(tt.status),
+				Status:    tt.status,
+				Detail:    tt.detail,
+				Instance:  "/api/user/orders",
+				Code:      tt.code,
+				RequestID: "host/abc-000001",
+				Errors:    tt.violations,
+				MFAToken:  tt.mfaToken,
+				ExpiresIn: tt.expiresIn,
+			}
+			if !reflect.DeepEqual(p, want) {
+				t.Errorf("expected %+v, got %+v", want, p)
+			}
+		})
+	}
+}
+
+func TestKnown(t *testing.T) {
+	if !Known(fmt.Errorf("wrapped: %w", e.ErrUserNotFound)) {
+		t.Error("wrapped sentinel reported unknown")
+	}
+	if Known(errors.New("connection refused")) {
+		t.Error("plain error reported known")
+	}
+}
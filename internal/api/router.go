@@ -3,9 +3,13 @@ package api
 import (
 	//"github.com/chestorix/gophermart/internal/interfaces"
 	mw "github.com/chestorix/gophermart/internal/api/middleware"
+	"github.com/chestorix/gophermart/internal/api/problem"
+	e "github.com/chestorix/gophermart/internal/errors"
+	"github.com/chestorix/gophermart/internal/models"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/sirupsen/logrus"
+	"net/http"
 )
 
 type Router struct {
@@ -28,19 +32,96 @@ func NewRouter(logger *logrus.Logger) *Router {
 	}
 }
 func (r *Router) SetupRoutes(handler *Handler) {
+	r.Use(mw.Merchant(handler.service))
+	r.Use(mw.Client)
+
+	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		problem.Write(w, r, e.ErrRouteNotFound)
+	})
+	r.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		problem.Write(w, r, e.ErrMethodNotAllowed)
+	})
+
 	r.Group(func(r chi.Router) {
 		r.Post("/api/user/register", handler.Register)
 		r.Post("/api/user/login", handler.Login)
+		r.Post("/api/user/login/mfa", handler.CompleteMFALogin)
+		r.Post("/api/user/token/refresh", handler.RefreshToken)
+		r.Post("/api/user/password/reset", handler.RequestPasswordReset)
+		r.Post("/api/user/password/reset/confirm", handler.ResetPassword)
+	})
+
+	// Routes merchant back-ends may also call with an API key holding the
+	// route's scope.
+	auth := mw.Auth(handler.service, handler.session, handler.logger)
+	r.Group(func(r chi.Router) {
+		r.With(mw.Scope(models.ScopeOrdersWrite), auth).Post("/api/user/orders", handler.UploadOrder)
+		r.With(mw.Scope(models.ScopeOrdersWrite), auth).Post("/api/user/orders/batch", handler.UploadOrders)
+		r.With(mw.Scope(models.ScopeOrdersRead), auth).Get("/api/user/orders", handler.GetUserOrders)
+		r.With(mw.Scope(models.ScopeOrdersRead), auth).Get("/api/user/orders/{number}", handler.GetUserOrder)
+		r.With(mw.Scope(models.ScopeBalanceRead), auth).Get("/api/user/balance", handler.GetUserBalance)
+		r.With(mw.Scope(models.ScopeBalanceRead), auth).Get("/api/user/withdrawals", handler.GetUserWithdrawals)
+		r.With(mw.Scope(models.ScopeBalanceRead), auth).Get("/api/user/history", handler.GetUserHistory)
+		r.With(mw.Scope(models.ScopeWithdraw), auth).Post("/api/user/balance/withdraw", handler.Withdraw)
 	})
 
 	// Protected routes
 	r.Group(func(r chi.Router) {
-		r.Use(mw.Auth(handler.service))
+		r.Use(auth)
+
+		r.Post("/api/user/logout", handler.Logout)
+		r.Post("/api/user/password", handler.ChangePassword)
+		r.Post("/api/user/2fa/totp", handler.EnrollTOTP)
+		r.Post("/api/user/2fa/totp/confirm", handler.ConfirmTOTP)
+		r.Delete("/api/user/2fa/totp", handler.DisableTOTP)
+		r.Post("/api/user/2fa/recovery-codes", handler.RegenerateRecoveryCodes)
+		r.Post("/api/user/vouchers/redeem", handler.RedeemVoucher)
+		r.Get("/api/user/vouchers", handler.GetUserVouchers)
+		r.Get("/api/user/export", handler.ExportUserData)
+		r.Delete("/api/user", handler.CloseAccount)
+		r.Get("/api/user/sessions", handler.GetSessions)
+		r.Delete("/api/user/sessions/{id}", handler.RevokeSession)
+		r.Get("/api/user/events", handler.StreamEvents)
+		r.Get("/api/user/ws", handler.WebSocket)
+	})
+
+	// Admin routes. Support staff can look users up; changes need an admin.
+	// Every call is written to the audit log.
+	r.Route("/api/admin", func(r chi.Router) {
+		r.Use(auth)
+		r.Use(mw.Audit(handler.service, handler.logger))
+		r.Use(mw.RequireRole(handler.service, models.RoleSupport, models.RoleAdmin))
+
+		r.Get("/users", handler.SearchUsers)
+		r.Get("/users/{id}", handler.GetUser)
+		r.Get("/users/{id}/orders", handler.GetUserOrdersAdmin)
+		r.Get("/users/{id}/withdrawals", handler.GetUserWithdrawalsAdmin)
+		r.Get("/users/{id}/balance", handler.GetUserBalanceAdmin)
+		r.Get("/users/{id}/adjustments", handler.GetUserAdjustments)
+		r.Post("/users/{id}/adjustments", handler.CreateAdjustment)
+		r.Get("/adjustments/pending", handler.GetPendingAdjustments)
+		r.Post("/orders/{number}/recheck", handler.RecheckOrder)
+		r.Get("/withdrawals/pending", handler.GetPendingWithdrawals)
+		r.Get("/fraud/signals", handler.GetFraudSignals)
+
+		r.Group(func(r chi.Router) {
+			r.Use(mw.RequireRole(handler.service, models.RoleAdmin))
 
-		r.Post("/api/user/orders", handler.UploadOrder)
-		r.Get("/api/user/orders", handler.GetUserOrders)
-		r.Get("/api/user/balance", handler.GetUserBalance)
-		r.Post("/api/user/balance/withdraw", handler.Withdraw)
-		r.Get("/api/user/withdrawals", handler.GetUserWithdrawals)
+			r.Post("/users/{id}/disable", handler.DisableUser)
+			r.Post("/users/{id}/enable", handler.EnableUser)
+			r.Put("/users/{id}/role", handler.SetUserRole)
+			r.Post("/login-locks/unlock", handler.UnlockLogin)
+			r.Post("/withdrawals/{order}/approve", handler.ApproveWithdrawal)
+			r.Post("/withdrawals/{order}/reject", handler.RejectWithdrawal)
+			r.Post("/fraud/signals/{id}/resolve", handler.ResolveFraudSignal)
+			r.Post("/adjustments/{id}/approve", handler.ApproveAdjustment)
+			r.Post("/adjustments/{id}/reject", handler.RejectAdjustment)
+			r.Post("/vouchers", handler.GenerateVouchers)
+			r.Get("/vouchers/{batch}/export", handler.ExportVoucherBatch)
+			r.Post("/api-keys", handler.CreateAPIKey)
+			r.Get("/api-keys", handler.GetAPIKeys)
+			r.Delete("/api-keys/{id}", handler.RevokeAPIKey)
+			r.Get("/audit-log", handler.GetAuditLog)
+		})
 	})
 }
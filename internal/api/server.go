@@ -2,6 +2,7 @@ package api
 
 import (
 	"context"
+	"github.com/chestorix/gophermart/internal/api/middleware"
 	"github.com/chestorix/gophermart/internal/config"
 	"github.com/chestorix/gophermart/internal/interfaces"
 	"github.com/sirupsen/logrus"
@@ -28,7 +29,18 @@ func NewServer(cfg *config.ServerConfig, service interfaces.Service, logger *log
 
 func (s *Server) Start() error {
 	s.logger.Info("Starting server...")
-	s.router.SetupRoutes(NewHandler(s.service, s.logger, s.cfg.DBURI))
+	handler := NewHandler(s.service, s.logger, s.cfg.DBURI)
+	mode, err := middleware.ParseAuthMode(s.cfg.Session.Mode)
+	if err != nil {
+		return err
+	}
+	handler.session = middleware.Session{
+		Mode:     mode,
+		Secure:   s.cfg.Session.CookieSecure,
+		SameSite: middleware.ParseSameSite(s.cfg.Session.CookieSameSite),
+		Domain:   s.cfg.Session.CookieDomain,
+	}
+	s.router.SetupRoutes(handler)
 	httpServer := &http.Server{
 		Addr:    s.cfg.RunAddress,
 		Handler: s.router,
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/chestorix/gophermart/internal/api/middleware"
+	e "github.com/chestorix/gophermart/internal/errors"
+	"github.com/chestorix/gophermart/internal/models"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"time"
+)
+
+// GetSessions lists the caller's live sessions and marks the one the
+// request was made with.
+func (h *Handler) GetSessions(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.TokenClaimsKey).(models.TokenClaims)
+	if !ok {
+		h.fail(w, r, e.ErrUnauthorized)
+		return
+	}
+
+	sessions, err := h.service.GetSessions(r.Context(), claims.UserID)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+
+	if len(sessions) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	type sessionResponse struct {
+		ID         string    `json:"id"`
+		IP         string    `json:"ip"`
+		UserAgent  string    `json:"user_agent"`
+		CreatedAt  time.Time `json:"created_at"`
+		LastSeenAt time.Time `json:"last_seen_at"`
+		Current    bool      `json:"current"`
+	}
+
+	response := make([]sessionResponse, 0, len(sessions))
+	for _, s := range sessions {
+		response = append(response, sessionResponse{
+			ID:         s.ID,
+			IP:         s.IP,
+			UserAgent:  s.UserAgent,
+			CreatedAt:  s.CreatedAt,
+			LastSeenAt: s.LastSeenAt,
+			Current:    s.ID == claims.FamilyID,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		h.logger.Errorf("encode response failed: %v", err)
+	}
+}
+
+// RevokeSession signs the caller out of one session. Revoking the current
+// session also clears the session cookies.
+func (h *Handler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.TokenClaimsKey).(models.TokenClaims)
+	if !ok {
+		h.fail(w, r, e.ErrUnauthorized)
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	err := h.service.RevokeSession(r.Context(), claims.UserID, id)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	if id == claims.FamilyID {
+		h.session.Clear(w)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,175 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/chestorix/gophermart/internal/api/middleware"
+	e "github.com/chestorix/gophermart/internal/errors"
+	"github.com/chestorix/gophermart/internal/models"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+func (h *Handler) GenerateVouchers(w http.ResponseWriter, r *http.Request) {
+	creatorID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		h.fail(w, r, e.ErrUnauthorized)
+		return
+	}
+
+	var req struct {
+		Count     int        `json:"count"`
+		Amount    float64    `json:"amount"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.fail(w, r, e.ErrInvalidRequest)
+		return
+	}
+
+	batchID, vouchers, err := h.service.GenerateVouchers(r.Context(), creatorID, req.Count, req.Amount, req.ExpiresAt)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+
+	if r.Header.Get("Accept") == "text/csv" {
+		writeVouchersCSV(w, batchID, vouchers)
+		return
+	}
+
+	response := struct {
+		BatchID string `json:"batch_id"`
+		Count   int    `json:"count"`
+	}{
+		BatchID: batchID,
+		Count:   len(vouchers),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		h.logger.Errorf("encode response failed: %v", err)
+	}
+}
+
+// ExportVoucherBatch streams a batch as CSV, including redemption state, so
+// it can be handed to the printing vendor or reconciled later.
+func (h *Handler) ExportVoucherBatch(w http.ResponseWriter, r *http.Request) {
+	batchID := chi.URLParam(r, "batch")
+	vouchers, err := h.service.GetVoucherBatch(r.Context(), batchID)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	if len(vouchers) == 0 {
+		h.fail(w, r, e.ErrVoucherBatchNotFound)
+		return
+	}
+
+	writeVouchersCSV(w, batchID, vouchers)
+}
+
+func writeVouchersCSV(w http.ResponseWriter, batchID string, vouchers []models.Voucher) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=vouchers-%s.csv", batchID))
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"code", "amount", "expires_at", "redeemed_at"})
+	for _, v := range vouchers {
+		cw.Write([]string{
+			v.DisplayCode(),
+			strconv.FormatFloat(v.Amount, 'f', 2, 64),
+			formatOptionalTime(v.ExpiresAt),
+			formatOptionalTime(v.RedeemedAt),
+		})
+	}
+	cw.Flush()
+}
+
+func formatOptionalTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+func (h *Handler) RedeemVoucher(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		h.fail(w, r, e.ErrUnauthorized)
+		return
+	}
+
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		h.fail(w, r, e.ErrInvalidRequest)
+		return
+	}
+
+	voucher, err := h.service.RedeemVoucher(r.Context(), userID, req.Code)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+
+	response := struct {
+		Code   string  `json:"code"`
+		Amount float64 `json:"amount"`
+	}{
+		Code:   voucher.DisplayCode(),
+		Amount: voucher.Amount,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		h.logger.Errorf("encode response failed: %v", err)
+	}
+}
+
+func (h *Handler) GetUserVouchers(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		h.fail(w, r, e.ErrUnauthorized)
+		return
+	}
+
+	vouchers, err := h.service.GetUserVouchers(r.Context(), userID)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+
+	if len(vouchers) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	type voucherResponse struct {
+		Code       string    `json:"code"`
+		Amount     float64   `json:"amount"`
+		RedeemedAt time.Time `json:"redeemed_at"`
+	}
+
+	response := make([]voucherResponse, 0, len(vouchers))
+	for _, v := range vouchers {
+		item := voucherResponse{
+			Code:   v.DisplayCode(),
+			Amount: v.Amount,
+		}
+		if v.RedeemedAt != nil {
+			item.RedeemedAt = *v.RedeemedAt
+		}
+		response = append(response, item)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		h.logger.Errorf("encode response failed: %v", err)
+	}
+}
@@ -0,0 +1,196 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"github.com/chestorix/gophermart/internal/api/middleware"
+	e "github.com/chestorix/gophermart/internal/errors"
+	"github.com/chestorix/gophermart/internal/models"
+	"golang.org/x/net/websocket"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	// wsPingInterval is how often the server sends a ping message; a client
+	// that sends nothing for wsIdleTimeout is disconnected.
+	wsPingInterval = 30 * time.Second
+	wsIdleTimeout  = 75 * time.Second
+	// wsWriteTimeout bounds one write, so a client that stops reading does
+	// not hold its connection open.
+	wsWriteTimeout  = 10 * time.Second
+	wsMaxMessageLen = 4 << 10
+)
+
+// WebSocket topics a client may subscribe to.
+const (
+	wsTopicOrders  = "orders"
+	wsTopicBalance = "balance"
+)
+
+var wsEventTopics = map[models.EventType]string{
+	models.EventOrder:   wsTopicOrders,
+	models.EventBalance: wsTopicBalance,
+}
+
+// wsClientMessage is a message from the client: subscribe, unsubscribe,
+// ping or pong.
+type wsClientMessage struct {
+	Type   string   `json:"type"`
+	Topics []string `json:"topics,omitempty"`
+}
+
+// wsServerMessage is a message to the client: order, balance, subscribed,
+// ping, pong or error.
+type wsServerMessage struct {
+	Type   string   `json:"type"`
+	Topics []string `json:"topics,omitempty"`
+	Data   any      `json:"data,omitempty"`
+	Error  string   `json:"error,omitempty"`
+}
+
+// sameOriginWebSocket accepts clients without an Origin header, such as
+// mobile apps, and browsers only from the API's own origin, so that other
+// sites cannot ride on the session cookie.
+func sameOriginWebSocket(config *websocket.Config, r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	parsed, err := url.Parse(origin)
+	if err != nil || parsed.Host != r.Host {
+		return fmt.Errorf("origin %q not allowed", origin)
+	}
+	config.Origin = parsed
+	return nil
+}
+
+// WebSocket pushes the caller's order and balance updates over a WebSocket.
+// Clients choose topics with subscribe and unsubscribe messages. The
+// connection is closed when the access token expires or is revoked.
+func (h *Handler) WebSocket(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.TokenClaimsKey).(models.TokenClaims)
+	if !ok {
+		h.fail(w, r, e.ErrUnauthorized)
+		return
+	}
+
+	events, release, err := h.service.ConnectWebSocket(r.Context(), claims.UserID)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	defer release()
+
+	server := websocket.Server{
+		Handshake: sameOriginWebSocket,
+		Handler: func(conn *websocket.Conn) {
+			conn.MaxPayloadBytes = wsMaxMessageLen
+			h.serveWebSocket(conn, claims, events)
+		},
+	}
+	server.ServeHTTP(w, r)
+}
+
+func (h *Handler) serveWebSocket(conn *websocket.Conn, claims models.TokenClaims, events <-chan models.UserEvent) {
+	defer conn.Close()
+	ctx, cancel := context.WithCancel(conn.Request().Context())
+	defer cancel()
+
+	messages := make(chan wsClientMessage, 8)
+	go func() {
+		defer cancel()
+		for {
+			conn.SetReadDeadline(time.Now().Add(wsIdleTimeout))
+			var msg wsClientMessage
+			if err := websocket.JSON.Receive(conn, &msg); err != nil {
+				return
+			}
+			select {
+			case messages <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	send := func(msg wsServerMessage) bool {
+		conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		return websocket.JSON.Send(conn, msg) == nil
+	}
+
+	topics := make(map[string]bool)
+	expiry := time.NewTimer(time.Until(claims.ExpiresAt))
+	defer expiry.Stop()
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	for {
+		var reply wsServerMessage
+		select {
+		case <-ctx.Done():
+			return
+		case <-expiry.C:
+			send(wsServerMessage{Type: "error", Error: "access token expired, reconnect"})
+			return
+		case <-ping.C:
+			if !h.streamAuthorized(ctx, claims) {
+				send(wsServerMessage{Type: "error", Error: "session ended"})
+				return
+			}
+			reply = wsServerMessage{Type: "ping"}
+		case event, ok := <-events:
+			if !ok {
+				// The connection fell too far behind; the client has to
+				// reconnect and reload.
+				send(wsServerMessage{Type: "error", Error: "too slow, reconnect"})
+				return
+			}
+			topic := wsEventTopics[event.Type]
+			if !topics[topic] {
+				continue
+			}
+			reply = wsServerMessage{Type: string(event.Type), Data: event.Order}
+			if event.Balance != nil {
+				reply.Data = event.Balance
+			}
+		case msg := <-messages:
+			reply = handleWebSocketMessage(msg, topics)
+			if reply.Type == "" {
+				continue
+			}
+		}
+		if !send(reply) {
+			return
+		}
+	}
+}
+
+// handleWebSocketMessage applies a client message to topics and returns
+// the reply, if any.
+func handleWebSocketMessage(msg wsClientMessage, topics map[string]bool) wsServerMessage {
+	switch msg.Type {
+	case "subscribe", "unsubscribe":
+		for _, topic := range msg.Topics {
+			if topic != wsTopicOrders && topic != wsTopicBalance {
+				return wsServerMessage{Type: "error", Error: fmt.Sprintf("unknown topic %q", topic)}
+			}
+		}
+		for _, topic := range msg.Topics {
+			topics[topic] = msg.Type == "subscribe"
+		}
+		subscribed := make([]string, 0, len(topics))
+		for _, topic := range []string{wsTopicOrders, wsTopicBalance} {
+			if topics[topic] {
+				subscribed = append(subscribed, topic)
+			}
+		}
+		return wsServerMessage{Type: "subscribed", Topics: subscribed}
+	case "ping":
+		return wsServerMessage{Type: "pong"}
+	case "pong":
+		return wsServerMessage{}
+	default:
+		return wsServerMessage{Type: "error", Error: fmt.Sprintf("unknown message type %q", msg.Type)}
+	}
+}
@@ -0,0 +1,22 @@
+// Package client carries the caller's address and user agent through the
+// context, so that sessions can record where they were opened.
+package client
+
+import "context"
+
+type Info struct {
+	IP        string
+	UserAgent string
+}
+
+type contextKey struct{}
+
+func WithInfo(ctx context.Context, info Info) context.Context {
+	return context.WithValue(ctx, contextKey{}, info)
+}
+
+// FromContext returns the Info stored in ctx, or an empty one.
+func FromContext(ctx context.Context) Info {
+	info, _ := ctx.Value(contextKey{}).(Info)
+	return info
+}
@@ -3,13 +3,118 @@ package config
 import (
 	"flag"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type ServerConfig struct {
-	RunAddress string `env:"RUN_ADDRESS"`
-	DBURI      string `env:"DATABASE_URI"`
-	AccSysAddr string `env:"ACCRUAL_SYSTEM_ADDRESS"`
+	RunAddress                  string   `env:"RUN_ADDRESS"`
+	DBURI                       string   `env:"DATABASE_URI"`
+	AccSysAddr                  string   `env:"ACCRUAL_SYSTEM_ADDRESS"`
+	WithdrawalReviewThreshold   float64  `env:"WITHDRAWAL_REVIEW_THRESHOLD"`
+	AdjustmentApprovalThreshold float64  `env:"ADJUSTMENT_APPROVAL_THRESHOLD"`
+	ClosureBalance              string   `env:"ACCOUNT_CLOSURE_BALANCE"`
+	OrderBatchLimit             int      `env:"ORDER_BATCH_LIMIT"`
+	WebSocketConnsPerUser       int      `env:"WS_CONNECTIONS_PER_USER"`
+	AdminLogins                 []string `env:"ADMIN_LOGINS"`
+	OrderValidators             string   `env:"ORDER_VALIDATORS"`
+	JWT                         JWTConfig
+	Session                     SessionConfig
+	Notifier                    string        `env:"NOTIFIER"`
+	PasswordResetTTL            time.Duration `env:"PASSWORD_RESET_TTL"`
+	Fraud                       FraudConfig
+	LoginThrottle               LoginThrottleConfig
+	PasswordHash                PasswordHashConfig
+	CredentialPolicy            CredentialPolicyConfig
+	MFA                         MFAConfig
+}
+
+// MFAConfig controls TOTP two-factor authentication.
+type MFAConfig struct {
+	// ChallengeTTL is how long the second login step may take.
+	ChallengeTTL      time.Duration `env:"MFA_CHALLENGE_TTL"`
+	ChallengeAttempts int           `env:"MFA_CHALLENGE_ATTEMPTS"`
+	// WithdrawalThreshold requires a code for withdrawals above this sum
+	// (0 disables the check).
+	WithdrawalThreshold float64 `env:"MFA_WITHDRAWAL_THRESHOLD"`
+}
+
+// CredentialPolicyConfig is checked at registration and when a password
+// changes. Lengths are in characters; 0 disables a limit.
+type CredentialPolicyConfig struct {
+	LoginMinLength    int    `env:"LOGIN_MIN_LENGTH"`
+	LoginMaxLength    int    `env:"LOGIN_MAX_LENGTH"`
+	LoginPattern      string `env:"LOGIN_PATTERN"`
+	PasswordMinLength int    `env:"PASSWORD_MIN_LENGTH"`
+	PasswordMaxLength int    `env:"PASSWORD_MAX_LENGTH"`
+	// RejectCommonPasswords checks passwords against the bundled list.
+	RejectCommonPasswords bool `env:"REJECT_COMMON_PASSWORDS"`
+}
+
+// PasswordHashConfig holds the argon2id parameters for new password
+// hashes. Hashes made with other parameters are upgraded on login.
+type PasswordHashConfig struct {
+	// Memory is in KiB.
+	Memory      uint `env:"ARGON2_MEMORY"`
+	Iterations  uint `env:"ARGON2_ITERATIONS"`
+	Parallelism uint `env:"ARGON2_PARALLELISM"`
+	// Concurrency caps simultaneous hash computations; 0 means GOMAXPROCS.
+	Concurrency int `env:"ARGON2_CONCURRENCY"`
+}
+
+// LoginThrottleConfig limits password guessing. Failures are counted per
+// login and per IP; counters reset after Window without failures.
+type LoginThrottleConfig struct {
+	Window time.Duration `env:"LOGIN_FAILURE_WINDOW"`
+	// FreeAttempts failures per login are allowed before delays start.
+	FreeAttempts  int           `env:"LOGIN_FREE_ATTEMPTS"`
+	BaseDelay     time.Duration `env:"LOGIN_BASE_DELAY"`
+	MaxDelay      time.Duration `env:"LOGIN_MAX_DELAY"`
+	MaxFailures   int           `env:"LOGIN_MAX_FAILURES"`
+	IPMaxFailures int           `env:"LOGIN_IP_MAX_FAILURES"`
+	Lockout       time.Duration `env:"LOGIN_LOCKOUT"`
+}
+
+// JWTConfig lists where the token signing keys come from. Keys from all
+// sources are combined; the last one listed signs new tokens and every key
+// is accepted when verifying, which allows rotating keys without logging
+// everyone out.
+type JWTConfig struct {
+	// Secret is a single key with the ID "default".
+	Secret string `env:"JWT_SECRET"`
+	// Keys is a comma-separated list of kid:secret pairs.
+	Keys string `env:"JWT_KEYS"`
+	// KeyFile holds one "kid secret" pair per line; lines starting with # are ignored.
+	KeyFile string `env:"JWT_KEY_FILE"`
+
+	AccessTokenTTL  time.Duration `env:"ACCESS_TOKEN_TTL"`
+	RefreshTokenTTL time.Duration `env:"REFRESH_TOKEN_TTL"`
+}
+
+// SessionConfig controls cookie-based sessions for browser clients.
+// Mode is "header" (bearer tokens only) or "cookie" (bearer tokens and
+// HttpOnly cookies with double-submit CSRF protection).
+type SessionConfig struct {
+	Mode           string `env:"AUTH_MODE"`
+	CookieSecure   bool   `env:"COOKIE_SECURE"`
+	CookieSameSite string `env:"COOKIE_SAMESITE"`
+	CookieDomain   string `env:"COOKIE_DOMAIN"`
+}
+
+// FraudConfig holds the thresholds of the order upload fraud rules.
+// A limit of 0 disables the rule; actions are FLAG, THROTTLE or BLOCK.
+// Every rule defaults to FLAG, so uploads are only restricted once an
+// operator configures a stronger action.
+type FraudConfig struct {
+	Window           time.Duration `env:"FRAUD_WINDOW"`
+	ThrottleFor      time.Duration `env:"FRAUD_THROTTLE_FOR"`
+	ConflictLimit    int           `env:"FRAUD_CONFLICT_LIMIT"`
+	ConflictAction   string        `env:"FRAUD_CONFLICT_ACTION"`
+	SequentialLimit  int           `env:"FRAUD_SEQUENTIAL_LIMIT"`
+	SequentialAction string        `env:"FRAUD_SEQUENTIAL_ACTION"`
+	AccountsPerIP    int           `env:"FRAUD_ACCOUNTS_PER_IP"`
+	AccountsAction   string        `env:"FRAUD_ACCOUNTS_PER_IP_ACTION"`
 }
 
 func ensureHTTP(address string) string {
@@ -18,11 +123,69 @@ func ensureHTTP(address string) string {
 	}
 	return address
 }
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 func Load() *ServerConfig {
 	cfg := &ServerConfig{}
+	var adminLogins string
 	flag.StringVar(&cfg.RunAddress, "a", "localhost:8090", "address and port to run server")
 	flag.StringVar(&cfg.DBURI, "d", "", "host=<host> user=<user> password=<password> dbname=<dbname> sslmode=<disable/enable>")
 	flag.StringVar(&cfg.AccSysAddr, "r", "", "accrual system address ")
+	flag.Float64Var(&cfg.WithdrawalReviewThreshold, "withdrawal-review-threshold", 0, "withdrawals above this sum wait for manual review (0 disables review)")
+	flag.Float64Var(&cfg.AdjustmentApprovalThreshold, "adjustment-approval-threshold", 0, "manual balance adjustments above this amount need a second operator (0 disables approval)")
+	flag.StringVar(&cfg.ClosureBalance, "closure-balance", "forfeit", "what happens to the balance of a closed account: forfeit or payout")
+	flag.IntVar(&cfg.OrderBatchLimit, "order-batch-limit", 1000, "maximum number of order numbers in one batch upload")
+	flag.IntVar(&cfg.WebSocketConnsPerUser, "ws-connections-per-user", 5, "maximum number of WebSocket connections one user may hold on a replica")
+	flag.StringVar(&adminLogins, "admins", "", "comma-separated admin logins as merchant:login, or a bare login for the default merchant")
+	flag.StringVar(&cfg.JWT.Secret, "jwt-secret", "", "token signing key")
+	flag.StringVar(&cfg.JWT.Keys, "jwt-keys", "", "token signing keys as kid:secret pairs separated by commas, newest last")
+	flag.StringVar(&cfg.JWT.KeyFile, "jwt-key-file", "", "file with one \"kid secret\" pair per line, newest last")
+	flag.DurationVar(&cfg.JWT.AccessTokenTTL, "access-token-ttl", 15*time.Minute, "access token lifetime")
+	flag.DurationVar(&cfg.JWT.RefreshTokenTTL, "refresh-token-ttl", 30*24*time.Hour, "refresh token lifetime")
+	flag.StringVar(&cfg.Session.Mode, "auth-mode", "header", "how clients authenticate: header or cookie")
+	flag.BoolVar(&cfg.Session.CookieSecure, "cookie-secure", true, "set the Secure attribute on session cookies")
+	flag.StringVar(&cfg.Session.CookieSameSite, "cookie-samesite", "lax", "SameSite attribute of session cookies: lax, strict or none")
+	flag.StringVar(&cfg.Session.CookieDomain, "cookie-domain", "", "Domain attribute of session cookies")
+	flag.StringVar(&cfg.Notifier, "notifier", "log", "where user notifications go: log (records only that one was sent) or file:<path>")
+	flag.DurationVar(&cfg.PasswordResetTTL, "password-reset-ttl", time.Hour, "password reset token lifetime")
+	flag.StringVar(&cfg.OrderValidators, "order-validators", "luhn", "order number validators separated by ';', e.g. luhn;length:10-19;prefix:2|5;regex:^[0-9]+$")
+	flag.DurationVar(&cfg.Fraud.Window, "fraud-window", time.Hour, "time window the fraud rules look back over")
+	flag.DurationVar(&cfg.Fraud.ThrottleFor, "fraud-throttle-for", 15*time.Minute, "how long a THROTTLE verdict blocks uploads")
+	flag.IntVar(&cfg.Fraud.ConflictLimit, "fraud-conflict-limit", 5, "uploads rejected with 409 per account within the window")
+	flag.StringVar(&cfg.Fraud.ConflictAction, "fraud-conflict-action", "FLAG", "action for the conflict rule")
+	flag.IntVar(&cfg.Fraud.SequentialLimit, "fraud-sequential-limit", 5, "sequential order numbers per account within the window")
+	flag.StringVar(&cfg.Fraud.SequentialAction, "fraud-sequential-action", "FLAG", "action for the sequential numbers rule")
+	flag.IntVar(&cfg.Fraud.AccountsPerIP, "fraud-accounts-per-ip", 5, "distinct accounts uploading from one IP within the window")
+	flag.StringVar(&cfg.Fraud.AccountsAction, "fraud-accounts-per-ip-action", "FLAG", "action for the accounts per IP rule")
+	flag.DurationVar(&cfg.LoginThrottle.Window, "login-failure-window", 15*time.Minute, "failed logins older than this are forgotten")
+	flag.IntVar(&cfg.LoginThrottle.FreeAttempts, "login-free-attempts", 3, "failed logins per account before delays start")
+	flag.DurationVar(&cfg.LoginThrottle.BaseDelay, "login-base-delay", time.Second, "first delay after the free attempts, doubled on every failure")
+	flag.DurationVar(&cfg.LoginThrottle.MaxDelay, "login-max-delay", time.Minute, "longest progressive delay")
+	flag.IntVar(&cfg.LoginThrottle.MaxFailures, "login-max-failures", 10, "failed logins per account before a lockout")
+	flag.IntVar(&cfg.LoginThrottle.IPMaxFailures, "login-ip-max-failures", 50, "failed logins per IP before a lockout")
+	flag.DurationVar(&cfg.LoginThrottle.Lockout, "login-lockout", 15*time.Minute, "lockout duration")
+	flag.UintVar(&cfg.PasswordHash.Memory, "argon2-memory", 64*1024, "argon2id memory in KiB")
+	flag.UintVar(&cfg.PasswordHash.Iterations, "argon2-iterations", 3, "argon2id iterations")
+	flag.UintVar(&cfg.PasswordHash.Parallelism, "argon2-parallelism", 2, "argon2id threads")
+	flag.IntVar(&cfg.PasswordHash.Concurrency, "argon2-concurrency", 0, "password hashes computed at once, each holding argon2-memory (0 means GOMAXPROCS)")
+	flag.IntVar(&cfg.CredentialPolicy.LoginMinLength, "login-min-length", 3, "shortest allowed login")
+	flag.IntVar(&cfg.CredentialPolicy.LoginMaxLength, "login-max-length", 64, "longest allowed login")
+	flag.StringVar(&cfg.CredentialPolicy.LoginPattern, "login-pattern", `^[\p{L}\p{N}._@-]+$`, "regular expression a normalized login must match")
+	flag.IntVar(&cfg.CredentialPolicy.PasswordMinLength, "password-min-length", 8, "shortest allowed password")
+	flag.IntVar(&cfg.CredentialPolicy.PasswordMaxLength, "password-max-length", 256, "longest allowed password")
+	flag.BoolVar(&cfg.CredentialPolicy.RejectCommonPasswords, "reject-common-passwords", true, "reject passwords from the bundled list of common passwords")
+	flag.DurationVar(&cfg.MFA.ChallengeTTL, "mfa-challenge-ttl", 5*time.Minute, "time to enter the two-factor code after the password")
+	flag.IntVar(&cfg.MFA.ChallengeAttempts, "mfa-challenge-attempts", 5, "wrong two-factor codes allowed per login challenge")
+	flag.Float64Var(&cfg.MFA.WithdrawalThreshold, "mfa-withdrawal-threshold", 0, "withdrawals above this sum need a two-factor code (0 disables)")
 	flag.Parse()
 
 	if envRunAddress := os.Getenv("RUN_ADDRESS"); envRunAddress != "" {
@@ -35,5 +198,106 @@ func Load() *ServerConfig {
 		cfg.AccSysAddr = ensureHTTP(envAccSysAddr)
 
 	}
+	if envThreshold := os.Getenv("WITHDRAWAL_REVIEW_THRESHOLD"); envThreshold != "" {
+		if threshold, err := strconv.ParseFloat(envThreshold, 64); err == nil {
+			cfg.WithdrawalReviewThreshold = threshold
+		}
+	}
+	envFloat("ADJUSTMENT_APPROVAL_THRESHOLD", &cfg.AdjustmentApprovalThreshold)
+	envString("ACCOUNT_CLOSURE_BALANCE", &cfg.ClosureBalance)
+	envInt("ORDER_BATCH_LIMIT", &cfg.OrderBatchLimit)
+	envInt("WS_CONNECTIONS_PER_USER", &cfg.WebSocketConnsPerUser)
+	if envAdminLogins := os.Getenv("ADMIN_LOGINS"); envAdminLogins != "" {
+		adminLogins = envAdminLogins
+	}
+	cfg.AdminLogins = splitList(adminLogins)
+
+	envString("ORDER_VALIDATORS", &cfg.OrderValidators)
+	envString("NOTIFIER", &cfg.Notifier)
+	envDuration("PASSWORD_RESET_TTL", &cfg.PasswordResetTTL)
+	envString("AUTH_MODE", &cfg.Session.Mode)
+	envBool("COOKIE_SECURE", &cfg.Session.CookieSecure)
+	envString("COOKIE_SAMESITE", &cfg.Session.CookieSameSite)
+	envString("COOKIE_DOMAIN", &cfg.Session.CookieDomain)
+	envString("JWT_SECRET", &cfg.JWT.Secret)
+	envString("JWT_KEYS", &cfg.JWT.Keys)
+	envString("JWT_KEY_FILE", &cfg.JWT.KeyFile)
+	envDuration("ACCESS_TOKEN_TTL", &cfg.JWT.AccessTokenTTL)
+	envDuration("REFRESH_TOKEN_TTL", &cfg.JWT.RefreshTokenTTL)
+	envDuration("LOGIN_FAILURE_WINDOW", &cfg.LoginThrottle.Window)
+	envInt("LOGIN_FREE_ATTEMPTS", &cfg.LoginThrottle.FreeAttempts)
+	envDuration("LOGIN_BASE_DELAY", &cfg.LoginThrottle.BaseDelay)
+	envDuration("LOGIN_MAX_DELAY", &cfg.LoginThrottle.MaxDelay)
+	envInt("LOGIN_MAX_FAILURES", &cfg.LoginThrottle.MaxFailures)
+	envInt("LOGIN_IP_MAX_FAILURES", &cfg.LoginThrottle.IPMaxFailures)
+	envDuration("LOGIN_LOCKOUT", &cfg.LoginThrottle.Lockout)
+	envInt("LOGIN_MIN_LENGTH", &cfg.CredentialPolicy.LoginMinLength)
+	envInt("LOGIN_MAX_LENGTH", &cfg.CredentialPolicy.LoginMaxLength)
+	envString("LOGIN_PATTERN", &cfg.CredentialPolicy.LoginPattern)
+	envInt("PASSWORD_MIN_LENGTH", &cfg.CredentialPolicy.PasswordMinLength)
+	envInt("PASSWORD_MAX_LENGTH", &cfg.CredentialPolicy.PasswordMaxLength)
+	envBool("REJECT_COMMON_PASSWORDS", &cfg.CredentialPolicy.RejectCommonPasswords)
+	envDuration("MFA_CHALLENGE_TTL", &cfg.MFA.ChallengeTTL)
+	envInt("MFA_CHALLENGE_ATTEMPTS", &cfg.MFA.ChallengeAttempts)
+	envFloat("MFA_WITHDRAWAL_THRESHOLD", &cfg.MFA.WithdrawalThreshold)
+	envUint("ARGON2_MEMORY", &cfg.PasswordHash.Memory)
+	envUint("ARGON2_ITERATIONS", &cfg.PasswordHash.Iterations)
+	envUint("ARGON2_PARALLELISM", &cfg.PasswordHash.Parallelism)
+	envInt("ARGON2_CONCURRENCY", &cfg.PasswordHash.Concurrency)
+
+	envDuration("FRAUD_WINDOW", &cfg.Fraud.Window)
+	envDuration("FRAUD_THROTTLE_FOR", &cfg.Fraud.ThrottleFor)
+	envInt("FRAUD_CONFLICT_LIMIT", &cfg.Fraud.ConflictLimit)
+	envString("FRAUD_CONFLICT_ACTION", &cfg.Fraud.ConflictAction)
+	envInt("FRAUD_SEQUENTIAL_LIMIT", &cfg.Fraud.SequentialLimit)
+	envString("FRAUD_SEQUENTIAL_ACTION", &cfg.Fraud.SequentialAction)
+	envInt("FRAUD_ACCOUNTS_PER_IP", &cfg.Fraud.AccountsPerIP)
+	envString("FRAUD_ACCOUNTS_PER_IP_ACTION", &cfg.Fraud.AccountsAction)
 	return cfg
 }
+
+func envString(name string, dst *string) {
+	if value := os.Getenv(name); value != "" {
+		*dst = value
+	}
+}
+
+func envInt(name string, dst *int) {
+	if value := os.Getenv(name); value != "" {
+		if parsed, err := strconv.Atoi(value); err == nil {
+			*dst = parsed
+		}
+	}
+}
+
+func envFloat(name string, dst *float64) {
+	if value := os.Getenv(name); value != "" {
+		if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+			*dst = parsed
+		}
+	}
+}
+
+func envUint(name string, dst *uint) {
+	if value := os.Getenv(name); value != "" {
+		if parsed, err := strconv.ParseUint(value, 10, 32); err == nil {
+			*dst = uint(parsed)
+		}
+	}
+}
+
+func envDuration(name string, dst *time.Duration) {
+	if value := os.Getenv(name); value != "" {
+		if parsed, err := time.ParseDuration(value); err == nil {
+			*dst = parsed
+		}
+	}
+}
+
+func envBool(name string, dst *bool) {
+	if value := os.Getenv(name); value != "" {
+		if parsed, err := strconv.ParseBool(value); err == nil {
+			*dst = parsed
+		}
+	}
+}
@@ -1,16 +1,154 @@
 package errors
 
-import "errors"
+import (
+	"errors"
+	"strings"
+	"time"
+)
 
 var (
 	ErrUserAlreadyExists                 = errors.New("user already exists")
+	ErrUserNotFound                      = errors.New("user not found")
+	ErrUserDisabled                      = errors.New("user disabled")
 	ErrInvalidCredentials                = errors.New("invalid credentials")
+	ErrWrongPassword                     = errors.New("wrong password")
+	ErrTooManyLoginAttempts              = errors.New("too many login attempts")
 	ErrOrderAlreadyUploadedByUser        = errors.New("order already uploaded by user")
 	ErrOrderAlreadyUploadedByAnotherUser = errors.New("order already uploaded by another user")
 	ErrInvalidOrderNumber                = errors.New("invalid order number")
 	ErrInsufficientFunds                 = errors.New("insufficient funds")
+	ErrAdjustmentExceedsBalance          = errors.New("debit exceeds the user's balance")
 	ErrOrderNotRegistered                = errors.New("order not registered")
 	ErrInvalidTokenClaim                 = errors.New("invalid token claim")
 	ErrInvalidToken                      = errors.New("invalid token")
 	ErrUnexpectedSignMethod              = errors.New("unexpected signing method")
+	ErrUnknownSigningKey                 = errors.New("unknown signing key")
+	ErrTokenRevoked                      = errors.New("token revoked")
+	ErrInvalidRefreshToken               = errors.New("invalid refresh token")
+	ErrRefreshTokenReused                = errors.New("refresh token reused")
+	ErrPolicyViolation                   = errors.New("credentials do not meet the policy")
+	ErrInvalidResetToken                 = errors.New("invalid or expired reset token")
+	ErrWithdrawalNotFound                = errors.New("withdrawal not found")
+	ErrWithdrawalNotPending              = errors.New("withdrawal is not pending review")
+	ErrWithdrawalAlreadyExists           = errors.New("order number already used for a withdrawal")
+	ErrForbidden                         = errors.New("forbidden")
+	ErrAccountBlocked                    = errors.New("account blocked")
+	ErrTooManyRequests                   = errors.New("too many requests")
+	ErrUnknownMerchant                   = errors.New("unknown merchant")
+	ErrVoucherNotFound                   = errors.New("voucher not found")
+	ErrVoucherAlreadyRedeemed            = errors.New("voucher already redeemed")
+	ErrVoucherExpired                    = errors.New("voucher expired")
+	ErrInvalidVoucherBatch               = errors.New("invalid voucher batch")
+	ErrVoucherBatchNotFound              = errors.New("voucher batch not found")
+	ErrFraudSignalNotFound               = errors.New("fraud signal not found or already resolved")
+	ErrMFARequired                       = errors.New("two-factor code required")
+	ErrInvalidMFACode                    = errors.New("invalid two-factor code")
+	ErrInvalidMFALoginCode               = errors.New("invalid two-factor code")
+	ErrInvalidTOTPSetupCode              = errors.New("code does not match the new authenticator")
+	ErrInvalidMFAChallenge               = errors.New("invalid or expired mfa challenge")
+	ErrTOTPAlreadyEnabled                = errors.New("two-factor authentication is already enabled")
+	ErrTOTPNotEnrolled                   = errors.New("two-factor authentication is not enabled")
+	ErrWithdrawalNeedsMFA                = errors.New("withdrawals above the limit need two-factor authentication")
+	ErrInvalidAPIKey                     = errors.New("invalid api key")
+	ErrAPIKeyNotFound                    = errors.New("api key not found or already revoked")
+	ErrInvalidAPIKeyRequest              = errors.New("api key needs a name and known scopes")
+	ErrOrderNotFound                     = errors.New("order not found")
+	ErrInvalidRole                       = errors.New("invalid role")
+	ErrInvalidAdjustment                 = errors.New("adjustment needs a non-zero amount, a known reason code and a comment")
+	ErrAdjustmentNotFound                = errors.New("adjustment not found")
+	ErrAdjustmentNotPending              = errors.New("adjustment is not pending approval")
+	ErrSelfApproval                      = errors.New("adjustments must be approved by another operator")
+	ErrInvalidClosureBalance             = errors.New("account closure balance must be forfeit or payout")
+	ErrInvalidAuthMode                   = errors.New("auth mode must be header or cookie")
+	ErrSessionNotFound                   = errors.New("session not found")
+	ErrSessionRevoked                    = errors.New("session revoked")
+	ErrInvalidPageQuery                  = errors.New("invalid limit, cursor, status, from, to or sort parameter")
+	ErrEmptyBatch                        = errors.New("no order numbers in batch")
+	ErrBatchTooLarge                     = errors.New("too many order numbers in batch")
+	ErrTooManyConnections                = errors.New("too many open connections")
+	ErrInvalidRequest                    = errors.New("invalid request format")
+	ErrRequestTooLarge                   = errors.New("request body too large")
+	ErrUnauthorized                      = errors.New("unauthorized")
+	ErrInvalidCSRFToken                  = errors.New("invalid csrf token")
+	ErrAPIKeyNotAccepted                 = errors.New("api keys are not accepted here")
+	ErrAPIKeyScope                       = errors.New("api key lacks scope")
+	ErrUserLoginRequired                 = errors.New("X-User-Login header is required")
+	ErrAccrualUnavailable                = errors.New("accrual system unavailable")
+	ErrRouteNotFound                     = errors.New("route not found")
+	ErrMethodNotAllowed                  = errors.New("method not allowed")
 )
+
+// PolicyViolation is one failed credential policy rule.
+type PolicyViolation struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// PolicyError lists every rule a login or password failed.
+type PolicyError struct {
+	Violations []PolicyViolation
+}
+
+func (p *PolicyError) Error() string {
+	rules := make([]string, 0, len(p.Violations))
+	for _, v := range p.Violations {
+		rules = append(rules, v.Rule)
+	}
+	return ErrPolicyViolation.Error() + ": " + strings.Join(rules, ", ")
+}
+
+func (p *PolicyError) Unwrap() error {
+	return ErrPolicyViolation
+}
+
+// FieldError reports request fields that are missing or malformed.
+type FieldError struct {
+	Violations []PolicyViolation
+}
+
+// InvalidField returns a FieldError for a single field.
+func InvalidField(field, rule, message string) *FieldError {
+	return &FieldError{Violations: []PolicyViolation{{Field: field, Rule: rule, Message: message}}}
+}
+
+func (f *FieldError) Error() string {
+	messages := make([]string, 0, len(f.Violations))
+	for _, v := range f.Violations {
+		messages = append(messages, v.Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (f *FieldError) Unwrap() error {
+	return ErrInvalidRequest
+}
+
+// MFAChallengeError is returned by Login for users with 2FA enabled. Token
+// is exchanged for the real tokens together with a TOTP or recovery code.
+type MFAChallengeError struct {
+	Token     string
+	ExpiresIn time.Duration
+}
+
+func (m *MFAChallengeError) Error() string {
+	return ErrMFARequired.Error()
+}
+
+func (m *MFAChallengeError) Unwrap() error {
+	return ErrMFARequired
+}
+
+// RetryAfterError is returned when a caller must wait before trying again.
+type RetryAfterError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (r *RetryAfterError) Error() string {
+	return r.Err.Error()
+}
+
+func (r *RetryAfterError) Unwrap() error {
+	return r.Err
+}
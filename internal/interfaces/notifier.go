@@ -0,0 +1,11 @@
+package interfaces
+
+import (
+	"context"
+	"github.com/chestorix/gophermart/internal/models"
+	"time"
+)
+
+type Notifier interface {
+	NotifyPasswordReset(ctx context.Context, user models.User, token string, expiresAt time.Time) error
+}
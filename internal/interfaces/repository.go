@@ -3,20 +3,94 @@ package interfaces
 import (
 	"context"
 	"github.com/chestorix/gophermart/internal/models"
+	"time"
 )
 
 type Repository interface {
 	Test() string
-	CreateUser(ctx context.Context, user models.User) error
+	GetMerchants(ctx context.Context) ([]models.Merchant, error)
+
+	CreateUser(ctx context.Context, user models.User) (int, error)
 	GetUserByLogin(ctx context.Context, login string) (models.User, error)
+	LoginTaken(ctx context.Context, login string) (bool, error)
+	GetUserByID(ctx context.Context, id int) (models.User, error)
+	UpdatePassword(ctx context.Context, userID int, passwordHash string) error
+	RehashPassword(ctx context.Context, userID int, oldHash, newHash string) error
+
+	ReserveLoginAttempt(ctx context.Context, key string, window time.Duration, delays []time.Duration) (models.LoginThrottle, error)
+	ReleaseLoginAttempt(ctx context.Context, key string, delays []time.Duration) error
+	ClearLoginFailures(ctx context.Context, key string) error
+
+	SetTOTPSecret(ctx context.Context, userID int, secret string) error
+	GetUserTOTP(ctx context.Context, userID int) (models.UserTOTP, error)
+	EnableTOTP(ctx context.Context, userID int) error
+	DisableTOTP(ctx context.Context, userID int) error
+	UseTOTPStep(ctx context.Context, userID int, step int64) (bool, error)
+	ReplaceRecoveryCodes(ctx context.Context, userID int, codeHashes []string) error
+	UseRecoveryCode(ctx context.Context, userID int, codeHash string) error
+	CreateMFAChallenge(ctx context.Context, userID int, tokenHash string, expiresAt time.Time) error
+	ReserveMFAChallengeAttempt(ctx context.Context, tokenHash string, maxAttempts int) (models.MFAChallenge, error)
+	DeleteMFAChallenge(ctx context.Context, tokenHash string) error
+
+	CreateAPIKey(ctx context.Context, key models.APIKey) (models.APIKey, error)
+	GetAPIKeys(ctx context.Context) ([]models.APIKey, error)
+	UseAPIKey(ctx context.Context, keyHash string) (models.APIKey, error)
+	RevokeAPIKey(ctx context.Context, id int) error
+
+	SearchUsers(ctx context.Context, query string, limit int) ([]models.User, error)
+	SetUserRole(ctx context.Context, userID int, role models.Role) error
+	SetUserDisabled(ctx context.Context, userID int, disabled bool) error
+	CloseUser(ctx context.Context, userID int, pseudonym string, settlement models.ClosureBalance) error
+	CreateAuditEntry(ctx context.Context, entry models.AuditEntry) error
+	GetAuditLog(ctx context.Context, actorID, limit int) ([]models.AuditEntry, error)
+	GetUserAuditLog(ctx context.Context, userID, limit int) ([]models.AuditEntry, error)
 
 	CreateOrder(ctx context.Context, order models.Order) error
+	CreateOrders(ctx context.Context, userID int, numbers []string) ([]string, error)
+	GetOrdersByNumbers(ctx context.Context, numbers []string) ([]models.Order, error)
 	GetOrderByNumber(ctx context.Context, number string) (models.Order, error)
-	GetOrdersByUserID(ctx context.Context, userID int) ([]models.Order, error)
+	GetOrdersByUserID(ctx context.Context, userID int, q models.PageQuery) ([]models.Order, error)
 	UpdateOrder(ctx context.Context, order models.Order) error
 	GetOrdersToProcess(ctx context.Context, limit int) ([]models.Order, error)
 
 	CreateWithdrawal(ctx context.Context, withdrawal models.Withdrawal) error
-	GetWithdrawalsByUserID(ctx context.Context, userID int) ([]models.Withdrawal, error)
+	GetWithdrawalsByUserID(ctx context.Context, userID int, q models.PageQuery) ([]models.Withdrawal, error)
 	GetUserBalance(ctx context.Context, userID int) (current, withdrawn float64, err error)
+	GetWithdrawalsByStatus(ctx context.Context, status models.WithdrawalStatus) ([]models.Withdrawal, error)
+	ReviewWithdrawal(ctx context.Context, review models.WithdrawalReview) error
+
+	CreateAdjustment(ctx context.Context, adj models.BalanceAdjustment) (models.BalanceAdjustment, error)
+	GetAdjustment(ctx context.Context, id int) (models.BalanceAdjustment, error)
+	GetAdjustments(ctx context.Context, userID int, status models.AdjustmentStatus) ([]models.BalanceAdjustment, error)
+	ReviewAdjustment(ctx context.Context, id, reviewerID int, decision models.ReviewDecision) (models.BalanceAdjustment, error)
+
+	RecordUploadAttempts(ctx context.Context, attempts []models.UploadAttempt) error
+	CountUploadAttempts(ctx context.Context, userID int, result models.UploadResult, since time.Time) (int, error)
+	GetRecentUploadNumbers(ctx context.Context, userID int, since time.Time) ([]string, error)
+	CountUsersByIP(ctx context.Context, ip string, since time.Time) (int, error)
+	CreateFraudSignal(ctx context.Context, signal models.FraudSignal) error
+	GetFraudSignals(ctx context.Context, userID int, unresolvedOnly bool) ([]models.FraudSignal, error)
+	ResolveFraudSignal(ctx context.Context, id, reviewerID int, resolution string) error
+
+	CreateVouchers(ctx context.Context, vouchers []models.Voucher) error
+	GetVouchersByBatch(ctx context.Context, batchID string) ([]models.Voucher, error)
+	GetVouchersByRedeemer(ctx context.Context, userID int) ([]models.Voucher, error)
+	RedeemVoucher(ctx context.Context, code string, userID int) (models.Voucher, error)
+
+	CreateRefreshToken(ctx context.Context, token models.RefreshToken) error
+	UseRefreshToken(ctx context.Context, tokenHash string) (models.RefreshToken, error)
+	RevokeRefreshTokenFamily(ctx context.Context, familyID string) error
+	RevokeUserRefreshTokens(ctx context.Context, userID int, exceptFamilyID string) error
+	RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error
+	IsTokenRevoked(ctx context.Context, jti string) (bool, error)
+	CreatePasswordResetToken(ctx context.Context, userID int, tokenHash string, expiresAt time.Time) error
+	UsePasswordResetToken(ctx context.Context, tokenHash string) (int, error)
+
+	CreateSession(ctx context.Context, session models.Session) error
+	TouchSession(ctx context.Context, session models.Session) (bool, error)
+	GetSessions(ctx context.Context, userID int) ([]models.Session, error)
+	RevokeSession(ctx context.Context, userID int, id string) error
+
+	PublishEvent(ctx context.Context, event models.UserEvent) error
+	ListenEvents(ctx context.Context, handle func(models.UserEvent)) error
 }
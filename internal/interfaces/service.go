@@ -3,21 +3,74 @@ package interfaces
 import (
 	"context"
 	"github.com/chestorix/gophermart/internal/models"
+	"time"
 )
 
 type Service interface {
 	Test() string
 
-	Register(ctx context.Context, login, password string) (string, error)
-	Login(ctx context.Context, login, password string) (string, error)
+	Register(ctx context.Context, login, password string) (models.TokenPair, error)
+	Login(ctx context.Context, login, password, ip string) (models.TokenPair, error)
+	RefreshToken(ctx context.Context, refreshToken string) (models.TokenPair, error)
+	Logout(ctx context.Context, claims models.TokenClaims) error
+	ChangePassword(ctx context.Context, claims models.TokenClaims, currentPassword, newPassword string) (models.TokenPair, error)
+	RequestPasswordReset(ctx context.Context, login string) error
+	ResetPassword(ctx context.Context, token, newPassword string) error
 	GetUserByLogin(ctx context.Context, login string) (models.User, error)
+	ExportUserData(ctx context.Context, userID int) (models.UserExport, error)
+	CloseAccount(ctx context.Context, userID int, password, mfaCode string) error
 
-	UploadOrder(ctx context.Context, userID int, orderNumber string) error
-	GetUserOrders(ctx context.Context, userID int) ([]models.Order, error)
+	UploadOrder(ctx context.Context, userID int, orderNumber, ip string) error
+	UploadOrders(ctx context.Context, userID int, numbers []string, ip string) ([]models.OrderUploadItem, error)
+	GetUserOrder(ctx context.Context, userID int, orderNumber string) (models.Order, error)
+	GetUserOrders(ctx context.Context, userID int, q models.PageQuery) ([]models.Order, *models.PageCursor, error)
 
-	Withdraw(ctx context.Context, userID int, orderNumber string, sum float64) error
-	GetUserWithdrawals(ctx context.Context, userID int) ([]models.Withdrawal, error)
+	Withdraw(ctx context.Context, userID int, orderNumber string, sum float64, mfaCode string) (models.WithdrawalStatus, error)
+	GetUserWithdrawals(ctx context.Context, userID int, q models.PageQuery) ([]models.Withdrawal, *models.PageCursor, error)
 	GetUserBalance(ctx context.Context, userID int) (current, withdrawn float64, err error)
 
-	ValidateToken(tokenString string) (string, error)
+	ValidateToken(ctx context.Context, tokenString string) (models.TokenClaims, error)
+	TouchSession(ctx context.Context, claims models.TokenClaims) error
+	RevalidateToken(ctx context.Context, claims models.TokenClaims) error
+	GetSessions(ctx context.Context, userID int) ([]models.Session, error)
+	RevokeSession(ctx context.Context, userID int, id string) error
+	SubscribeEvents(ctx context.Context, userID int) (<-chan models.UserEvent, func())
+	ConnectWebSocket(ctx context.Context, userID int) (<-chan models.UserEvent, func(), error)
+	ResolveMerchant(ctx context.Context, code, host string) (models.Merchant, error)
+
+	UserRole(ctx context.Context, userID int) (models.Role, error)
+	GetUser(ctx context.Context, userID int) (models.User, error)
+	SearchUsers(ctx context.Context, query string, limit int) ([]models.User, error)
+	SetUserRole(ctx context.Context, userID int, role models.Role) error
+	SetUserDisabled(ctx context.Context, userID int, disabled bool) error
+	RecheckOrder(ctx context.Context, orderNumber string) (models.Order, error)
+	RecordAudit(ctx context.Context, entry models.AuditEntry) error
+	GetAuditLog(ctx context.Context, actorID, limit int) ([]models.AuditEntry, error)
+	UnlockLogin(ctx context.Context, login, ip string) error
+
+	EnrollTOTP(ctx context.Context, userID int) (models.TOTPEnrollment, error)
+	ConfirmTOTP(ctx context.Context, userID int, code string) ([]string, error)
+	DisableTOTP(ctx context.Context, userID int, code string) error
+	RegenerateRecoveryCodes(ctx context.Context, userID int, code string) ([]string, error)
+	CompleteMFALogin(ctx context.Context, mfaToken, code, ip string) (models.TokenPair, error)
+
+	CreateAPIKey(ctx context.Context, creatorID int, name string, scopes []string) (string, models.APIKey, error)
+	GetAPIKeys(ctx context.Context) ([]models.APIKey, error)
+	RevokeAPIKey(ctx context.Context, id int) error
+	AuthenticateAPIKey(ctx context.Context, key string) (models.APIKey, error)
+	GetPendingWithdrawals(ctx context.Context) ([]models.Withdrawal, error)
+	ReviewWithdrawal(ctx context.Context, reviewerID int, orderNumber string, decision models.ReviewDecision, reason string) error
+	GetFraudSignals(ctx context.Context, userID int, unresolvedOnly bool) ([]models.FraudSignal, error)
+	ResolveFraudSignal(ctx context.Context, id, reviewerID int, resolution string) error
+
+	CreateAdjustment(ctx context.Context, adj models.BalanceAdjustment) (models.BalanceAdjustment, error)
+	GetUserAdjustments(ctx context.Context, userID int) ([]models.BalanceAdjustment, error)
+	GetPendingAdjustments(ctx context.Context) ([]models.BalanceAdjustment, error)
+	ReviewAdjustment(ctx context.Context, id, reviewerID int, decision models.ReviewDecision) (models.BalanceAdjustment, error)
+	GetUserHistory(ctx context.Context, userID int) ([]models.HistoryEntry, error)
+
+	GenerateVouchers(ctx context.Context, creatorID, count int, amount float64, expiresAt *time.Time) (string, []models.Voucher, error)
+	GetVoucherBatch(ctx context.Context, batchID string) ([]models.Voucher, error)
+	RedeemVoucher(ctx context.Context, userID int, code string) (models.Voucher, error)
+	GetUserVouchers(ctx context.Context, userID int) ([]models.Voucher, error)
 }
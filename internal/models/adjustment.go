@@ -0,0 +1,48 @@
+package models
+
+import "time"
+
+type AdjustmentStatus string
+
+const (
+	AdjustmentStatusApplied         AdjustmentStatus = "APPLIED"
+	AdjustmentStatusPendingApproval AdjustmentStatus = "PENDING_APPROVAL"
+	AdjustmentStatusRejected        AdjustmentStatus = "REJECTED"
+)
+
+type AdjustmentReason string
+
+const (
+	AdjustmentReasonGoodwill     AdjustmentReason = "GOODWILL"
+	AdjustmentReasonCorrection   AdjustmentReason = "CORRECTION"
+	AdjustmentReasonCompensation AdjustmentReason = "COMPENSATION"
+	AdjustmentReasonOther        AdjustmentReason = "OTHER"
+	// AdjustmentReasonAccountClosure is booked by the system when a closed
+	// account forfeits its balance; operators cannot use it.
+	AdjustmentReasonAccountClosure AdjustmentReason = "ACCOUNT_CLOSURE"
+)
+
+func (r AdjustmentReason) Valid() bool {
+	switch r {
+	case AdjustmentReasonGoodwill, AdjustmentReasonCorrection, AdjustmentReasonCompensation, AdjustmentReasonOther:
+		return true
+	}
+	return false
+}
+
+// BalanceAdjustment is a manual change of a user's balance by an operator.
+// Amount is signed. Only APPLIED adjustments count towards the balance;
+// large ones wait in PENDING_APPROVAL for a second operator.
+type BalanceAdjustment struct {
+	ID         int
+	UserID     int
+	MerchantID int
+	Amount     float64
+	Reason     AdjustmentReason
+	Comment    string
+	CreatedBy  int
+	Status     AdjustmentStatus
+	ReviewedBy *int
+	ReviewedAt *time.Time
+	CreatedAt  time.Time
+}
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// AuditEntry records one admin API call, including ones that were denied.
+type AuditEntry struct {
+	ID      int
+	ActorID int
+	Method  string
+	// Route is the route pattern, e.g. /api/admin/users/{id}/disable; Path
+	// holds the actual values.
+	Route     string
+	Path      string
+	Status    int
+	IP        string
+	RequestID string
+	// TargetUserID is the user the request acted on, 0 if none.
+	TargetUserID int
+	CreatedAt    time.Time
+}
@@ -0,0 +1,28 @@
+package models
+
+type EventType string
+
+const (
+	EventOrder   EventType = "order"
+	EventBalance EventType = "balance"
+)
+
+// UserEvent is a change pushed to the user's live event stream. It is
+// carried between replicas as a Postgres notification payload.
+type UserEvent struct {
+	Type    EventType     `json:"type"`
+	UserID  int           `json:"user_id"`
+	Order   *OrderEvent   `json:"order,omitempty"`
+	Balance *BalanceEvent `json:"balance,omitempty"`
+}
+
+type OrderEvent struct {
+	Number  string      `json:"number"`
+	Status  OrderStatus `json:"status"`
+	Accrual float64     `json:"accrual,omitempty"`
+}
+
+type BalanceEvent struct {
+	Current   float64 `json:"current"`
+	Withdrawn float64 `json:"withdrawn"`
+}
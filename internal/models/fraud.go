@@ -0,0 +1,42 @@
+package models
+
+import "time"
+
+type UploadResult string
+
+const (
+	UploadResultAccepted  UploadResult = "ACCEPTED"
+	UploadResultDuplicate UploadResult = "DUPLICATE"
+	UploadResultConflict  UploadResult = "CONFLICT"
+	UploadResultInvalid   UploadResult = "INVALID"
+)
+
+// UploadAttempt is one call to the order upload endpoint, kept for the fraud rules.
+type UploadAttempt struct {
+	UserID      int
+	IP          string
+	OrderNumber string
+	Result      UploadResult
+	CreatedAt   time.Time
+}
+
+type FraudAction string
+
+const (
+	FraudActionFlag     FraudAction = "FLAG"
+	FraudActionThrottle FraudAction = "THROTTLE"
+	FraudActionBlock    FraudAction = "BLOCK"
+)
+
+type FraudSignal struct {
+	ID         int
+	UserID     int
+	IP         string
+	Rule       string
+	Action     FraudAction
+	Details    string
+	CreatedAt  time.Time
+	ResolvedAt *time.Time
+	ResolvedBy *int
+	Resolution string
+}
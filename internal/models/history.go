@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+type HistoryEntryType string
+
+const (
+	HistoryEntryAccrual    HistoryEntryType = "ACCRUAL"
+	HistoryEntryWithdrawal HistoryEntryType = "WITHDRAWAL"
+	HistoryEntryVoucher    HistoryEntryType = "VOUCHER"
+	HistoryEntryAdjustment HistoryEntryType = "ADJUSTMENT"
+)
+
+// HistoryEntry is one balance change as the user sees it. Amount is
+// signed; Reference is the order number, voucher code or adjustment reason.
+type HistoryEntry struct {
+	Type      HistoryEntryType
+	Amount    float64
+	Reference string
+	Status    string
+	At        time.Time
+}
@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// LoginThrottle tracks failed logins for one key, either a login or an IP.
+type LoginThrottle struct {
+	Key           string
+	Failures      int
+	LastFailureAt time.Time
+	BlockedUntil  *time.Time
+}
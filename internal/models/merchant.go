@@ -0,0 +1,12 @@
+package models
+
+type Merchant struct {
+	ID   int
+	Code string
+	Name string
+	// Host is matched against the request host name when no X-Merchant header is sent.
+	Host string
+	// AccrualAddr overrides the accrual system address from the configuration.
+	AccrualAddr string
+	JWTIssuer   string
+}
@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// UserTOTP is a user's TOTP enrollment. It is pending until EnabledAt is
+// set by verifying a first code.
+type UserTOTP struct {
+	UserID    int
+	Secret    string
+	EnabledAt *time.Time
+	// LastStep is the last time step a code was accepted for; codes of
+	// that step or earlier are rejected, so every code works once.
+	LastStep int64
+}
+
+// TOTPEnrollment is shown to the user once to set up an authenticator app.
+type TOTPEnrollment struct {
+	Secret string
+	URI    string
+}
+
+// MFAChallenge is the second step of a login of a user with 2FA enabled.
+type MFAChallenge struct {
+	UserID    int
+	Attempts  int
+	ExpiresAt time.Time
+}
@@ -23,7 +23,16 @@ const (
 type Order struct {
 	Number     string
 	UserID     int
+	MerchantID int
 	Status     OrderStatus
 	Accrual    float64
 	UploadedAt time.Time
+	// UpdatedAt is when the status or accrual last changed.
+	UpdatedAt time.Time
+}
+
+// OrderUploadItem is the outcome for one number of a batch upload.
+type OrderUploadItem struct {
+	Number string
+	Result UploadResult
 }
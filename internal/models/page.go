@@ -0,0 +1,51 @@
+package models
+
+import (
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PageQuery selects a page of a user's orders or withdrawals. Rows are
+// ordered by time, with the order number breaking ties, so that After can
+// continue right behind the last row of the previous page.
+type PageQuery struct {
+	// Limit is the page size; 0 returns every matching row.
+	Limit    int
+	After    *PageCursor
+	Statuses []string
+	// From is inclusive, To exclusive.
+	From      *time.Time
+	To        *time.Time
+	Ascending bool
+}
+
+// PageCursor is the position of a row in the listing.
+type PageCursor struct {
+	At     time.Time
+	Number string
+}
+
+// Encode returns the cursor in the opaque form handed to clients.
+func (c PageCursor) Encode() string {
+	raw := strconv.FormatInt(c.At.UnixMicro(), 10) + ":" + c.Number
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// ParsePageCursor decodes a cursor made by Encode.
+func ParsePageCursor(value string) (PageCursor, bool) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return PageCursor{}, false
+	}
+	micros, number, ok := strings.Cut(string(raw), ":")
+	if !ok || number == "" {
+		return PageCursor{}, false
+	}
+	at, err := strconv.ParseInt(micros, 10, 64)
+	if err != nil {
+		return PageCursor{}, false
+	}
+	return PageCursor{At: time.UnixMicro(at), Number: number}, true
+}
@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// ClosureBalance decides what happens to the points left on an account
+// when its owner closes it.
+type ClosureBalance string
+
+const (
+	// ClosureBalanceForfeit books the rest off with an ACCOUNT_CLOSURE adjustment.
+	ClosureBalanceForfeit ClosureBalance = "forfeit"
+	// ClosureBalancePayout holds the rest as a withdrawal for operators to pay out.
+	ClosureBalancePayout ClosureBalance = "payout"
+)
+
+func (c ClosureBalance) Valid() bool {
+	switch c {
+	case ClosureBalanceForfeit, ClosureBalancePayout:
+		return true
+	}
+	return false
+}
+
+// UserExport is everything stored about a user, as handed out by the
+// personal data export.
+type UserExport struct {
+	User        User
+	TOTPEnabled bool
+	Orders      []Order
+	Withdrawals []Withdrawal
+	Vouchers    []Voucher
+	Adjustments []BalanceAdjustment
+	AuditLog    []AuditEntry
+	ExportedAt  time.Time
+}
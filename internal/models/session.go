@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// Session is one login on one device. Its ID is the refresh token family
+// and is carried by every access token issued from it.
+type Session struct {
+	ID         string
+	UserID     int
+	MerchantID int
+	IP         string
+	UserAgent  string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	RevokedAt  *time.Time
+}
@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+// TokenPair is returned by Register, Login and token refresh.
+type TokenPair struct {
+	AccessToken      string
+	RefreshToken     string
+	ExpiresIn        time.Duration
+	RefreshExpiresIn time.Duration
+}
+
+// TokenClaims are the verified claims of an access token.
+type TokenClaims struct {
+	UserID       int
+	Login        string
+	TokenVersion int
+	// ID is the jti claim used for revocation.
+	ID string
+	// FamilyID links the access token to the refresh token chain it came from.
+	FamilyID  string
+	ExpiresAt time.Time
+}
+
+// RefreshToken is stored by hash only. Tokens issued by rotating one
+// another share a FamilyID, so reuse of a rotated token revokes the chain.
+type RefreshToken struct {
+	ID        int
+	UserID    int
+	FamilyID  string
+	TokenHash string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+}
@@ -2,9 +2,37 @@ package models
 
 import "time"
 
+// Role decides which parts of the admin API a user may call. Support can
+// look users up and re-check orders; admins can also change accounts,
+// review withdrawals and manage vouchers and API keys.
+type Role string
+
+const (
+	RoleUser    Role = "user"
+	RoleSupport Role = "support"
+	RoleAdmin   Role = "admin"
+)
+
+func (r Role) Valid() bool {
+	switch r {
+	case RoleUser, RoleSupport, RoleAdmin:
+		return true
+	}
+	return false
+}
+
 type User struct {
 	ID           int
+	MerchantID   int
 	Login        string
 	PasswordHash string
-	CreatedAt    time.Time
+	Role         Role
+	// TokenVersion is embedded in access tokens; bumping it invalidates
+	// every token issued before.
+	TokenVersion int
+	DisabledAt   *time.Time
+	// DeletedAt is set once the user closed the account. The row stays,
+	// pseudonymized, so that financial records keep their owner.
+	DeletedAt *time.Time
+	CreatedAt time.Time
 }
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// Voucher is a one-time code worth a fixed number of points.
+// Code is stored normalized: upper case, without separators.
+type Voucher struct {
+	Code       string
+	MerchantID int
+	BatchID    string
+	Amount     float64
+	ExpiresAt  *time.Time
+	CreatedBy  int
+	CreatedAt  time.Time
+	RedeemedBy *int
+	RedeemedAt *time.Time
+}
+
+// DisplayCode splits the code into groups of four, e.g. ABCD-EFGH-JKLM.
+func (v Voucher) DisplayCode() string {
+	var b strings.Builder
+	for i, r := range v.Code {
+		if i > 0 && i%4 == 0 {
+			b.WriteByte('-')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -2,9 +2,35 @@ package models
 
 import "time"
 
+type WithdrawalStatus string
+
+const (
+	WithdrawalStatusProcessed     WithdrawalStatus = "PROCESSED"
+	WithdrawalStatusPendingReview WithdrawalStatus = "PENDING_REVIEW"
+	WithdrawalStatusRejected      WithdrawalStatus = "REJECTED"
+)
+
 type Withdrawal struct {
 	Order       string
 	UserID      int
+	MerchantID  int
 	Sum         float64
+	Status      WithdrawalStatus
 	ProcessedAt time.Time
 }
+
+type ReviewDecision string
+
+const (
+	ReviewDecisionApproved ReviewDecision = "APPROVED"
+	ReviewDecisionRejected ReviewDecision = "REJECTED"
+)
+
+// WithdrawalReview records an operator decision on a withdrawal that was held for review.
+type WithdrawalReview struct {
+	Order      string
+	ReviewerID int
+	Decision   ReviewDecision
+	Reason     string
+	DecidedAt  time.Time
+}
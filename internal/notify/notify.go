@@ -0,0 +1,81 @@
+// Package notify delivers messages to users. There is no mail service yet,
+// so the sinks write to the log or to a file for local development.
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/chestorix/gophermart/internal/interfaces"
+	"github.com/chestorix/gophermart/internal/models"
+	"github.com/sirupsen/logrus"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// New builds a notifier from a spec: "log" or "file:<path>".
+func New(spec string, logger *logrus.Logger) (interfaces.Notifier, error) {
+	kind, arg, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "", "log":
+		return &LogNotifier{logger: logger}, nil
+	case "file":
+		if arg == "" {
+			return nil, fmt.Errorf("file notifier needs a path, e.g. file:/tmp/notifications.log")
+		}
+		return &FileNotifier{path: arg}, nil
+	default:
+		return nil, fmt.Errorf("unknown notifier %q", kind)
+	}
+}
+
+// LogNotifier records in the application log that a notification was sent.
+// Logs are widely readable, so it never writes secrets such as reset tokens;
+// use the file notifier to receive them during development.
+type LogNotifier struct {
+	logger *logrus.Logger
+}
+
+func (n *LogNotifier) NotifyPasswordReset(ctx context.Context, user models.User, token string, expiresAt time.Time) error {
+	n.logger.WithFields(logrus.Fields{
+		"user_id":    user.ID,
+		"expires_at": expiresAt,
+	}).Info("password reset sent")
+	return nil
+}
+
+// FileNotifier appends notifications as JSON lines to a file.
+type FileNotifier struct {
+	mu   sync.Mutex
+	path string
+}
+
+func (n *FileNotifier) NotifyPasswordReset(ctx context.Context, user models.User, token string, expiresAt time.Time) error {
+	return n.write(map[string]any{
+		"type":       "password_reset",
+		"user_id":    user.ID,
+		"login":      user.Login,
+		"token":      token,
+		"expires_at": expiresAt,
+		"sent_at":    time.Now(),
+	})
+}
+
+func (n *FileNotifier) write(record map[string]any) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	f, err := os.OpenFile(n.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
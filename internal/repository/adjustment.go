@@ -0,0 +1,123 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	e "github.com/chestorix/gophermart/internal/errors"
+	"github.com/chestorix/gophermart/internal/models"
+	"github.com/chestorix/gophermart/internal/tenant"
+)
+
+const adjustmentColumns = `id, merchant_id, user_id, amount, reason_code, comment, created_by, status, reviewed_by, reviewed_at, created_at`
+
+func scanAdjustment(row rowScanner) (models.BalanceAdjustment, error) {
+	var adj models.BalanceAdjustment
+	err := row.Scan(
+		&adj.ID,
+		&adj.MerchantID,
+		&adj.UserID,
+		&adj.Amount,
+		&adj.Reason,
+		&adj.Comment,
+		&adj.CreatedBy,
+		&adj.Status,
+		&adj.ReviewedBy,
+		&adj.ReviewedAt,
+		&adj.CreatedAt,
+	)
+	return adj, err
+}
+
+func (p *Postgres) CreateAdjustment(ctx context.Context, adj models.BalanceAdjustment) (models.BalanceAdjustment, error) {
+	query := `
+		INSERT INTO balance_adjustments (merchant_id, user_id, amount, reason_code, comment, created_by, status)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING ` + adjustmentColumns
+	return scanAdjustment(p.db.QueryRowContext(ctx, query,
+		tenant.MerchantID(ctx),
+		adj.UserID,
+		adj.Amount,
+		adj.Reason,
+		adj.Comment,
+		adj.CreatedBy,
+		adj.Status,
+	))
+}
+
+func (p *Postgres) GetAdjustment(ctx context.Context, id int) (models.BalanceAdjustment, error) {
+	query := `SELECT ` + adjustmentColumns + ` FROM balance_adjustments WHERE id = $1 AND merchant_id = $2`
+	adj, err := scanAdjustment(p.db.QueryRowContext(ctx, query, id, tenant.MerchantID(ctx)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.BalanceAdjustment{}, e.ErrAdjustmentNotFound
+	}
+	return adj, err
+}
+
+// GetAdjustments returns adjustments newest first. With userID 0 it returns
+// adjustments of every user; an empty status matches any status.
+func (p *Postgres) GetAdjustments(ctx context.Context, userID int, status models.AdjustmentStatus) ([]models.BalanceAdjustment, error) {
+	query := `
+		SELECT ` + adjustmentColumns + `
+		FROM balance_adjustments
+		WHERE merchant_id = $1 AND ($2 = 0 OR user_id = $2) AND ($3 = '' OR status = $3)
+		ORDER BY created_at DESC
+	`
+	rows, err := p.db.QueryContext(ctx, query, tenant.MerchantID(ctx), userID, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var adjustments []models.BalanceAdjustment
+	for rows.Next() {
+		adj, err := scanAdjustment(rows)
+		if err != nil {
+			return nil, err
+		}
+		adjustments = append(adjustments, adj)
+	}
+	return adjustments, rows.Err()
+}
+
+// ReviewAdjustment applies or rejects an adjustment that waits for approval.
+// The row lock keeps two operators from deciding on it at the same time.
+func (p *Postgres) ReviewAdjustment(ctx context.Context, id, reviewerID int, decision models.ReviewDecision) (models.BalanceAdjustment, error) {
+	status := models.AdjustmentStatusApplied
+	if decision == models.ReviewDecisionRejected {
+		status = models.AdjustmentStatusRejected
+	}
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.BalanceAdjustment{}, err
+	}
+	defer tx.Rollback()
+
+	var current models.AdjustmentStatus
+	err = tx.QueryRowContext(ctx,
+		`SELECT status FROM balance_adjustments WHERE id = $1 AND merchant_id = $2 FOR UPDATE`,
+		id, tenant.MerchantID(ctx),
+	).Scan(&current)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.BalanceAdjustment{}, e.ErrAdjustmentNotFound
+		}
+		return models.BalanceAdjustment{}, err
+	}
+	if current != models.AdjustmentStatusPendingApproval {
+		return models.BalanceAdjustment{}, e.ErrAdjustmentNotPending
+	}
+
+	adj, err := scanAdjustment(tx.QueryRowContext(ctx, `
+		UPDATE balance_adjustments
+		SET status = $1, reviewed_by = $2, reviewed_at = NOW()
+		WHERE id = $3
+		RETURNING `+adjustmentColumns,
+		status, reviewerID, id,
+	))
+	if err != nil {
+		return models.BalanceAdjustment{}, err
+	}
+	return adj, tx.Commit()
+}
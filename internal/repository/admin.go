@@ -0,0 +1,157 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	e "github.com/chestorix/gophermart/internal/errors"
+	"github.com/chestorix/gophermart/internal/models"
+	"github.com/chestorix/gophermart/internal/tenant"
+	"strconv"
+	"strings"
+)
+
+// SearchUsers finds users of the merchant in ctx whose login starts with
+// query, or whose ID is query.
+func (p *Postgres) SearchUsers(ctx context.Context, query string, limit int) ([]models.User, error) {
+	id, err := strconv.Atoi(query)
+	if err != nil {
+		id = 0
+	}
+	pattern := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(query) + "%"
+
+	rows, err := p.db.QueryContext(ctx, `
+		SELECT `+userColumns+`
+		FROM users
+		WHERE merchant_id = $1 AND (login LIKE $2 OR id = $3)
+		ORDER BY login
+		LIMIT $4
+	`, tenant.MerchantID(ctx), pattern, id, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+func (p *Postgres) SetUserRole(ctx context.Context, userID int, role models.Role) error {
+	result, err := p.db.ExecContext(ctx,
+		`UPDATE users SET role = $1 WHERE id = $2 AND merchant_id = $3`,
+		role, userID, tenant.MerchantID(ctx),
+	)
+	return expectUserRow(result, err)
+}
+
+// SetUserDisabled disables or re-enables an account. Disabling bumps the
+// token version, so existing access tokens stop working at once. Closed
+// accounts are not found.
+func (p *Postgres) SetUserDisabled(ctx context.Context, userID int, disabled bool) error {
+	query := `
+		UPDATE users
+		SET disabled_at = NULL
+		WHERE id = $1 AND merchant_id = $2 AND deleted_at IS NULL
+	`
+	if disabled {
+		query = `
+			UPDATE users
+			SET disabled_at = COALESCE(disabled_at, NOW()), token_version = token_version + 1
+			WHERE id = $1 AND merchant_id = $2 AND deleted_at IS NULL
+		`
+	}
+	result, err := p.db.ExecContext(ctx, query, userID, tenant.MerchantID(ctx))
+	return expectUserRow(result, err)
+}
+
+func expectUserRow(result sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return e.ErrUserNotFound
+	}
+	return nil
+}
+
+func (p *Postgres) CreateAuditEntry(ctx context.Context, entry models.AuditEntry) error {
+	_, err := p.db.ExecContext(ctx, `
+		INSERT INTO admin_audit_log (merchant_id, actor_id, method, route, path, status, ip, request_id, target_user_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, 0))
+	`,
+		tenant.MerchantID(ctx),
+		entry.ActorID,
+		entry.Method,
+		entry.Route,
+		entry.Path,
+		entry.Status,
+		entry.IP,
+		entry.RequestID,
+		entry.TargetUserID,
+	)
+	return err
+}
+
+// GetAuditLog returns the newest entries first, optionally of one actor.
+func (p *Postgres) GetAuditLog(ctx context.Context, actorID, limit int) ([]models.AuditEntry, error) {
+	return p.queryAuditLog(ctx, `
+		SELECT `+auditColumns+`
+		FROM admin_audit_log
+		WHERE merchant_id = $1 AND ($2 = 0 OR actor_id = $2)
+		ORDER BY created_at DESC, id DESC
+		LIMIT $3
+	`, tenant.MerchantID(ctx), actorID, limit)
+}
+
+// GetUserAuditLog returns the newest entries first that the user either
+// made or was the target of.
+func (p *Postgres) GetUserAuditLog(ctx context.Context, userID, limit int) ([]models.AuditEntry, error) {
+	return p.queryAuditLog(ctx, `
+		SELECT `+auditColumns+`
+		FROM admin_audit_log
+		WHERE merchant_id = $1 AND (actor_id = $2 OR target_user_id = $2)
+		ORDER BY created_at DESC, id DESC
+		LIMIT $3
+	`, tenant.MerchantID(ctx), userID, limit)
+}
+
+const auditColumns = `id, actor_id, method, route, path, status, ip, request_id, COALESCE(target_user_id, 0), created_at`
+
+func (p *Postgres) queryAuditLog(ctx context.Context, query string, args ...any) ([]models.AuditEntry, error) {
+	rows, err := p.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []models.AuditEntry
+	for rows.Next() {
+		var entry models.AuditEntry
+		if err := rows.Scan(
+			&entry.ID,
+			&entry.ActorID,
+			&entry.Method,
+			&entry.Route,
+			&entry.Path,
+			&entry.Status,
+			&entry.IP,
+			&entry.RequestID,
+			&entry.TargetUserID,
+			&entry.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
@@ -0,0 +1,102 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	e "github.com/chestorix/gophermart/internal/errors"
+	"github.com/chestorix/gophermart/internal/models"
+	"github.com/chestorix/gophermart/internal/tenant"
+	"strings"
+)
+
+const apiKeyColumns = `id, merchant_id, name, prefix, key_hash, scopes, created_by, created_at, last_used_at, revoked_at`
+
+func scanAPIKey(row rowScanner) (models.APIKey, error) {
+	var key models.APIKey
+	var scopes string
+	err := row.Scan(
+		&key.ID,
+		&key.MerchantID,
+		&key.Name,
+		&key.Prefix,
+		&key.KeyHash,
+		&scopes,
+		&key.CreatedBy,
+		&key.CreatedAt,
+		&key.LastUsedAt,
+		&key.RevokedAt,
+	)
+	if scopes != "" {
+		key.Scopes = strings.Split(scopes, " ")
+	}
+	return key, err
+}
+
+func (p *Postgres) CreateAPIKey(ctx context.Context, key models.APIKey) (models.APIKey, error) {
+	query := `
+		INSERT INTO api_keys (merchant_id, name, prefix, key_hash, scopes, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING ` + apiKeyColumns
+	return scanAPIKey(p.db.QueryRowContext(ctx, query,
+		tenant.MerchantID(ctx),
+		key.Name,
+		key.Prefix,
+		key.KeyHash,
+		strings.Join(key.Scopes, " "),
+		key.CreatedBy,
+	))
+}
+
+func (p *Postgres) GetAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE merchant_id = $1 ORDER BY created_at DESC`
+	rows, err := p.db.QueryContext(ctx, query, tenant.MerchantID(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []models.APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// UseAPIKey looks up an active key of the merchant in ctx by its hash and
+// records the time it was used.
+func (p *Postgres) UseAPIKey(ctx context.Context, keyHash string) (models.APIKey, error) {
+	query := `
+		UPDATE api_keys
+		SET last_used_at = NOW()
+		WHERE key_hash = $1 AND merchant_id = $2 AND revoked_at IS NULL
+		RETURNING ` + apiKeyColumns
+	key, err := scanAPIKey(p.db.QueryRowContext(ctx, query, keyHash, tenant.MerchantID(ctx)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.APIKey{}, e.ErrInvalidAPIKey
+	}
+	return key, err
+}
+
+func (p *Postgres) RevokeAPIKey(ctx context.Context, id int) error {
+	result, err := p.db.ExecContext(ctx, `
+		UPDATE api_keys
+		SET revoked_at = NOW()
+		WHERE id = $1 AND merchant_id = $2 AND revoked_at IS NULL
+	`, id, tenant.MerchantID(ctx))
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return e.ErrAPIKeyNotFound
+	}
+	return nil
+}
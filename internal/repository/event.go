@@ -0,0 +1,53 @@
+package repository
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"github.com/chestorix/gophermart/internal/models"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// eventChannel is the LISTEN/NOTIFY channel user events travel on.
+const eventChannel = "gophermart_events"
+
+// PublishEvent notifies every replica listening for user events.
+func (p *Postgres) PublishEvent(ctx context.Context, event models.UserEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = p.db.ExecContext(ctx, `SELECT pg_notify($1, $2)`, eventChannel, string(payload))
+	return err
+}
+
+// ListenEvents holds a connection listening for user events and calls
+// handle for each one until ctx is done or the connection fails. The
+// connection is never returned to the pool.
+func (p *Postgres) ListenEvents(ctx context.Context, handle func(models.UserEvent)) error {
+	conn, err := p.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn any) error {
+		pgConn := driverConn.(*stdlib.Conn).Conn()
+		if _, err := pgConn.Exec(ctx, "LISTEN "+eventChannel); err != nil {
+			return err
+		}
+		for {
+			notification, err := pgConn.WaitForNotification(ctx)
+			if err != nil {
+				// The connection is left mid-wait; make database/sql drop it.
+				return fmt.Errorf("%w: %v", driver.ErrBadConn, err)
+			}
+			var event models.UserEvent
+			if err := json.Unmarshal([]byte(notification.Payload), &event); err != nil {
+				continue
+			}
+			handle(event)
+		}
+	})
+}
@@ -0,0 +1,153 @@
+package repository
+
+import (
+	"context"
+	e "github.com/chestorix/gophermart/internal/errors"
+	"github.com/chestorix/gophermart/internal/models"
+	"github.com/chestorix/gophermart/internal/tenant"
+	"time"
+)
+
+// RecordUploadAttempts stores the attempts with a single statement, so that
+// batch uploads cost one round trip.
+func (p *Postgres) RecordUploadAttempts(ctx context.Context, attempts []models.UploadAttempt) error {
+	if len(attempts) == 0 {
+		return nil
+	}
+	userIDs := make([]int, 0, len(attempts))
+	ips := make([]string, 0, len(attempts))
+	numbers := make([]string, 0, len(attempts))
+	results := make([]string, 0, len(attempts))
+	for _, attempt := range attempts {
+		userIDs = append(userIDs, attempt.UserID)
+		ips = append(ips, attempt.IP)
+		numbers = append(numbers, attempt.OrderNumber)
+		results = append(results, string(attempt.Result))
+	}
+	query := `
+		INSERT INTO order_upload_attempts (merchant_id, user_id, ip, order_number, result)
+		SELECT $5, * FROM unnest($1::integer[], $2::text[], $3::text[], $4::text[])
+	`
+	_, err := p.db.ExecContext(ctx, query, userIDs, ips, numbers, results, tenant.MerchantID(ctx))
+	return err
+}
+
+func (p *Postgres) CountUploadAttempts(ctx context.Context, userID int, result models.UploadResult, since time.Time) (int, error) {
+	query := `
+		SELECT COUNT(*)
+		FROM order_upload_attempts
+		WHERE user_id = $1 AND merchant_id = $2 AND result = $3 AND created_at >= $4
+	`
+	var count int
+	err := p.db.QueryRowContext(ctx, query, userID, tenant.MerchantID(ctx), result, since).Scan(&count)
+	return count, err
+}
+
+func (p *Postgres) GetRecentUploadNumbers(ctx context.Context, userID int, since time.Time) ([]string, error) {
+	query := `
+		SELECT order_number
+		FROM order_upload_attempts
+		WHERE user_id = $1 AND merchant_id = $2 AND created_at >= $3
+		ORDER BY created_at DESC
+	`
+	rows, err := p.db.QueryContext(ctx, query, userID, tenant.MerchantID(ctx), since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var numbers []string
+	for rows.Next() {
+		var number string
+		if err := rows.Scan(&number); err != nil {
+			return nil, err
+		}
+		numbers = append(numbers, number)
+	}
+	return numbers, rows.Err()
+}
+
+func (p *Postgres) CountUsersByIP(ctx context.Context, ip string, since time.Time) (int, error) {
+	query := `
+		SELECT COUNT(DISTINCT user_id)
+		FROM order_upload_attempts
+		WHERE merchant_id = $1 AND ip = $2 AND created_at >= $3
+	`
+	var count int
+	err := p.db.QueryRowContext(ctx, query, tenant.MerchantID(ctx), ip, since).Scan(&count)
+	return count, err
+}
+
+func (p *Postgres) CreateFraudSignal(ctx context.Context, signal models.FraudSignal) error {
+	query := `
+		INSERT INTO fraud_signals (merchant_id, user_id, ip, rule, action, details)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+	_, err := p.db.ExecContext(ctx, query,
+		tenant.MerchantID(ctx),
+		signal.UserID,
+		signal.IP,
+		signal.Rule,
+		signal.Action,
+		signal.Details,
+	)
+	return err
+}
+
+// GetFraudSignals returns signals of the current merchant newest first. With
+// userID 0 it returns signals of every user; with unresolvedOnly it skips
+// reviewed ones.
+func (p *Postgres) GetFraudSignals(ctx context.Context, userID int, unresolvedOnly bool) ([]models.FraudSignal, error) {
+	query := `
+		SELECT id, user_id, ip, rule, action, details, created_at, resolved_at, resolved_by, resolution
+		FROM fraud_signals
+		WHERE merchant_id = $1 AND ($2 = 0 OR user_id = $2) AND (NOT $3 OR resolved_at IS NULL)
+		ORDER BY created_at DESC
+	`
+	rows, err := p.db.QueryContext(ctx, query, tenant.MerchantID(ctx), userID, unresolvedOnly)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var signals []models.FraudSignal
+	for rows.Next() {
+		var signal models.FraudSignal
+		if err := rows.Scan(
+			&signal.ID,
+			&signal.UserID,
+			&signal.IP,
+			&signal.Rule,
+			&signal.Action,
+			&signal.Details,
+			&signal.CreatedAt,
+			&signal.ResolvedAt,
+			&signal.ResolvedBy,
+			&signal.Resolution,
+		); err != nil {
+			return nil, err
+		}
+		signals = append(signals, signal)
+	}
+	return signals, rows.Err()
+}
+
+func (p *Postgres) ResolveFraudSignal(ctx context.Context, id, reviewerID int, resolution string) error {
+	query := `
+		UPDATE fraud_signals
+		SET resolved_at = NOW(), resolved_by = $2, resolution = $3
+		WHERE id = $1 AND merchant_id = $4 AND resolved_at IS NULL
+	`
+	res, err := p.db.ExecContext(ctx, query, id, reviewerID, resolution, tenant.MerchantID(ctx))
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return e.ErrFraudSignalNotFound
+	}
+	return nil
+}
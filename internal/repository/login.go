@@ -0,0 +1,117 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/chestorix/gophermart/internal/models"
+	"time"
+)
+
+// ReserveLoginAttempt counts an attempt against key before the credentials
+// are checked, so that parallel guesses cannot all pass the throttle before
+// any of them is recorded. A counter whose last failure is older than window
+// starts over. The row stays locked until the block for the new count is
+// stored, which makes concurrent attempts wait for it.
+//
+// delays[n-1] is how long the key is blocked after n failures; counts past
+// the end use the last delay. If the key is already blocked nothing is
+// counted and the returned throttle has BlockedUntil set.
+func (p *Postgres) ReserveLoginAttempt(ctx context.Context, key string, window time.Duration, delays []time.Duration) (models.LoginThrottle, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.LoginThrottle{}, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO login_failures AS l (key, failures, last_failure_at)
+		VALUES ($1, 1, NOW())
+		ON CONFLICT (key) DO UPDATE
+		SET failures = CASE
+		        WHEN l.blocked_until > NOW() THEN l.failures
+		        WHEN l.last_failure_at < NOW() - $2 * INTERVAL '1 second' THEN 1
+		        ELSE l.failures + 1
+		    END,
+		    last_failure_at = CASE WHEN l.blocked_until > NOW() THEN l.last_failure_at ELSE NOW() END
+		RETURNING key, failures, last_failure_at, blocked_until, COALESCE(blocked_until > NOW(), FALSE)
+	`
+	var (
+		t       models.LoginThrottle
+		blocked bool
+	)
+	if err := tx.QueryRowContext(ctx, query, key, window.Seconds()).Scan(
+		&t.Key,
+		&t.Failures,
+		&t.LastFailureAt,
+		&t.BlockedUntil,
+		&blocked,
+	); err != nil {
+		return models.LoginThrottle{}, err
+	}
+	if blocked {
+		return t, tx.Commit()
+	}
+
+	t.BlockedUntil = nil
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE login_failures
+		SET blocked_until = CASE WHEN $2::float8 > 0 THEN NOW() + $2::float8 * INTERVAL '1 second' END
+		WHERE key = $1
+	`, key, delayAfter(delays, t.Failures).Seconds()); err != nil {
+		return models.LoginThrottle{}, err
+	}
+	return t, tx.Commit()
+}
+
+// ReleaseLoginAttempt takes back an attempt reserved by ReserveLoginAttempt
+// whose credentials were correct, and lifts or shortens the block it caused.
+func (p *Postgres) ReleaseLoginAttempt(ctx context.Context, key string, delays []time.Duration) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var failures int
+	err = tx.QueryRowContext(ctx, `
+		UPDATE login_failures
+		SET failures = failures - 1
+		WHERE key = $1 AND failures > 0
+		RETURNING failures
+	`, key).Scan(&failures)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	delay := 0.0
+	if failures > 0 {
+		delay = delayAfter(delays, failures).Seconds()
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE login_failures
+		SET blocked_until = CASE WHEN $2::float8 > 0 THEN LEAST(blocked_until, NOW() + $2::float8 * INTERVAL '1 second') END
+		WHERE key = $1
+	`, key, delay); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func delayAfter(delays []time.Duration, failures int) time.Duration {
+	if len(delays) == 0 || failures <= 0 {
+		return 0
+	}
+	if failures > len(delays) {
+		return delays[len(delays)-1]
+	}
+	return delays[failures-1]
+}
+
+func (p *Postgres) ClearLoginFailures(ctx context.Context, key string) error {
+	_, err := p.db.ExecContext(ctx, `DELETE FROM login_failures WHERE key = $1`, key)
+	return err
+}
@@ -0,0 +1,36 @@
+package repository
+
+import (
+	"context"
+	"github.com/chestorix/gophermart/internal/models"
+)
+
+func (p *Postgres) GetMerchants(ctx context.Context) ([]models.Merchant, error) {
+	query := `
+		SELECT id, code, name, host, accrual_address, jwt_issuer
+		FROM merchants
+		ORDER BY id
+	`
+	rows, err := p.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var merchants []models.Merchant
+	for rows.Next() {
+		var m models.Merchant
+		if err := rows.Scan(
+			&m.ID,
+			&m.Code,
+			&m.Name,
+			&m.Host,
+			&m.AccrualAddr,
+			&m.JWTIssuer,
+		); err != nil {
+			return nil, err
+		}
+		merchants = append(merchants, m)
+	}
+	return merchants, rows.Err()
+}
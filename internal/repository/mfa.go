@@ -0,0 +1,176 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	e "github.com/chestorix/gophermart/internal/errors"
+	"github.com/chestorix/gophermart/internal/models"
+	"github.com/chestorix/gophermart/internal/tenant"
+	"time"
+)
+
+// SetTOTPSecret starts or restarts a pending enrollment. An enabled
+// enrollment is left alone and reported as ErrTOTPAlreadyEnabled.
+func (p *Postgres) SetTOTPSecret(ctx context.Context, userID int, secret string) error {
+	query := `
+		INSERT INTO user_totp (user_id, secret)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, last_step = 0, created_at = NOW()
+		WHERE user_totp.enabled_at IS NULL
+	`
+	result, err := p.db.ExecContext(ctx, query, userID, secret)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return e.ErrTOTPAlreadyEnabled
+	}
+	return nil
+}
+
+func (p *Postgres) GetUserTOTP(ctx context.Context, userID int) (models.UserTOTP, error) {
+	query := `SELECT user_id, secret, enabled_at, last_step FROM user_totp WHERE user_id = $1`
+	var totp models.UserTOTP
+	err := p.db.QueryRowContext(ctx, query, userID).Scan(&totp.UserID, &totp.Secret, &totp.EnabledAt, &totp.LastStep)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.UserTOTP{}, e.ErrTOTPNotEnrolled
+	}
+	return totp, err
+}
+
+func (p *Postgres) EnableTOTP(ctx context.Context, userID int) error {
+	_, err := p.db.ExecContext(ctx,
+		`UPDATE user_totp SET enabled_at = NOW() WHERE user_id = $1 AND enabled_at IS NULL`,
+		userID,
+	)
+	return err
+}
+
+func (p *Postgres) DisableTOTP(ctx context.Context, userID int) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_totp WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseTOTPStep records that a code of step was accepted. It reports false
+// if a code of this or a later step was accepted before, i.e. a replay.
+func (p *Postgres) UseTOTPStep(ctx context.Context, userID int, step int64) (bool, error) {
+	result, err := p.db.ExecContext(ctx,
+		`UPDATE user_totp SET last_step = $2 WHERE user_id = $1 AND last_step < $2`,
+		userID, step,
+	)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+// ReplaceRecoveryCodes drops the user's recovery codes and stores new ones.
+func (p *Postgres) ReplaceRecoveryCodes(ctx context.Context, userID int, codeHashes []string) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	for _, hash := range codeHashes {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)`,
+			userID, hash,
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// UseRecoveryCode consumes a recovery code; each works once.
+func (p *Postgres) UseRecoveryCode(ctx context.Context, userID int, codeHash string) error {
+	result, err := p.db.ExecContext(ctx, `
+		UPDATE recovery_codes
+		SET used_at = NOW()
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`, userID, codeHash)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return e.ErrInvalidMFACode
+	}
+	return nil
+}
+
+// CreateMFAChallenge stores a login challenge for the merchant in ctx and
+// drops expired ones.
+func (p *Postgres) CreateMFAChallenge(ctx context.Context, userID int, tokenHash string, expiresAt time.Time) error {
+	if _, err := p.db.ExecContext(ctx,
+		`DELETE FROM mfa_challenges WHERE expires_at < NOW()`,
+	); err != nil {
+		return err
+	}
+	_, err := p.db.ExecContext(ctx, `
+		INSERT INTO mfa_challenges (token_hash, user_id, merchant_id, expires_at)
+		VALUES ($1, $2, $3, $4)
+	`, tokenHash, userID, tenant.MerchantID(ctx), expiresAt)
+	return err
+}
+
+// ReserveMFAChallengeAttempt counts an attempt at a challenge before its
+// code is checked and returns the challenge. The conditional UPDATE lets at
+// most maxAttempts attempts through, however many run in parallel; an
+// expired or used up challenge gives ErrInvalidMFAChallenge.
+func (p *Postgres) ReserveMFAChallengeAttempt(ctx context.Context, tokenHash string, maxAttempts int) (models.MFAChallenge, error) {
+	query := `
+		UPDATE mfa_challenges
+		SET attempts = attempts + 1
+		WHERE token_hash = $1 AND merchant_id = $2 AND expires_at > NOW() AND attempts < $3
+		RETURNING user_id, attempts, expires_at
+	`
+	var c models.MFAChallenge
+	err := p.db.QueryRowContext(ctx, query, tokenHash, tenant.MerchantID(ctx), maxAttempts).Scan(&c.UserID, &c.Attempts, &c.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.MFAChallenge{}, e.ErrInvalidMFAChallenge
+	}
+	return c, err
+}
+
+// DeleteMFAChallenge consumes a challenge. Only one of several concurrent
+// calls succeeds; the others get ErrInvalidMFAChallenge.
+func (p *Postgres) DeleteMFAChallenge(ctx context.Context, tokenHash string) error {
+	result, err := p.db.ExecContext(ctx, `DELETE FROM mfa_challenges WHERE token_hash = $1`, tokenHash)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return e.ErrInvalidMFAChallenge
+	}
+	return nil
+}
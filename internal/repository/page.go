@@ -0,0 +1,43 @@
+package repository
+
+import (
+	"fmt"
+	"github.com/chestorix/gophermart/internal/models"
+	"strings"
+)
+
+// pageClause turns q into the filter, keyset and ORDER BY parts of a
+// listing ordered by timeColumn and keyColumn. args holds the parameters
+// already used by the query; the returned slice extends it.
+func pageClause(q models.PageQuery, timeColumn, keyColumn string, args []any) (string, []any) {
+	var b strings.Builder
+	param := func(value any) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if len(q.Statuses) > 0 {
+		fmt.Fprintf(&b, " AND status = ANY(%s)", param(q.Statuses))
+	}
+	if q.From != nil {
+		fmt.Fprintf(&b, " AND %s >= %s", timeColumn, param(*q.From))
+	}
+	if q.To != nil {
+		fmt.Fprintf(&b, " AND %s < %s", timeColumn, param(*q.To))
+	}
+
+	direction, compare := "DESC", "<"
+	if q.Ascending {
+		direction, compare = "ASC", ">"
+	}
+	if q.After != nil {
+		fmt.Fprintf(&b, " AND (%s, %s) %s (%s, %s)",
+			timeColumn, keyColumn, compare, param(q.After.At), param(q.After.Number))
+	}
+
+	fmt.Fprintf(&b, " ORDER BY %s %s, %s %s", timeColumn, direction, keyColumn, direction)
+	if q.Limit > 0 {
+		fmt.Fprintf(&b, " LIMIT %s", param(q.Limit))
+	}
+	return b.String(), args
+}
@@ -5,7 +5,9 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	e "github.com/chestorix/gophermart/internal/errors"
 	"github.com/chestorix/gophermart/internal/models"
+	"github.com/chestorix/gophermart/internal/tenant"
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"time"
 )
@@ -58,6 +60,206 @@ CREATE TABLE IF NOT EXISTS withdrawals (
     sum NUMERIC(10, 2) NOT NULL,
     processed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
 );
+ALTER TABLE withdrawals ADD COLUMN IF NOT EXISTS status VARCHAR(50) NOT NULL DEFAULT 'PROCESSED';
+CREATE TABLE IF NOT EXISTS withdrawal_reviews (
+    id SERIAL PRIMARY KEY,
+    order_number VARCHAR(255) NOT NULL REFERENCES withdrawals(order_number),
+    reviewer_id INTEGER NOT NULL REFERENCES users(id),
+    decision VARCHAR(50) NOT NULL CHECK (decision IN ('APPROVED', 'REJECTED')),
+    reason TEXT NOT NULL DEFAULT '',
+    decided_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+CREATE TABLE IF NOT EXISTS order_upload_attempts (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    ip VARCHAR(64) NOT NULL DEFAULT '',
+    order_number VARCHAR(255) NOT NULL,
+    result VARCHAR(50) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS order_upload_attempts_user_idx ON order_upload_attempts (user_id, created_at);
+CREATE TABLE IF NOT EXISTS fraud_signals (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    ip VARCHAR(64) NOT NULL DEFAULT '',
+    rule VARCHAR(100) NOT NULL,
+    action VARCHAR(20) NOT NULL CHECK (action IN ('FLAG', 'THROTTLE', 'BLOCK')),
+    details TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    resolved_at TIMESTAMP WITH TIME ZONE,
+    resolved_by INTEGER REFERENCES users(id),
+    resolution TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS fraud_signals_user_idx ON fraud_signals (user_id) WHERE resolved_at IS NULL;
+CREATE TABLE IF NOT EXISTS merchants (
+    id SERIAL PRIMARY KEY,
+    code VARCHAR(64) NOT NULL UNIQUE,
+    name VARCHAR(255) NOT NULL,
+    host VARCHAR(255) NOT NULL DEFAULT '',
+    accrual_address VARCHAR(255) NOT NULL DEFAULT '',
+    jwt_issuer VARCHAR(255) NOT NULL
+);
+INSERT INTO merchants (id, code, name, jwt_issuer) VALUES (1, 'default', 'Gophermart', 'gophermart')
+ON CONFLICT (id) DO NOTHING;
+SELECT setval('merchants_id_seq', GREATEST((SELECT MAX(id) FROM merchants), 1));
+ALTER TABLE users ADD COLUMN IF NOT EXISTS merchant_id INTEGER NOT NULL DEFAULT 1 REFERENCES merchants(id);
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS merchant_id INTEGER NOT NULL DEFAULT 1 REFERENCES merchants(id);
+ALTER TABLE withdrawals ADD COLUMN IF NOT EXISTS merchant_id INTEGER NOT NULL DEFAULT 1 REFERENCES merchants(id);
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_login_key;
+CREATE UNIQUE INDEX IF NOT EXISTS users_merchant_login_idx ON users (merchant_id, login);
+CREATE INDEX IF NOT EXISTS orders_merchant_user_idx ON orders (merchant_id, user_id);
+CREATE INDEX IF NOT EXISTS withdrawals_merchant_user_idx ON withdrawals (merchant_id, user_id);
+CREATE TABLE IF NOT EXISTS vouchers (
+    code VARCHAR(32) PRIMARY KEY,
+    merchant_id INTEGER NOT NULL REFERENCES merchants(id),
+    batch_id VARCHAR(32) NOT NULL,
+    amount NUMERIC(10, 2) NOT NULL CHECK (amount > 0),
+    expires_at TIMESTAMP WITH TIME ZONE,
+    created_by INTEGER NOT NULL REFERENCES users(id),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    redeemed_by INTEGER REFERENCES users(id),
+    redeemed_at TIMESTAMP WITH TIME ZONE
+);
+CREATE INDEX IF NOT EXISTS vouchers_batch_idx ON vouchers (batch_id);
+CREATE INDEX IF NOT EXISTS vouchers_redeemed_by_idx ON vouchers (redeemed_by) WHERE redeemed_by IS NOT NULL;
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    family_id VARCHAR(64) NOT NULL,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE,
+    revoked_at TIMESTAMP WITH TIME ZONE
+);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS token_version INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS disabled_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(16) NOT NULL DEFAULT 'user';
+CREATE INDEX IF NOT EXISTS refresh_tokens_family_idx ON refresh_tokens (family_id);
+CREATE TABLE IF NOT EXISTS password_reset_tokens (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE
+);
+CREATE TABLE IF NOT EXISTS login_failures (
+    key VARCHAR(320) PRIMARY KEY,
+    failures INTEGER NOT NULL,
+    last_failure_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    blocked_until TIMESTAMP WITH TIME ZONE
+);
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    jti VARCHAR(64) PRIMARY KEY,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+CREATE TABLE IF NOT EXISTS user_totp (
+    user_id INTEGER PRIMARY KEY REFERENCES users(id),
+    secret VARCHAR(64) NOT NULL,
+    enabled_at TIMESTAMP WITH TIME ZONE,
+    last_step BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    code_hash CHAR(64) NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE,
+    UNIQUE (user_id, code_hash)
+);
+CREATE TABLE IF NOT EXISTS mfa_challenges (
+    token_hash CHAR(64) PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    merchant_id INTEGER NOT NULL REFERENCES merchants(id),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+CREATE TABLE IF NOT EXISTS api_keys (
+    id SERIAL PRIMARY KEY,
+    merchant_id INTEGER NOT NULL REFERENCES merchants(id),
+    name VARCHAR(255) NOT NULL,
+    prefix VARCHAR(16) NOT NULL,
+    key_hash CHAR(64) NOT NULL UNIQUE,
+    scopes TEXT NOT NULL,
+    created_by INTEGER NOT NULL REFERENCES users(id),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    last_used_at TIMESTAMP WITH TIME ZONE,
+    revoked_at TIMESTAMP WITH TIME ZONE
+);
+CREATE TABLE IF NOT EXISTS admin_audit_log (
+    id SERIAL PRIMARY KEY,
+    merchant_id INTEGER NOT NULL REFERENCES merchants(id),
+    actor_id INTEGER NOT NULL REFERENCES users(id),
+    method VARCHAR(8) NOT NULL,
+    route VARCHAR(255) NOT NULL,
+    path TEXT NOT NULL,
+    status INTEGER NOT NULL,
+    ip VARCHAR(64) NOT NULL,
+    request_id VARCHAR(128) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS admin_audit_log_merchant_created_idx ON admin_audit_log (merchant_id, created_at);
+CREATE TABLE IF NOT EXISTS balance_adjustments (
+    id SERIAL PRIMARY KEY,
+    merchant_id INTEGER NOT NULL REFERENCES merchants(id),
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    amount NUMERIC(10, 2) NOT NULL,
+    reason_code VARCHAR(32) NOT NULL,
+    comment TEXT NOT NULL,
+    created_by INTEGER NOT NULL REFERENCES users(id),
+    status VARCHAR(32) NOT NULL,
+    reviewed_by INTEGER REFERENCES users(id),
+    reviewed_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS balance_adjustments_user_idx ON balance_adjustments (user_id, status);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE;
+CREATE TABLE IF NOT EXISTS sessions (
+    id VARCHAR(64) PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    merchant_id INTEGER NOT NULL REFERENCES merchants(id),
+    ip VARCHAR(64) NOT NULL DEFAULT '',
+    user_agent VARCHAR(512) NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    last_seen_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    revoked_at TIMESTAMP WITH TIME ZONE
+);
+CREATE INDEX IF NOT EXISTS sessions_user_idx ON sessions (user_id, last_seen_at);
+CREATE INDEX IF NOT EXISTS orders_user_uploaded_idx ON orders (user_id, uploaded_at, number);
+CREATE INDEX IF NOT EXISTS withdrawals_user_processed_idx ON withdrawals (user_id, processed_at, order_number);
+ALTER TABLE order_upload_attempts ADD COLUMN IF NOT EXISTS merchant_id INTEGER REFERENCES merchants(id);
+ALTER TABLE fraud_signals ADD COLUMN IF NOT EXISTS merchant_id INTEGER REFERENCES merchants(id);
+UPDATE order_upload_attempts a SET merchant_id = u.merchant_id FROM users u WHERE a.merchant_id IS NULL AND u.id = a.user_id;
+UPDATE fraud_signals f SET merchant_id = u.merchant_id FROM users u WHERE f.merchant_id IS NULL AND u.id = f.user_id;
+ALTER TABLE order_upload_attempts ALTER COLUMN merchant_id SET NOT NULL;
+ALTER TABLE fraud_signals ALTER COLUMN merchant_id SET NOT NULL;
+CREATE INDEX IF NOT EXISTS order_upload_attempts_merchant_ip_idx ON order_upload_attempts (merchant_id, ip, created_at);
+DROP INDEX IF EXISTS order_upload_attempts_ip_idx;
+CREATE INDEX IF NOT EXISTS fraud_signals_merchant_created_idx ON fraud_signals (merchant_id, created_at);
+ALTER TABLE password_reset_tokens ADD COLUMN IF NOT EXISTS merchant_id INTEGER REFERENCES merchants(id);
+UPDATE password_reset_tokens t SET merchant_id = u.merchant_id FROM users u WHERE t.merchant_id IS NULL AND u.id = t.user_id;
+ALTER TABLE password_reset_tokens ALTER COLUMN merchant_id SET NOT NULL;
+CREATE INDEX IF NOT EXISTS users_merchant_lower_login_idx ON users (merchant_id, lower(login));
+ALTER TABLE admin_audit_log ADD COLUMN IF NOT EXISTS target_user_id INTEGER;
+CREATE INDEX IF NOT EXISTS admin_audit_log_target_idx ON admin_audit_log (target_user_id) WHERE target_user_id IS NOT NULL;
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS merchant_id INTEGER REFERENCES merchants(id);
+UPDATE refresh_tokens t SET merchant_id = u.merchant_id FROM users u WHERE t.merchant_id IS NULL AND u.id = t.user_id;
+ALTER TABLE refresh_tokens ALTER COLUMN merchant_id SET NOT NULL;
+ALTER TABLE revoked_tokens ADD COLUMN IF NOT EXISTS merchant_id INTEGER REFERENCES merchants(id);
+ALTER TABLE withdrawal_reviews ADD COLUMN IF NOT EXISTS merchant_id INTEGER REFERENCES merchants(id);
+UPDATE withdrawal_reviews r SET merchant_id = w.merchant_id FROM withdrawals w WHERE r.merchant_id IS NULL AND w.order_number = r.order_number;
+ALTER TABLE withdrawal_reviews ALTER COLUMN merchant_id SET NOT NULL;
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'withdrawals_merchant_order_pkey') THEN
+        ALTER TABLE withdrawal_reviews DROP CONSTRAINT IF EXISTS withdrawal_reviews_order_number_fkey;
+        ALTER TABLE withdrawals DROP CONSTRAINT IF EXISTS withdrawals_pkey;
+        ALTER TABLE withdrawals ADD CONSTRAINT withdrawals_merchant_order_pkey PRIMARY KEY (merchant_id, order_number);
+        ALTER TABLE withdrawal_reviews ADD CONSTRAINT withdrawal_reviews_withdrawal_fkey
+            FOREIGN KEY (merchant_id, order_number) REFERENCES withdrawals (merchant_id, order_number);
+    END IF;
+END $$;
 	`)
 	return err
 }
@@ -66,79 +268,234 @@ func (p *Postgres) Test() string {
 	return "test"
 }
 
-func (p *Postgres) CreateUser(ctx context.Context, user models.User) error {
-	query := `INSERT INTO users (login, password_hash) VALUES ($1, $2) RETURNING id`
+func (p *Postgres) CreateUser(ctx context.Context, user models.User) (int, error) {
+	query := `INSERT INTO users (merchant_id, login, password_hash) VALUES ($1, $2, $3) RETURNING id`
 
 	var id int
-	err := p.db.QueryRowContext(ctx, query, user.Login, user.PasswordHash).Scan(&id)
+	err := p.db.QueryRowContext(ctx, query, tenant.MerchantID(ctx), user.Login, user.PasswordHash).Scan(&id)
 	if err != nil {
-		return fmt.Errorf("failed to insert user: %w", err)
+		return 0, fmt.Errorf("failed to insert user: %w", err)
 	}
-	return nil
+	return id, nil
 }
 
-func (p *Postgres) GetUserByLogin(ctx context.Context, login string) (models.User, error) {
-	query := `SELECT id, login, password_hash, created_at FROM users WHERE login = $1`
+const userColumns = `id, merchant_id, login, password_hash, role, token_version, disabled_at, deleted_at, created_at`
+
+// rowScanner is implemented by *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// queryRower is implemented by *sql.DB and *sql.Tx.
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func scanUser(row rowScanner) (models.User, error) {
 	var user models.User
-	err := p.db.QueryRowContext(ctx, query, login).Scan(
+	err := row.Scan(
 		&user.ID,
+		&user.MerchantID,
 		&user.Login,
 		&user.PasswordHash,
+		&user.Role,
+		&user.TokenVersion,
+		&user.DisabledAt,
+		&user.DeletedAt,
 		&user.CreatedAt,
 	)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return models.User{}, fmt.Errorf("user not found")
+			return models.User{}, e.ErrUserNotFound
 		}
 		return models.User{}, err
 	}
 	return user, nil
 }
 
+func (p *Postgres) GetUserByLogin(ctx context.Context, login string) (models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE login = $1 AND merchant_id = $2`
+	return scanUser(p.db.QueryRowContext(ctx, query, login, tenant.MerchantID(ctx)))
+}
+
+// LoginTaken reports whether the merchant in ctx has a user whose login
+// equals login ignoring case. Logins stored before normalization may be
+// mixed case; registering their lower-case form would shadow them.
+func (p *Postgres) LoginTaken(ctx context.Context, login string) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM users WHERE lower(login) = lower($1) AND merchant_id = $2)`
+	var taken bool
+	err := p.db.QueryRowContext(ctx, query, login, tenant.MerchantID(ctx)).Scan(&taken)
+	return taken, err
+}
+
+func (p *Postgres) GetUserByID(ctx context.Context, id int) (models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = $1 AND merchant_id = $2`
+	return scanUser(p.db.QueryRowContext(ctx, query, id, tenant.MerchantID(ctx)))
+}
+
+// UpdatePassword stores the new hash and bumps the token version, which
+// invalidates every access token issued before.
+func (p *Postgres) UpdatePassword(ctx context.Context, userID int, passwordHash string) error {
+	query := `
+		UPDATE users
+		SET password_hash = $1, token_version = token_version + 1
+		WHERE id = $2 AND merchant_id = $3
+	`
+	res, err := p.db.ExecContext(ctx, query, passwordHash, userID, tenant.MerchantID(ctx))
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return e.ErrUserNotFound
+	}
+	return nil
+}
+
+// RehashPassword replaces the hash of a password that has not changed, so
+// unlike UpdatePassword it keeps existing sessions. The update is skipped if
+// the hash was changed concurrently.
+func (p *Postgres) RehashPassword(ctx context.Context, userID int, oldHash, newHash string) error {
+	query := `
+		UPDATE users
+		SET password_hash = $1
+		WHERE id = $2 AND merchant_id = $3 AND password_hash = $4
+	`
+	_, err := p.db.ExecContext(ctx, query, newHash, userID, tenant.MerchantID(ctx), oldHash)
+	return err
+}
+
+// CreateOrder inserts the order for the merchant in ctx. Order numbers are
+// unique across merchants, so a number taken by another merchant's user is
+// reported as ErrOrderAlreadyUploadedByAnotherUser.
 func (p *Postgres) CreateOrder(ctx context.Context, order models.Order) error {
 	query := `
-		INSERT INTO orders (number, user_id, status, accrual)
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO orders (number, user_id, merchant_id, status, accrual)
+		VALUES ($1, $2, $3, $4, $5)
 		ON CONFLICT (number) DO NOTHING
 	`
 
-	_, err := p.db.ExecContext(ctx, query,
+	res, err := p.db.ExecContext(ctx, query,
 		order.Number,
 		order.UserID,
+		tenant.MerchantID(ctx),
 		order.Status,
 		order.Accrual,
 	)
+	if err != nil {
+		return err
+	}
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if inserted == 0 {
+		return e.ErrOrderAlreadyUploadedByAnotherUser
+	}
+	return nil
+}
 
-	return err
+// CreateOrders inserts the user's new orders with one statement and returns
+// the numbers that were inserted. Numbers another request took first are
+// skipped.
+func (p *Postgres) CreateOrders(ctx context.Context, userID int, numbers []string) ([]string, error) {
+	if len(numbers) == 0 {
+		return nil, nil
+	}
+	query := `
+		INSERT INTO orders (number, user_id, merchant_id, status, accrual)
+		SELECT number, $2, $3, $4, 0 FROM unnest($1::text[]) AS number
+		ON CONFLICT (number) DO NOTHING
+		RETURNING number
+	`
+	rows, err := p.db.QueryContext(ctx, query, numbers, userID, tenant.MerchantID(ctx), models.OrderStatusNew)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	inserted := make([]string, 0, len(numbers))
+	for rows.Next() {
+		var number string
+		if err := rows.Scan(&number); err != nil {
+			return nil, err
+		}
+		inserted = append(inserted, number)
+	}
+	return inserted, rows.Err()
 }
 
 func (p *Postgres) GetOrderByNumber(ctx context.Context, number string) (models.Order, error) {
 	query := `
-		SELECT number, user_id, status, accrual, uploaded_at 
+		SELECT number, user_id, merchant_id, status, accrual, uploaded_at, updated_at
 		FROM orders 
-		WHERE number = $1
+		WHERE number = $1 AND merchant_id = $2
 	`
 	var order models.Order
-	err := p.db.QueryRowContext(ctx, query, number).Scan(
+	err := p.db.QueryRowContext(ctx, query, number, tenant.MerchantID(ctx)).Scan(
 		&order.Number,
 		&order.UserID,
+		&order.MerchantID,
 		&order.Status,
 		&order.Accrual,
-		&order.UploadedAt)
+		&order.UploadedAt,
+		&order.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Order{}, e.ErrOrderNotFound
+	}
 	if err != nil {
 		return models.Order{}, err
 	}
 	return order, nil
 }
 
-func (p *Postgres) GetOrdersByUserID(ctx context.Context, userID int) ([]models.Order, error) {
+// GetOrdersByNumbers returns the orders among numbers that already exist in
+// the current merchant.
+func (p *Postgres) GetOrdersByNumbers(ctx context.Context, numbers []string) ([]models.Order, error) {
+	if len(numbers) == 0 {
+		return nil, nil
+	}
+	query := `
+		SELECT number, user_id, merchant_id, status, accrual, uploaded_at, updated_at
+		FROM orders
+		WHERE number = ANY($1) AND merchant_id = $2
+	`
+	rows, err := p.db.QueryContext(ctx, query, numbers, tenant.MerchantID(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var orders []models.Order
+	for rows.Next() {
+		var order models.Order
+		if err := rows.Scan(
+			&order.Number,
+			&order.UserID,
+			&order.MerchantID,
+			&order.Status,
+			&order.Accrual,
+			&order.UploadedAt,
+			&order.UpdatedAt); err != nil {
+			return nil, err
+		}
+		orders = append(orders, order)
+	}
+	return orders, rows.Err()
+}
+
+// GetOrdersByUserID returns the user's orders selected by q, newest first
+// unless q asks for ascending order.
+func (p *Postgres) GetOrdersByUserID(ctx context.Context, userID int, q models.PageQuery) ([]models.Order, error) {
+	page, args := pageClause(q, "uploaded_at", "number", []any{userID, tenant.MerchantID(ctx)})
 	query := `
 		SELECT number, status, accrual, uploaded_at 
 		FROM orders 
-		WHERE user_id = $1
-		ORDER BY uploaded_at DESC
-	`
-	rows, err := p.db.QueryContext(ctx, query, userID)
+		WHERE user_id = $1 AND merchant_id = $2` + page
+	rows, err := p.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -161,11 +518,13 @@ func (p *Postgres) GetOrdersByUserID(ctx context.Context, userID int) ([]models.
 	return orders, nil
 }
 
+// UpdateOrder stores a polled status and accrual. Polls that change nothing
+// leave the row, and with it updated_at and the order's ETag, alone.
 func (p *Postgres) UpdateOrder(ctx context.Context, order models.Order) error {
 	query := `
 		UPDATE orders 
 		SET status = $1, accrual = $2, updated_at = NOW() 
-		WHERE number = $3
+		WHERE number = $3 AND (status IS DISTINCT FROM $1 OR accrual IS DISTINCT FROM $2)
 	`
 	_, err := p.db.ExecContext(ctx, query,
 		order.Status,
@@ -174,28 +533,44 @@ func (p *Postgres) UpdateOrder(ctx context.Context, order models.Order) error {
 	return err
 }
 
+// CreateWithdrawal stores a withdrawal for the merchant in ctx. Order
+// numbers are unique per merchant; a taken one yields
+// ErrWithdrawalAlreadyExists.
 func (p *Postgres) CreateWithdrawal(ctx context.Context, withdrawal models.Withdrawal) error {
 	query := `
-		INSERT INTO withdrawals (order_number, user_id, sum)
-		VALUES ($1, $2, $3)
+		INSERT INTO withdrawals (order_number, user_id, merchant_id, sum, status)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (merchant_id, order_number) DO NOTHING
 	`
-	_, err := p.db.ExecContext(ctx, query,
+	result, err := p.db.ExecContext(ctx, query,
 		withdrawal.Order,
 		withdrawal.UserID,
+		tenant.MerchantID(ctx),
 		withdrawal.Sum,
+		withdrawal.Status,
 	)
-
-	return err
-
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return e.ErrWithdrawalAlreadyExists
+	}
+	return nil
 }
-func (p *Postgres) GetWithdrawalsByUserID(ctx context.Context, userID int) ([]models.Withdrawal, error) {
+
+// GetWithdrawalsByUserID returns the user's withdrawals selected by q,
+// newest first unless q asks for ascending order.
+func (p *Postgres) GetWithdrawalsByUserID(ctx context.Context, userID int, q models.PageQuery) ([]models.Withdrawal, error) {
+	page, args := pageClause(q, "processed_at", "order_number", []any{userID, tenant.MerchantID(ctx)})
 	query := `
-		SELECT order_number, sum, processed_at 
+		SELECT order_number, sum, status, processed_at 
 		FROM withdrawals 
-		WHERE user_id = $1
-		ORDER BY processed_at DESC
-	`
-	rows, err := p.db.QueryContext(ctx, query, userID)
+		WHERE user_id = $1 AND merchant_id = $2` + page
+	rows, err := p.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -207,6 +582,7 @@ func (p *Postgres) GetWithdrawalsByUserID(ctx context.Context, userID int) ([]mo
 		if err := rows.Scan(
 			&w.Order,
 			&w.Sum,
+			&w.Status,
 			&w.ProcessedAt,
 		); err != nil {
 			return nil, err
@@ -221,24 +597,113 @@ func (p *Postgres) GetWithdrawalsByUserID(ctx context.Context, userID int) ([]mo
 	return withdrawals, nil
 }
 
+func (p *Postgres) GetWithdrawalsByStatus(ctx context.Context, status models.WithdrawalStatus) ([]models.Withdrawal, error) {
+	query := `
+		SELECT order_number, user_id, sum, status, processed_at
+		FROM withdrawals
+		WHERE status = $1 AND merchant_id = $2
+		ORDER BY processed_at ASC
+	`
+	rows, err := p.db.QueryContext(ctx, query, status, tenant.MerchantID(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var withdrawals []models.Withdrawal
+	for rows.Next() {
+		var w models.Withdrawal
+		if err := rows.Scan(
+			&w.Order,
+			&w.UserID,
+			&w.Sum,
+			&w.Status,
+			&w.ProcessedAt,
+		); err != nil {
+			return nil, err
+		}
+		withdrawals = append(withdrawals, w)
+	}
+
+	return withdrawals, rows.Err()
+}
+
+// ReviewWithdrawal moves a PENDING_REVIEW withdrawal to its final status and
+// stores the decision in the same transaction.
+func (p *Postgres) ReviewWithdrawal(ctx context.Context, review models.WithdrawalReview) error {
+	status := models.WithdrawalStatusProcessed
+	if review.Decision == models.ReviewDecisionRejected {
+		status = models.WithdrawalStatusRejected
+	}
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var current models.WithdrawalStatus
+	err = tx.QueryRowContext(ctx,
+		`SELECT status FROM withdrawals WHERE order_number = $1 AND merchant_id = $2 FOR UPDATE`,
+		review.Order, tenant.MerchantID(ctx),
+	).Scan(&current)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return e.ErrWithdrawalNotFound
+		}
+		return err
+	}
+	if current != models.WithdrawalStatusPendingReview {
+		return e.ErrWithdrawalNotPending
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE withdrawals SET status = $1 WHERE order_number = $2 AND merchant_id = $3`,
+		status, review.Order, tenant.MerchantID(ctx),
+	); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO withdrawal_reviews (order_number, merchant_id, reviewer_id, decision, reason)
+		VALUES ($1, $2, $3, $4, $5)
+	`, review.Order, tenant.MerchantID(ctx), review.ReviewerID, review.Decision, review.Reason); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetUserBalance returns the spendable balance and the withdrawn total.
+// Accruals of processed orders and redeemed vouchers add to the balance.
+// Withdrawals waiting for review are held: they reduce the current balance
+// but are not counted as withdrawn until approved.
 func (p *Postgres) GetUserBalance(ctx context.Context, userID int) (current, withdrawn float64, err error) {
+	return userBalance(ctx, p.db, userID)
+}
+
+func userBalance(ctx context.Context, db queryRower, userID int) (current, withdrawn float64, err error) {
 	query := `
         SELECT 
-            COALESCE(SUM(CASE WHEN o.status = $2 THEN o.accrual ELSE 0 END), 0) as current,
-            COALESCE(SUM(w.sum), 0) as withdrawn
-        FROM users u
-        LEFT JOIN orders o ON o.user_id = u.id
-        LEFT JOIN withdrawals w ON w.user_id = u.id
-        WHERE u.id = $1
-        GROUP BY u.id
+            COALESCE((SELECT SUM(accrual) FROM orders WHERE user_id = $1 AND merchant_id = $5 AND status = $2), 0) as accrued,
+            COALESCE((SELECT SUM(sum) FROM withdrawals WHERE user_id = $1 AND merchant_id = $5 AND status = $3), 0) as withdrawn,
+            COALESCE((SELECT SUM(sum) FROM withdrawals WHERE user_id = $1 AND merchant_id = $5 AND status = $4), 0) as held,
+            COALESCE((SELECT SUM(amount) FROM vouchers WHERE redeemed_by = $1 AND merchant_id = $5), 0) as vouchers,
+            COALESCE((SELECT SUM(amount) FROM balance_adjustments WHERE user_id = $1 AND merchant_id = $5 AND status = $6), 0) as adjustments
     `
-	err = p.db.QueryRowContext(ctx, query, userID, models.OrderStatusProcessed).Scan(&current, &withdrawn)
-	return current - withdrawn, withdrawn, err
+	var accrued, held, vouchers, adjustments float64
+	err = db.QueryRowContext(ctx, query, userID,
+		models.OrderStatusProcessed,
+		models.WithdrawalStatusProcessed,
+		models.WithdrawalStatusPendingReview,
+		tenant.MerchantID(ctx),
+		models.AdjustmentStatusApplied,
+	).Scan(&accrued, &withdrawn, &held, &vouchers, &adjustments)
+	return accrued + vouchers + adjustments - withdrawn - held, withdrawn, err
 }
 
 func (p *Postgres) GetOrdersToProcess(ctx context.Context, limit int) ([]models.Order, error) {
 	query := `
-		SELECT number, user_id, status, accrual, uploaded_at
+		SELECT number, user_id, merchant_id, status, accrual, uploaded_at
 		FROM orders
 		WHERE status IN ('NEW', 'PROCESSING')
 		ORDER BY uploaded_at ASC
@@ -257,6 +722,7 @@ func (p *Postgres) GetOrdersToProcess(ctx context.Context, limit int) ([]models.
 		if err := rows.Scan(
 			&order.Number,
 			&order.UserID,
+			&order.MerchantID,
 			&order.Status,
 			&order.Accrual,
 			&order.UploadedAt,
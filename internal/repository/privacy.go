@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	e "github.com/chestorix/gophermart/internal/errors"
+	"github.com/chestorix/gophermart/internal/models"
+	"github.com/chestorix/gophermart/internal/tenant"
+)
+
+// CloseUser settles the balance of a closing account and pseudonymizes it:
+// the login is replaced, the password and second factor are dropped, every
+// token and session is invalidated and stored IP addresses and user agents
+// are cleared. Orders, withdrawals and adjustments keep pointing at the
+// user ID.
+//
+// A positive balance is held for payout as a withdrawal waiting for review,
+// or forfeited with an adjustment. Everything happens in one transaction
+// that locks the user row, so concurrent closures cannot settle twice.
+func (p *Postgres) CloseUser(ctx context.Context, userID int, pseudonym string, settlement models.ClosureBalance) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	merchantID := tenant.MerchantID(ctx)
+	err = tx.QueryRowContext(ctx,
+		`SELECT id FROM users WHERE id = $1 AND merchant_id = $2 AND deleted_at IS NULL FOR UPDATE`,
+		userID, merchantID,
+	).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return e.ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	current, _, err := userBalance(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+	if current > 0 {
+		if err := settleClosedAccount(ctx, tx, userID, current, settlement); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE users
+		SET login = $1,
+		    password_hash = '',
+		    disabled_at = COALESCE(disabled_at, NOW()),
+		    deleted_at = NOW(),
+		    token_version = token_version + 1
+		WHERE id = $2
+	`, pseudonym, userID); err != nil {
+		return err
+	}
+
+	for _, query := range []string{
+		`DELETE FROM user_totp WHERE user_id = $1`,
+		`DELETE FROM recovery_codes WHERE user_id = $1`,
+		`DELETE FROM mfa_challenges WHERE user_id = $1`,
+		`DELETE FROM password_reset_tokens WHERE user_id = $1`,
+		`UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`,
+		`UPDATE sessions SET ip = '', user_agent = '', revoked_at = COALESCE(revoked_at, NOW()) WHERE user_id = $1`,
+		`UPDATE order_upload_attempts SET ip = '' WHERE user_id = $1`,
+		`UPDATE fraud_signals SET ip = '' WHERE user_id = $1`,
+		`UPDATE admin_audit_log SET ip = '' WHERE actor_id = $1`,
+	} {
+		if _, err := tx.ExecContext(ctx, query, userID); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func settleClosedAccount(ctx context.Context, tx *sql.Tx, userID int, balance float64, settlement models.ClosureBalance) error {
+	if settlement == models.ClosureBalancePayout {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO withdrawals (order_number, user_id, merchant_id, sum, status)
+			VALUES ($1, $2, $3, $4, $5)
+		`, fmt.Sprintf("CLOSURE-%d", userID), userID, tenant.MerchantID(ctx), balance, models.WithdrawalStatusPendingReview)
+		return err
+	}
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO balance_adjustments (merchant_id, user_id, amount, reason_code, comment, created_by, status)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, tenant.MerchantID(ctx), userID, -balance, models.AdjustmentReasonAccountClosure,
+		"balance forfeited on account closure", userID, models.AdjustmentStatusApplied)
+	return err
+}
@@ -0,0 +1,120 @@
+package repository
+
+import (
+	"context"
+	e "github.com/chestorix/gophermart/internal/errors"
+	"github.com/chestorix/gophermart/internal/models"
+	"github.com/chestorix/gophermart/internal/tenant"
+)
+
+func (p *Postgres) CreateSession(ctx context.Context, session models.Session) error {
+	query := `
+		INSERT INTO sessions (id, user_id, merchant_id, ip, user_agent)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (id) DO NOTHING
+	`
+	_, err := p.db.ExecContext(ctx, query,
+		session.ID,
+		session.UserID,
+		tenant.MerchantID(ctx),
+		session.IP,
+		session.UserAgent,
+	)
+	return err
+}
+
+// TouchSession records activity on a session and reports whether it has
+// been revoked. Sessions opened before sessions were recorded are created
+// on first use.
+func (p *Postgres) TouchSession(ctx context.Context, session models.Session) (bool, error) {
+	query := `
+		INSERT INTO sessions (id, user_id, merchant_id, ip, user_agent)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (id) DO UPDATE
+		SET last_seen_at = NOW(), ip = EXCLUDED.ip, user_agent = EXCLUDED.user_agent
+		RETURNING revoked_at IS NOT NULL
+	`
+	var revoked bool
+	err := p.db.QueryRowContext(ctx, query,
+		session.ID,
+		session.UserID,
+		tenant.MerchantID(ctx),
+		session.IP,
+		session.UserAgent,
+	).Scan(&revoked)
+	return revoked, err
+}
+
+// GetSessions returns the user's live sessions, most recently used first.
+// A session is live while it has a refresh token that can still be used.
+func (p *Postgres) GetSessions(ctx context.Context, userID int) ([]models.Session, error) {
+	query := `
+		SELECT s.id, s.user_id, s.merchant_id, s.ip, s.user_agent, s.created_at, s.last_seen_at, s.revoked_at
+		FROM sessions s
+		WHERE s.user_id = $1 AND s.merchant_id = $2 AND s.revoked_at IS NULL
+		  AND EXISTS (
+			SELECT 1 FROM refresh_tokens t
+			WHERE t.family_id = s.id AND t.used_at IS NULL AND t.revoked_at IS NULL AND t.expires_at > NOW()
+		  )
+		ORDER BY s.last_seen_at DESC
+	`
+	rows, err := p.db.QueryContext(ctx, query, userID, tenant.MerchantID(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []models.Session
+	for rows.Next() {
+		var session models.Session
+		if err := rows.Scan(
+			&session.ID,
+			&session.UserID,
+			&session.MerchantID,
+			&session.IP,
+			&session.UserAgent,
+			&session.CreatedAt,
+			&session.LastSeenAt,
+			&session.RevokedAt,
+		); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// RevokeSession marks one of the user's sessions as revoked and revokes its
+// refresh tokens.
+func (p *Postgres) RevokeSession(ctx context.Context, userID int, id string) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE sessions
+		SET revoked_at = NOW()
+		WHERE id = $1 AND user_id = $2 AND merchant_id = $3 AND revoked_at IS NULL
+	`, id, userID, tenant.MerchantID(ctx))
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return e.ErrSessionNotFound
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE refresh_tokens
+		SET revoked_at = NOW()
+		WHERE family_id = $1 AND merchant_id = $2 AND revoked_at IS NULL
+	`, id, tenant.MerchantID(ctx)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
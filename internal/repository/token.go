@@ -0,0 +1,154 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	e "github.com/chestorix/gophermart/internal/errors"
+	"github.com/chestorix/gophermart/internal/models"
+	"github.com/chestorix/gophermart/internal/tenant"
+	"time"
+)
+
+func (p *Postgres) CreateRefreshToken(ctx context.Context, token models.RefreshToken) error {
+	query := `
+		INSERT INTO refresh_tokens (user_id, merchant_id, family_id, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`
+	_, err := p.db.ExecContext(ctx, query,
+		token.UserID,
+		tenant.MerchantID(ctx),
+		token.FamilyID,
+		token.TokenHash,
+		token.ExpiresAt,
+	)
+	return err
+}
+
+// UseRefreshToken marks a token of the merchant in ctx as used and returns
+// it. A token that was already used yields ErrRefreshTokenReused together
+// with the stored token, so the caller can revoke its family. Tokens of
+// other merchants are not touched.
+func (p *Postgres) UseRefreshToken(ctx context.Context, tokenHash string) (models.RefreshToken, error) {
+	query := `
+		UPDATE refresh_tokens
+		SET used_at = NOW()
+		WHERE token_hash = $1 AND merchant_id = $2 AND used_at IS NULL AND revoked_at IS NULL AND expires_at > NOW()
+		RETURNING id, user_id, family_id, token_hash, created_at, expires_at, used_at, revoked_at
+	`
+	token, err := scanRefreshToken(p.db.QueryRowContext(ctx, query, tokenHash, tenant.MerchantID(ctx)))
+	if err == nil {
+		return token, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return models.RefreshToken{}, err
+	}
+
+	token, err = scanRefreshToken(p.db.QueryRowContext(ctx, `
+		SELECT id, user_id, family_id, token_hash, created_at, expires_at, used_at, revoked_at
+		FROM refresh_tokens
+		WHERE token_hash = $1 AND merchant_id = $2
+	`, tokenHash, tenant.MerchantID(ctx)))
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return models.RefreshToken{}, e.ErrInvalidRefreshToken
+	case err != nil:
+		return models.RefreshToken{}, err
+	case token.UsedAt != nil:
+		return token, e.ErrRefreshTokenReused
+	default:
+		return models.RefreshToken{}, e.ErrInvalidRefreshToken
+	}
+}
+
+func scanRefreshToken(row *sql.Row) (models.RefreshToken, error) {
+	var token models.RefreshToken
+	err := row.Scan(
+		&token.ID,
+		&token.UserID,
+		&token.FamilyID,
+		&token.TokenHash,
+		&token.CreatedAt,
+		&token.ExpiresAt,
+		&token.UsedAt,
+		&token.RevokedAt,
+	)
+	return token, err
+}
+
+func (p *Postgres) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = NOW()
+		WHERE family_id = $1 AND merchant_id = $2 AND revoked_at IS NULL
+	`
+	_, err := p.db.ExecContext(ctx, query, familyID, tenant.MerchantID(ctx))
+	return err
+}
+
+// RevokeUserRefreshTokens revokes every refresh token of the user except
+// the ones in exceptFamilyID, which may be empty.
+func (p *Postgres) RevokeUserRefreshTokens(ctx context.Context, userID int, exceptFamilyID string) error {
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = NOW()
+		WHERE user_id = $1 AND family_id <> $2 AND revoked_at IS NULL
+	`
+	_, err := p.db.ExecContext(ctx, query, userID, exceptFamilyID)
+	return err
+}
+
+// RevokeToken puts an access token on the revocation list until it expires
+// and drops entries whose tokens have expired anyway.
+func (p *Postgres) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	if _, err := p.db.ExecContext(ctx,
+		`DELETE FROM revoked_tokens WHERE expires_at < NOW()`,
+	); err != nil {
+		return err
+	}
+	_, err := p.db.ExecContext(ctx, `
+		INSERT INTO revoked_tokens (jti, merchant_id, expires_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (jti) DO NOTHING
+	`, jti, tenant.MerchantID(ctx), expiresAt)
+	return err
+}
+
+// IsTokenRevoked reports whether the merchant in ctx revoked the token.
+// Entries stored before revocations were scoped count for every merchant
+// until they expire.
+func (p *Postgres) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	var revoked bool
+	err := p.db.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1 AND (merchant_id = $2 OR merchant_id IS NULL))`,
+		jti, tenant.MerchantID(ctx),
+	).Scan(&revoked)
+	return revoked, err
+}
+
+func (p *Postgres) CreatePasswordResetToken(ctx context.Context, userID int, tokenHash string, expiresAt time.Time) error {
+	query := `
+		INSERT INTO password_reset_tokens (user_id, merchant_id, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4)
+	`
+	_, err := p.db.ExecContext(ctx, query, userID, tenant.MerchantID(ctx), tokenHash, expiresAt)
+	return err
+}
+
+// UsePasswordResetToken consumes a reset token of the merchant in ctx and
+// returns its user. The conditional UPDATE makes the token single-use even
+// under concurrent calls.
+func (p *Postgres) UsePasswordResetToken(ctx context.Context, tokenHash string) (int, error) {
+	query := `
+		UPDATE password_reset_tokens
+		SET used_at = NOW()
+		WHERE token_hash = $1 AND merchant_id = $2 AND used_at IS NULL AND expires_at > NOW()
+		RETURNING user_id
+	`
+	var userID int
+	err := p.db.QueryRowContext(ctx, query, tokenHash, tenant.MerchantID(ctx)).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, e.ErrInvalidResetToken
+	}
+	return userID, err
+}
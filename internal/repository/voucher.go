@@ -0,0 +1,141 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	e "github.com/chestorix/gophermart/internal/errors"
+	"github.com/chestorix/gophermart/internal/models"
+	"github.com/chestorix/gophermart/internal/tenant"
+	"time"
+)
+
+// CreateVouchers inserts a whole batch in one transaction.
+func (p *Postgres) CreateVouchers(ctx context.Context, vouchers []models.Voucher) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO vouchers (code, merchant_id, batch_id, amount, expires_at, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	merchantID := tenant.MerchantID(ctx)
+	for _, v := range vouchers {
+		if _, err := stmt.ExecContext(ctx,
+			v.Code,
+			merchantID,
+			v.BatchID,
+			v.Amount,
+			v.ExpiresAt,
+			v.CreatedBy,
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (p *Postgres) GetVouchersByBatch(ctx context.Context, batchID string) ([]models.Voucher, error) {
+	query := `
+		SELECT code, merchant_id, batch_id, amount, expires_at, created_by, created_at, redeemed_by, redeemed_at
+		FROM vouchers
+		WHERE batch_id = $1 AND merchant_id = $2
+		ORDER BY code
+	`
+	return p.queryVouchers(ctx, query, batchID, tenant.MerchantID(ctx))
+}
+
+func (p *Postgres) GetVouchersByRedeemer(ctx context.Context, userID int) ([]models.Voucher, error) {
+	query := `
+		SELECT code, merchant_id, batch_id, amount, expires_at, created_by, created_at, redeemed_by, redeemed_at
+		FROM vouchers
+		WHERE redeemed_by = $1 AND merchant_id = $2
+		ORDER BY redeemed_at DESC
+	`
+	return p.queryVouchers(ctx, query, userID, tenant.MerchantID(ctx))
+}
+
+func (p *Postgres) queryVouchers(ctx context.Context, query string, args ...any) ([]models.Voucher, error) {
+	rows, err := p.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var vouchers []models.Voucher
+	for rows.Next() {
+		var v models.Voucher
+		if err := rows.Scan(
+			&v.Code,
+			&v.MerchantID,
+			&v.BatchID,
+			&v.Amount,
+			&v.ExpiresAt,
+			&v.CreatedBy,
+			&v.CreatedAt,
+			&v.RedeemedBy,
+			&v.RedeemedAt,
+		); err != nil {
+			return nil, err
+		}
+		vouchers = append(vouchers, v)
+	}
+	return vouchers, rows.Err()
+}
+
+// RedeemVoucher marks the voucher as redeemed by userID. The conditional
+// UPDATE takes a row lock, so of two concurrent redemptions only one sees
+// redeemed_at IS NULL and succeeds.
+func (p *Postgres) RedeemVoucher(ctx context.Context, code string, userID int) (models.Voucher, error) {
+	query := `
+		UPDATE vouchers
+		SET redeemed_by = $1, redeemed_at = NOW()
+		WHERE code = $2 AND merchant_id = $3
+		  AND redeemed_at IS NULL
+		  AND (expires_at IS NULL OR expires_at > NOW())
+		RETURNING code, merchant_id, batch_id, amount, expires_at, created_by, created_at, redeemed_by, redeemed_at
+	`
+	merchantID := tenant.MerchantID(ctx)
+	var v models.Voucher
+	err := p.db.QueryRowContext(ctx, query, userID, code, merchantID).Scan(
+		&v.Code,
+		&v.MerchantID,
+		&v.BatchID,
+		&v.Amount,
+		&v.ExpiresAt,
+		&v.CreatedBy,
+		&v.CreatedAt,
+		&v.RedeemedBy,
+		&v.RedeemedAt,
+	)
+	if err == nil {
+		return v, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return models.Voucher{}, err
+	}
+
+	var redeemedAt, expiresAt *time.Time
+	err = p.db.QueryRowContext(ctx,
+		`SELECT redeemed_at, expires_at FROM vouchers WHERE code = $1 AND merchant_id = $2`,
+		code, merchantID,
+	).Scan(&redeemedAt, &expiresAt)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return models.Voucher{}, e.ErrVoucherNotFound
+	case err != nil:
+		return models.Voucher{}, err
+	case redeemedAt != nil:
+		return models.Voucher{}, e.ErrVoucherAlreadyRedeemed
+	default:
+		return models.Voucher{}, e.ErrVoucherExpired
+	}
+}
@@ -0,0 +1,161 @@
+package service
+
+import (
+	"context"
+	e "github.com/chestorix/gophermart/internal/errors"
+	"github.com/chestorix/gophermart/internal/models"
+	"math"
+	"sort"
+	"strings"
+)
+
+// CreateAdjustment records a manual balance change by an operator. Above
+// the approval threshold it waits for a second operator before it counts.
+// A debit may not take the balance below zero.
+func (s *Service) CreateAdjustment(ctx context.Context, adj models.BalanceAdjustment) (models.BalanceAdjustment, error) {
+	adj.Comment = strings.TrimSpace(adj.Comment)
+	if adj.Amount == 0 || math.IsNaN(adj.Amount) || math.IsInf(adj.Amount, 0) || !adj.Reason.Valid() || adj.Comment == "" {
+		return models.BalanceAdjustment{}, e.ErrInvalidAdjustment
+	}
+
+	adj.Status = models.AdjustmentStatusApplied
+	if s.adjustmentApprovalThreshold > 0 && math.Abs(adj.Amount) > s.adjustmentApprovalThreshold {
+		adj.Status = models.AdjustmentStatusPendingApproval
+	}
+	if adj.Status == models.AdjustmentStatusApplied {
+		if err := s.checkAdjustmentFunds(ctx, adj); err != nil {
+			return models.BalanceAdjustment{}, err
+		}
+	}
+
+	created, err := s.repo.CreateAdjustment(ctx, adj)
+	if err != nil {
+		return models.BalanceAdjustment{}, err
+	}
+	s.logger.Infof("adjustment %d of %.2f for user %d by user %d: %s",
+		created.ID, created.Amount, created.UserID, created.CreatedBy, created.Status)
+	return created, nil
+}
+
+func (s *Service) GetUserAdjustments(ctx context.Context, userID int) ([]models.BalanceAdjustment, error) {
+	return s.repo.GetAdjustments(ctx, userID, "")
+}
+
+func (s *Service) GetPendingAdjustments(ctx context.Context) ([]models.BalanceAdjustment, error) {
+	return s.repo.GetAdjustments(ctx, 0, models.AdjustmentStatusPendingApproval)
+}
+
+// ReviewAdjustment approves or rejects a held adjustment. The operator who
+// created it cannot decide on it.
+func (s *Service) ReviewAdjustment(ctx context.Context, id, reviewerID int, decision models.ReviewDecision) (models.BalanceAdjustment, error) {
+	adj, err := s.repo.GetAdjustment(ctx, id)
+	if err != nil {
+		return models.BalanceAdjustment{}, err
+	}
+	if adj.CreatedBy == reviewerID {
+		return models.BalanceAdjustment{}, e.ErrSelfApproval
+	}
+	if adj.Status != models.AdjustmentStatusPendingApproval {
+		return models.BalanceAdjustment{}, e.ErrAdjustmentNotPending
+	}
+	if decision == models.ReviewDecisionApproved {
+		if err := s.checkAdjustmentFunds(ctx, adj); err != nil {
+			return models.BalanceAdjustment{}, err
+		}
+	}
+
+	reviewed, err := s.repo.ReviewAdjustment(ctx, id, reviewerID, decision)
+	if err != nil {
+		return models.BalanceAdjustment{}, err
+	}
+	s.logger.Infof("adjustment %d %s by user %d", id, decision, reviewerID)
+	return reviewed, nil
+}
+
+func (s *Service) checkAdjustmentFunds(ctx context.Context, adj models.BalanceAdjustment) error {
+	if adj.Amount > 0 {
+		return nil
+	}
+	current, _, err := s.repo.GetUserBalance(ctx, adj.UserID)
+	if err != nil {
+		return err
+	}
+	if current+adj.Amount < 0 {
+		return e.ErrAdjustmentExceedsBalance
+	}
+	return nil
+}
+
+// GetUserHistory lists every change of the user's balance, newest first:
+// accruals of processed orders, withdrawals, redeemed vouchers and applied
+// adjustments.
+func (s *Service) GetUserHistory(ctx context.Context, userID int) ([]models.HistoryEntry, error) {
+	orders, err := s.repo.GetOrdersByUserID(ctx, userID, models.PageQuery{})
+	if err != nil {
+		return nil, err
+	}
+	withdrawals, err := s.repo.GetWithdrawalsByUserID(ctx, userID, models.PageQuery{})
+	if err != nil {
+		return nil, err
+	}
+	vouchers, err := s.repo.GetVouchersByRedeemer(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	adjustments, err := s.repo.GetAdjustments(ctx, userID, models.AdjustmentStatusApplied)
+	if err != nil {
+		return nil, err
+	}
+
+	var history []models.HistoryEntry
+	for _, o := range orders {
+		if o.Status != models.OrderStatusProcessed || o.Accrual == 0 {
+			continue
+		}
+		history = append(history, models.HistoryEntry{
+			Type:      models.HistoryEntryAccrual,
+			Amount:    o.Accrual,
+			Reference: o.Number,
+			Status:    string(o.Status),
+			At:        o.UploadedAt,
+		})
+	}
+	for _, w := range withdrawals {
+		history = append(history, models.HistoryEntry{
+			Type:      models.HistoryEntryWithdrawal,
+			Amount:    -w.Sum,
+			Reference: w.Order,
+			Status:    string(w.Status),
+			At:        w.ProcessedAt,
+		})
+	}
+	for _, v := range vouchers {
+		if v.RedeemedAt == nil {
+			continue
+		}
+		history = append(history, models.HistoryEntry{
+			Type:      models.HistoryEntryVoucher,
+			Amount:    v.Amount,
+			Reference: v.DisplayCode(),
+			At:        *v.RedeemedAt,
+		})
+	}
+	for _, a := range adjustments {
+		at := a.CreatedAt
+		if a.ReviewedAt != nil {
+			at = *a.ReviewedAt
+		}
+		history = append(history, models.HistoryEntry{
+			Type:      models.HistoryEntryAdjustment,
+			Amount:    a.Amount,
+			Reference: string(a.Reason),
+			Status:    string(a.Status),
+			At:        at,
+		})
+	}
+
+	sort.SliceStable(history, func(i, j int) bool {
+		return history[i].At.After(history[j].At)
+	})
+	return history, nil
+}
@@ -0,0 +1,120 @@
+package service
+
+import (
+	"context"
+	e "github.com/chestorix/gophermart/internal/errors"
+	"github.com/chestorix/gophermart/internal/models"
+	"github.com/chestorix/gophermart/internal/tenant"
+	"github.com/chestorix/gophermart/internal/validation"
+	"strings"
+)
+
+// adminLogin is an ADMIN_LOGINS entry. Logins are unique only within a
+// merchant, so every entry names one: "code:login", or a bare login for the
+// default merchant.
+type adminLogin struct {
+	merchant string
+	login    string
+}
+
+func parseAdminLogin(entry string, credentials *validation.CredentialPolicy) adminLogin {
+	merchant, login, ok := strings.Cut(entry, ":")
+	if !ok {
+		merchant, login = "", entry
+	}
+	return adminLogin{merchant: merchant, login: credentials.NormalizeLogin(login)}
+}
+
+// UserRole returns the role of a user. Logins listed in ADMIN_LOGINS are
+// admins of their merchant whatever their stored role, which bootstraps the
+// first admin.
+func (s *Service) UserRole(ctx context.Context, userID int) (models.Role, error) {
+	user, err := s.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	if len(s.adminLogins) == 0 {
+		return user.Role, nil
+	}
+	admin, err := s.isBootstrapAdmin(ctx, user)
+	if err != nil {
+		return "", err
+	}
+	if admin {
+		return models.RoleAdmin, nil
+	}
+	return user.Role, nil
+}
+
+func (s *Service) isBootstrapAdmin(ctx context.Context, user models.User) (bool, error) {
+	if user.MerchantID == tenant.DefaultMerchantID {
+		if _, ok := s.adminLogins[adminLogin{login: user.Login}]; ok {
+			return true, nil
+		}
+	}
+	merchants, err := s.loadMerchants(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, m := range merchants {
+		if m.ID == user.MerchantID {
+			_, ok := s.adminLogins[adminLogin{merchant: m.Code, login: user.Login}]
+			return ok, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *Service) GetUser(ctx context.Context, userID int) (models.User, error) {
+	return s.repo.GetUserByID(ctx, userID)
+}
+
+func (s *Service) SearchUsers(ctx context.Context, query string, limit int) ([]models.User, error) {
+	return s.repo.SearchUsers(ctx, s.credentials.NormalizeLogin(query), limit)
+}
+
+func (s *Service) SetUserRole(ctx context.Context, userID int, role models.Role) error {
+	if !role.Valid() {
+		return e.ErrInvalidRole
+	}
+	return s.repo.SetUserRole(ctx, userID, role)
+}
+
+// SetUserDisabled disables or re-enables an account. A disabled user is
+// signed out everywhere and cannot log in again.
+func (s *Service) SetUserDisabled(ctx context.Context, userID int, disabled bool) error {
+	if err := s.repo.SetUserDisabled(ctx, userID, disabled); err != nil {
+		return err
+	}
+	s.userCache.invalidate(userCacheKey{merchantID: tenant.MerchantID(ctx), userID: userID})
+	if !disabled {
+		return nil
+	}
+	return s.repo.RevokeUserRefreshTokens(ctx, userID, "")
+}
+
+// RecheckOrder asks the accrual system about an order right away, e.g.
+// after the accrual system fixed a wrong status.
+func (s *Service) RecheckOrder(ctx context.Context, orderNumber string) (models.Order, error) {
+	order, err := s.repo.GetOrderByNumber(ctx, orderNumber)
+	if err != nil {
+		return models.Order{}, err
+	}
+	accrualResp, err := s.GetAccrual(ctx, order.Number)
+	if err != nil {
+		return models.Order{}, err
+	}
+	applyAccrual(&order, accrualResp)
+	if err := s.repo.UpdateOrder(ctx, order); err != nil {
+		return models.Order{}, err
+	}
+	return order, nil
+}
+
+func (s *Service) RecordAudit(ctx context.Context, entry models.AuditEntry) error {
+	return s.repo.CreateAuditEntry(ctx, entry)
+}
+
+func (s *Service) GetAuditLog(ctx context.Context, actorID, limit int) ([]models.AuditEntry, error) {
+	return s.repo.GetAuditLog(ctx, actorID, limit)
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"context"
+	"github.com/chestorix/gophermart/internal/interfaces"
+	"github.com/chestorix/gophermart/internal/models"
+	"github.com/chestorix/gophermart/internal/tenant"
+	"github.com/chestorix/gophermart/internal/validation"
+	"testing"
+)
+
+type usersRepo struct {
+	interfaces.Repository
+	users     map[int]models.User
+	merchants []models.Merchant
+}
+
+func (r *usersRepo) GetUserByID(ctx context.Context, userID int) (models.User, error) {
+	return r.users[userID], nil
+}
+
+func (r *usersRepo) GetMerchants(ctx context.Context) ([]models.Merchant, error) {
+	return r.merchants, nil
+}
+
+func TestUserRole_AdminLoginsPerMerchant(t *testing.T) {
+	const shopID = 2
+	repo := &usersRepo{
+		users: map[int]models.User{
+			1: {ID: 1, Login: "root", Role: models.RoleUser, MerchantID: tenant.DefaultMerchantID},
+			2: {ID: 2, Login: "root", Role: models.RoleUser, MerchantID: shopID},
+			3: {ID: 3, Login: "boss", Role: models.RoleUser, MerchantID: shopID},
+			4: {ID: 4, Login: "boss", Role: models.RoleUser, MerchantID: tenant.DefaultMerchantID},
+		},
+		merchants: []models.Merchant{
+			{ID: tenant.DefaultMerchantID, Code: "default"},
+			{ID: shopID, Code: "shop"},
+		},
+	}
+	credentials := &validation.CredentialPolicy{}
+	s := &Service{
+		repo: repo,
+		adminLogins: map[adminLogin]struct{}{
+			parseAdminLogin("Root", credentials):      {},
+			parseAdminLogin("shop:boss", credentials): {},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		userID int
+		want   models.Role
+	}{
+		{name: "bare login in default merchant", userID: 1, want: models.RoleAdmin},
+		{name: "bare login registered in another merchant", userID: 2, want: models.RoleUser},
+		{name: "login qualified with its merchant", userID: 3, want: models.RoleAdmin},
+		{name: "qualified login in default merchant", userID: 4, want: models.RoleUser},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			role, err := s.UserRole(context.Background(), tt.userID)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if role != tt.want {
+				t.Errorf("expected role %s, got %s", tt.want, role)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"context"
+	e "github.com/chestorix/gophermart/internal/errors"
+	"github.com/chestorix/gophermart/internal/models"
+	"strings"
+)
+
+const (
+	apiKeyPrefix    = "gmk_"
+	apiKeyPrefixLen = len(apiKeyPrefix) + 8
+)
+
+// CreateAPIKey issues a key with the given scopes. The key itself is
+// returned only here; afterwards it is known by its prefix.
+func (s *Service) CreateAPIKey(ctx context.Context, creatorID int, name string, scopes []string) (string, models.APIKey, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(scopes) == 0 {
+		return "", models.APIKey{}, e.ErrInvalidAPIKeyRequest
+	}
+	for _, scope := range scopes {
+		if !validScope(scope) {
+			return "", models.APIKey{}, e.ErrInvalidAPIKeyRequest
+		}
+	}
+
+	secret, err := randomToken(32)
+	if err != nil {
+		return "", models.APIKey{}, err
+	}
+	key := apiKeyPrefix + secret
+	apiKey, err := s.repo.CreateAPIKey(ctx, models.APIKey{
+		Name:      name,
+		Prefix:    key[:apiKeyPrefixLen],
+		KeyHash:   hashToken(key),
+		Scopes:    scopes,
+		CreatedBy: creatorID,
+	})
+	if err != nil {
+		return "", models.APIKey{}, err
+	}
+	s.logger.Infof("api key %d (%s) created by user %d with scopes %v", apiKey.ID, apiKey.Prefix, creatorID, scopes)
+	return key, apiKey, nil
+}
+
+func (s *Service) GetAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	return s.repo.GetAPIKeys(ctx)
+}
+
+func (s *Service) RevokeAPIKey(ctx context.Context, id int) error {
+	return s.repo.RevokeAPIKey(ctx, id)
+}
+
+func (s *Service) AuthenticateAPIKey(ctx context.Context, key string) (models.APIKey, error) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return models.APIKey{}, e.ErrInvalidAPIKey
+	}
+	return s.repo.UseAPIKey(ctx, hashToken(key))
+}
+
+func validScope(scope string) bool {
+	for _, known := range models.APIKeyScopes {
+		if scope == known {
+			return true
+		}
+	}
+	return false
+}
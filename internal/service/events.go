@@ -0,0 +1,150 @@
+package service
+
+import (
+	"context"
+	e "github.com/chestorix/gophermart/internal/errors"
+	"github.com/chestorix/gophermart/internal/models"
+	"sync"
+	"time"
+)
+
+const (
+	// eventBufferSize is how many events a slow stream may fall behind
+	// before it is closed; the client reconnects and reloads its state.
+	eventBufferSize    = 16
+	eventListenBackoff = 5 * time.Second
+)
+
+// eventHub fans out user events received on this replica to the streams
+// open here. It also counts each user's WebSocket connections.
+type eventHub struct {
+	mu          sync.Mutex
+	subscribers map[int]map[chan models.UserEvent]struct{}
+	webSockets  map[int]int
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{
+		subscribers: make(map[int]map[chan models.UserEvent]struct{}),
+		webSockets:  make(map[int]int),
+	}
+}
+
+func (h *eventHub) subscribe(userID int) (chan models.UserEvent, func()) {
+	ch := make(chan models.UserEvent, eventBufferSize)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subscribers[userID] == nil {
+		h.subscribers[userID] = make(map[chan models.UserEvent]struct{})
+	}
+	h.subscribers[userID][ch] = struct{}{}
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			h.remove(userID, ch)
+		})
+	}
+}
+
+// remove drops the subscriber; the caller holds mu.
+func (h *eventHub) remove(userID int, ch chan models.UserEvent) {
+	delete(h.subscribers[userID], ch)
+	if len(h.subscribers[userID]) == 0 {
+		delete(h.subscribers, userID)
+	}
+}
+
+func (h *eventHub) dispatch(event models.UserEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subscribers[event.UserID] {
+		select {
+		case ch <- event:
+		default:
+			h.remove(event.UserID, ch)
+			close(ch)
+		}
+	}
+}
+
+// acquireWebSocket takes one of the user's connection slots.
+func (h *eventHub) acquireWebSocket(userID, limit int) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if limit > 0 && h.webSockets[userID] >= limit {
+		return false
+	}
+	h.webSockets[userID]++
+	return true
+}
+
+func (h *eventHub) releaseWebSocket(userID int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.webSockets[userID]--; h.webSockets[userID] <= 0 {
+		delete(h.webSockets, userID)
+	}
+}
+
+// SubscribeEvents opens a stream of the user's events. The returned
+// function must be called once the stream is no longer read.
+func (s *Service) SubscribeEvents(ctx context.Context, userID int) (<-chan models.UserEvent, func()) {
+	return s.events.subscribe(userID)
+}
+
+// ConnectWebSocket subscribes a WebSocket connection to the user's events.
+// Each user may hold a limited number of connections per replica.
+func (s *Service) ConnectWebSocket(ctx context.Context, userID int) (<-chan models.UserEvent, func(), error) {
+	if !s.events.acquireWebSocket(userID, s.webSocketConnsPerUser) {
+		return nil, nil, e.ErrTooManyConnections
+	}
+	events, unsubscribe := s.events.subscribe(userID)
+	var once sync.Once
+	return events, func() {
+		once.Do(func() {
+			unsubscribe()
+			s.events.releaseWebSocket(userID)
+		})
+	}, nil
+}
+
+// ListenEvents feeds events published by any replica to the streams open
+// on this one, reconnecting until ctx is done.
+func (s *Service) ListenEvents(ctx context.Context) {
+	for {
+		err := s.repo.ListenEvents(ctx, s.events.dispatch)
+		if ctx.Err() != nil {
+			return
+		}
+		s.logger.Errorf("listening for user events failed: %v", err)
+		select {
+		case <-time.After(eventListenBackoff):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// publishEvent sends the event to every replica. Failures are only logged:
+// live updates are best effort and clients can always reload.
+func (s *Service) publishEvent(ctx context.Context, event models.UserEvent) {
+	if err := s.repo.PublishEvent(ctx, event); err != nil {
+		s.logger.Errorf("publish %s event for user %d failed: %v", event.Type, event.UserID, err)
+	}
+}
+
+func (s *Service) publishBalance(ctx context.Context, userID int) {
+	current, withdrawn, err := s.repo.GetUserBalance(ctx, userID)
+	if err != nil {
+		s.logger.Errorf("get balance of user %d for event failed: %v", userID, err)
+		return
+	}
+	s.publishEvent(ctx, models.UserEvent{
+		Type:    models.EventBalance,
+		UserID:  userID,
+		Balance: &models.BalanceEvent{Current: current, Withdrawn: withdrawn},
+	})
+}
@@ -0,0 +1,299 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/chestorix/gophermart/internal/config"
+	e "github.com/chestorix/gophermart/internal/errors"
+	"github.com/chestorix/gophermart/internal/interfaces"
+	"github.com/chestorix/gophermart/internal/models"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FraudEvent describes an order upload that has just been recorded.
+type FraudEvent struct {
+	UserID      int
+	IP          string
+	OrderNumber string
+	Result      models.UploadResult
+	At          time.Time
+}
+
+// FraudRule inspects upload history and reports whether the event is suspicious.
+type FraudRule interface {
+	Name() string
+	Action() models.FraudAction
+	Check(ctx context.Context, repo interfaces.Repository, event FraudEvent) (details string, triggered bool, err error)
+}
+
+// FraudEngine runs the configured rules on every upload and turns their
+// verdicts into fraud signals and account restrictions.
+type FraudEngine struct {
+	repo        interfaces.Repository
+	rules       []FraudRule
+	window      time.Duration
+	throttleFor time.Duration
+}
+
+func NewFraudEngine(repo interfaces.Repository, cfg config.FraudConfig) *FraudEngine {
+	engine := &FraudEngine{
+		repo:        repo,
+		window:      cfg.Window,
+		throttleFor: cfg.ThrottleFor,
+	}
+	if cfg.ConflictLimit > 0 {
+		engine.rules = append(engine.rules, &conflictRule{
+			limit:  cfg.ConflictLimit,
+			action: parseFraudAction(cfg.ConflictAction),
+			window: cfg.Window,
+		})
+	}
+	if cfg.SequentialLimit > 0 {
+		engine.rules = append(engine.rules, &sequentialRule{
+			limit:  cfg.SequentialLimit,
+			action: parseFraudAction(cfg.SequentialAction),
+			window: cfg.Window,
+		})
+	}
+	if cfg.AccountsPerIP > 0 {
+		engine.rules = append(engine.rules, &accountsPerIPRule{
+			limit:  cfg.AccountsPerIP,
+			action: parseFraudAction(cfg.AccountsAction),
+			window: cfg.Window,
+		})
+	}
+	return engine
+}
+
+func parseFraudAction(action string) models.FraudAction {
+	switch models.FraudAction(strings.ToUpper(action)) {
+	case models.FraudActionThrottle:
+		return models.FraudActionThrottle
+	case models.FraudActionBlock:
+		return models.FraudActionBlock
+	default:
+		return models.FraudActionFlag
+	}
+}
+
+// CheckRestrictions returns ErrAccountBlocked or ErrTooManyRequests when an
+// unresolved signal currently restricts the user.
+func (f *FraudEngine) CheckRestrictions(ctx context.Context, userID int) error {
+	signals, err := f.repo.GetFraudSignals(ctx, userID, true)
+	if err != nil {
+		return err
+	}
+	throttledSince := time.Now().Add(-f.throttleFor)
+	for _, signal := range signals {
+		switch signal.Action {
+		case models.FraudActionBlock:
+			return e.ErrAccountBlocked
+		case models.FraudActionThrottle:
+			if signal.CreatedAt.After(throttledSince) {
+				return e.ErrTooManyRequests
+			}
+		}
+	}
+	return nil
+}
+
+// Observe records the upload attempt and evaluates every rule against it.
+// A rule is not reported again while its last signal is still in force: a
+// block until it is resolved, a throttle for throttleFor and a flag for the
+// rule window.
+func (f *FraudEngine) Observe(ctx context.Context, event FraudEvent) error {
+	return f.ObserveBatch(ctx, []FraudEvent{event})
+}
+
+// ObserveBatch records the attempts of one user's batch upload. The rules
+// look at the stored history, so they run once per distinct result rather
+// than once per number.
+func (f *FraudEngine) ObserveBatch(ctx context.Context, events []FraudEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	attempts := make([]models.UploadAttempt, 0, len(events))
+	last := make(map[models.UploadResult]FraudEvent)
+	var results []models.UploadResult
+	for _, event := range events {
+		attempts = append(attempts, models.UploadAttempt{
+			UserID:      event.UserID,
+			IP:          event.IP,
+			OrderNumber: event.OrderNumber,
+			Result:      event.Result,
+		})
+		if _, ok := last[event.Result]; !ok {
+			results = append(results, event.Result)
+		}
+		last[event.Result] = event
+	}
+	if err := f.repo.RecordUploadAttempts(ctx, attempts); err != nil {
+		return err
+	}
+
+	open, err := f.repo.GetFraudSignals(ctx, events[0].UserID, true)
+	if err != nil {
+		return err
+	}
+	for _, result := range results {
+		event := last[result]
+		for _, rule := range f.rules {
+			if f.signalInForce(open, rule.Name(), event.At) {
+				continue
+			}
+			details, triggered, err := rule.Check(ctx, f.repo, event)
+			if err != nil {
+				return fmt.Errorf("fraud rule %s: %w", rule.Name(), err)
+			}
+			if !triggered {
+				continue
+			}
+			signal := models.FraudSignal{
+				UserID:  event.UserID,
+				IP:      event.IP,
+				Rule:    rule.Name(),
+				Action:  rule.Action(),
+				Details: details,
+			}
+			if err := f.repo.CreateFraudSignal(ctx, signal); err != nil {
+				return err
+			}
+			signal.CreatedAt = event.At
+			open = append(open, signal)
+		}
+	}
+	return nil
+}
+
+// signalInForce reports whether one of the unresolved signals of rule still
+// applies at. Once a throttle lapses the rule may throttle again.
+func (f *FraudEngine) signalInForce(signals []models.FraudSignal, rule string, at time.Time) bool {
+	for _, signal := range signals {
+		if signal.Rule != rule {
+			continue
+		}
+		switch signal.Action {
+		case models.FraudActionBlock:
+			return true
+		case models.FraudActionThrottle:
+			if signal.CreatedAt.After(at.Add(-f.throttleFor)) {
+				return true
+			}
+		default:
+			if signal.CreatedAt.After(at.Add(-f.window)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type conflictRule struct {
+	limit  int
+	action models.FraudAction
+	window time.Duration
+}
+
+func (r *conflictRule) Name() string               { return "conflict_uploads" }
+func (r *conflictRule) Action() models.FraudAction { return r.action }
+
+func (r *conflictRule) Check(ctx context.Context, repo interfaces.Repository, event FraudEvent) (string, bool, error) {
+	if event.Result != models.UploadResultConflict {
+		return "", false, nil
+	}
+	count, err := repo.CountUploadAttempts(ctx, event.UserID, models.UploadResultConflict, event.At.Add(-r.window))
+	if err != nil {
+		return "", false, err
+	}
+	if count < r.limit {
+		return "", false, nil
+	}
+	return fmt.Sprintf("%d uploads of orders owned by other users within %s", count, r.window), true, nil
+}
+
+type sequentialRule struct {
+	limit  int
+	action models.FraudAction
+	window time.Duration
+}
+
+// sequentialGap is the largest difference between two order numbers that
+// still counts as sequential. Consecutive Luhn-valid numbers are at most
+// 10 apart.
+const sequentialGap = 10
+
+func (r *sequentialRule) Name() string               { return "sequential_numbers" }
+func (r *sequentialRule) Action() models.FraudAction { return r.action }
+
+func (r *sequentialRule) Check(ctx context.Context, repo interfaces.Repository, event FraudEvent) (string, bool, error) {
+	numbers, err := repo.GetRecentUploadNumbers(ctx, event.UserID, event.At.Add(-r.window))
+	if err != nil {
+		return "", false, err
+	}
+	run := longestSequentialRun(numbers)
+	if run < r.limit {
+		return "", false, nil
+	}
+	return fmt.Sprintf("%d sequential order numbers within %s", run, r.window), true, nil
+}
+
+// longestSequentialRun sorts the numbers and returns the length of the
+// longest chain where neighbours are at most sequentialGap apart.
+func longestSequentialRun(numbers []string) int {
+	values := make([]*big.Int, 0, len(numbers))
+	seen := make(map[string]struct{}, len(numbers))
+	for _, number := range numbers {
+		if _, ok := seen[number]; ok {
+			continue
+		}
+		seen[number] = struct{}{}
+		if value, ok := new(big.Int).SetString(number, 10); ok {
+			values = append(values, value)
+		}
+	}
+	if len(values) == 0 {
+		return 0
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i].Cmp(values[j]) < 0 })
+
+	gap := big.NewInt(sequentialGap)
+	longest, run := 1, 1
+	diff := new(big.Int)
+	for i := 1; i < len(values); i++ {
+		if diff.Sub(values[i], values[i-1]).Cmp(gap) <= 0 {
+			run++
+		} else {
+			run = 1
+		}
+		if run > longest {
+			longest = run
+		}
+	}
+	return longest
+}
+
+type accountsPerIPRule struct {
+	limit  int
+	action models.FraudAction
+	window time.Duration
+}
+
+func (r *accountsPerIPRule) Name() string               { return "accounts_per_ip" }
+func (r *accountsPerIPRule) Action() models.FraudAction { return r.action }
+
+func (r *accountsPerIPRule) Check(ctx context.Context, repo interfaces.Repository, event FraudEvent) (string, bool, error) {
+	if event.IP == "" {
+		return "", false, nil
+	}
+	count, err := repo.CountUsersByIP(ctx, event.IP, event.At.Add(-r.window))
+	if err != nil {
+		return "", false, err
+	}
+	if count < r.limit {
+		return "", false, nil
+	}
+	return fmt.Sprintf("%d accounts uploaded orders from %s within %s", count, event.IP, r.window), true, nil
+}
@@ -0,0 +1,321 @@
+package service
+
+import (
+	"context"
+	"github.com/chestorix/gophermart/internal/interfaces"
+	"github.com/chestorix/gophermart/internal/models"
+	"testing"
+	"time"
+)
+
+type fraudAttempt struct {
+	userID int
+	ip     string
+	number string
+	result models.UploadResult
+	at     time.Time
+}
+
+// fraudRepo answers the upload history queries of the fraud rules from
+// memory, with the same inclusive window start as the SQL.
+type fraudRepo struct {
+	interfaces.Repository
+	attempts []fraudAttempt
+	signals  []models.FraudSignal
+	created  []models.FraudSignal
+}
+
+func (r *fraudRepo) RecordUploadAttempts(ctx context.Context, attempts []models.UploadAttempt) error {
+	for _, a := range attempts {
+		r.attempts = append(r.attempts, fraudAttempt{userID: a.UserID, ip: a.IP, number: a.OrderNumber, result: a.Result, at: time.Now()})
+	}
+	return nil
+}
+
+func (r *fraudRepo) GetFraudSignals(ctx context.Context, userID int, unresolvedOnly bool) ([]models.FraudSignal, error) {
+	return r.signals, nil
+}
+
+func (r *fraudRepo) CreateFraudSignal(ctx context.Context, signal models.FraudSignal) error {
+	r.created = append(r.created, signal)
+	return nil
+}
+
+func (r *fraudRepo) CountUploadAttempts(ctx context.Context, userID int, result models.UploadResult, since time.Time) (int, error) {
+	count := 0
+	for _, a := range r.attempts {
+		if a.userID == userID && a.result == result && !a.at.Before(since) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r *fraudRepo) GetRecentUploadNumbers(ctx context.Context, userID int, since time.Time) ([]string, error) {
+	var numbers []string
+	for _, a := range r.attempts {
+		if a.userID == userID && !a.at.Before(since) {
+			numbers = append(numbers, a.number)
+		}
+	}
+	return numbers, nil
+}
+
+func (r *fraudRepo) CountUsersByIP(ctx context.Context, ip string, since time.Time) (int, error) {
+	users := make(map[int]struct{})
+	for _, a := range r.attempts {
+		if a.ip == ip && !a.at.Before(since) {
+			users[a.userID] = struct{}{}
+		}
+	}
+	return len(users), nil
+}
+
+func TestFraudRules(t *testing.T) {
+	const window = time.Hour
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	edge := now.Add(-window)
+	outside := edge.Add(-time.Second)
+
+	conflicts := func(user int, times ...time.Time) []fraudAttempt {
+		attempts := make([]fraudAttempt, 0, len(times))
+		for _, at := range times {
+			attempts = append(attempts, fraudAttempt{userID: user, ip: "10.0.0.1", result: models.UploadResultConflict, at: at})
+		}
+		return attempts
+	}
+	numbers := func(user int, at time.Time, values ...string) []fraudAttempt {
+		attempts := make([]fraudAttempt, 0, len(values))
+		for _, number := range values {
+			attempts = append(attempts, fraudAttempt{userID: user, ip: "10.0.0.1", number: number, result: models.UploadResultAccepted, at: at})
+		}
+		return attempts
+	}
+	fromIP := func(ip string, at time.Time, users ...int) []fraudAttempt {
+		attempts := make([]fraudAttempt, 0, len(users))
+		for _, user := range users {
+			attempts = append(attempts, fraudAttempt{userID: user, ip: ip, result: models.UploadResultAccepted, at: at})
+		}
+		return attempts
+	}
+	join := func(groups ...[]fraudAttempt) []fraudAttempt {
+		var all []fraudAttempt
+		for _, group := range groups {
+			all = append(all, group...)
+		}
+		return all
+	}
+
+	conflict := &conflictRule{limit: 3, action: models.FraudActionBlock, window: window}
+	sequential := &sequentialRule{limit: 3, action: models.FraudActionThrottle, window: window}
+	perIP := &accountsPerIPRule{limit: 3, action: models.FraudActionFlag, window: window}
+
+	tests := []struct {
+		name      string
+		rule      FraudRule
+		attempts  []fraudAttempt
+		event     FraudEvent
+		triggered bool
+	}{
+		{
+			name:      "conflicts below limit",
+			rule:      conflict,
+			attempts:  conflicts(1, now, now),
+			event:     FraudEvent{UserID: 1, Result: models.UploadResultConflict, At: now},
+			triggered: false,
+		},
+		{
+			name:      "conflicts at limit",
+			rule:      conflict,
+			attempts:  conflicts(1, now, now, now),
+			event:     FraudEvent{UserID: 1, Result: models.UploadResultConflict, At: now},
+			triggered: true,
+		},
+		{
+			name:      "conflict at window start counts",
+			rule:      conflict,
+			attempts:  conflicts(1, edge, now, now),
+			event:     FraudEvent{UserID: 1, Result: models.UploadResultConflict, At: now},
+			triggered: true,
+		},
+		{
+			name:      "conflict before window ignored",
+			rule:      conflict,
+			attempts:  conflicts(1, outside, now, now),
+			event:     FraudEvent{UserID: 1, Result: models.UploadResultConflict, At: now},
+			triggered: false,
+		},
+		{
+			name:      "other users' conflicts ignored",
+			rule:      conflict,
+			attempts:  join(conflicts(1, now, now), conflicts(2, now)),
+			event:     FraudEvent{UserID: 1, Result: models.UploadResultConflict, At: now},
+			triggered: false,
+		},
+		{
+			name:      "conflict rule skips other results",
+			rule:      conflict,
+			attempts:  conflicts(1, now, now, now),
+			event:     FraudEvent{UserID: 1, Result: models.UploadResultAccepted, At: now},
+			triggered: false,
+		},
+		{
+			name:      "sequential run below limit",
+			rule:      sequential,
+			attempts:  numbers(1, now, "12345678903", "12345678911", "12345679000"),
+			event:     FraudEvent{UserID: 1, At: now},
+			triggered: false,
+		},
+		{
+			name:      "sequential run at limit",
+			rule:      sequential,
+			attempts:  numbers(1, now, "12345678903", "12345678911", "12345678920"),
+			event:     FraudEvent{UserID: 1, At: now},
+			triggered: true,
+		},
+		{
+			name:      "sequential number at window start counts",
+			rule:      sequential,
+			attempts:  join(numbers(1, edge, "12345678903"), numbers(1, now, "12345678911", "12345678920")),
+			event:     FraudEvent{UserID: 1, At: now},
+			triggered: true,
+		},
+		{
+			name:      "sequential number before window ignored",
+			rule:      sequential,
+			attempts:  join(numbers(1, outside, "12345678903"), numbers(1, now, "12345678911", "12345678920")),
+			event:     FraudEvent{UserID: 1, At: now},
+			triggered: false,
+		},
+		{
+			name:      "accounts per IP below limit",
+			rule:      perIP,
+			attempts:  fromIP("10.0.0.1", now, 1, 2, 2),
+			event:     FraudEvent{UserID: 1, IP: "10.0.0.1", At: now},
+			triggered: false,
+		},
+		{
+			name:      "accounts per IP at limit",
+			rule:      perIP,
+			attempts:  fromIP("10.0.0.1", now, 1, 2, 3),
+			event:     FraudEvent{UserID: 1, IP: "10.0.0.1", At: now},
+			triggered: true,
+		},
+		{
+			name:      "account at window start counts",
+			rule:      perIP,
+			attempts:  join(fromIP("10.0.0.1", edge, 3), fromIP("10.0.0.1", now, 1, 2)),
+			event:     FraudEvent{UserID: 1, IP: "10.0.0.1", At: now},
+			triggered: true,
+		},
+		{
+			name:      "account before window ignored",
+			rule:      perIP,
+			attempts:  join(fromIP("10.0.0.1", outside, 3), fromIP("10.0.0.1", now, 1, 2)),
+			event:     FraudEvent{UserID: 1, IP: "10.0.0.1", At: now},
+			triggered: false,
+		},
+		{
+			name:      "accounts from other IPs ignored",
+			rule:      perIP,
+			attempts:  join(fromIP("10.0.0.1", now, 1, 2), fromIP("10.0.0.2", now, 3)),
+			event:     FraudEvent{UserID: 1, IP: "10.0.0.1", At: now},
+			triggered: false,
+		},
+		{
+			name:      "unknown IP skipped",
+			rule:      perIP,
+			attempts:  fromIP("", now, 1, 2, 3),
+			event:     FraudEvent{UserID: 1, At: now},
+			triggered: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			details, triggered, err := tt.rule.Check(context.Background(), &fraudRepo{attempts: tt.attempts}, tt.event)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if triggered != tt.triggered {
+				t.Errorf("expected triggered %v, got %v (%s)", tt.triggered, triggered, details)
+			}
+			if triggered && details == "" {
+				t.Error("triggered without details")
+			}
+		})
+	}
+}
+
+func TestLongestSequentialRun(t *testing.T) {
+	tests := []struct {
+		name    string
+		numbers []string
+		want    int
+	}{
+		{name: "empty", numbers: nil, want: 0},
+		{name: "single", numbers: []string{"12345678903"}, want: 1},
+		{name: "gap of ten is sequential", numbers: []string{"100", "110", "120"}, want: 3},
+		{name: "gap of eleven breaks the run", numbers: []string{"100", "110", "121"}, want: 2},
+		{name: "unsorted input", numbers: []string{"120", "100", "110"}, want: 3},
+		{name: "duplicates count once", numbers: []string{"100", "100", "110", "110"}, want: 2},
+		{name: "longest of several runs", numbers: []string{"100", "105", "500", "505", "510", "515"}, want: 4},
+		{name: "non-numeric skipped", numbers: []string{"100", "abc", "110"}, want: 2},
+		{name: "beyond int64", numbers: []string{"123456789012345678901234", "123456789012345678901230"}, want: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := longestSequentialRun(tt.numbers); got != tt.want {
+				t.Errorf("expected %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestFraudEngine_SignalInForce(t *testing.T) {
+	const (
+		window      = time.Hour
+		throttleFor = 15 * time.Minute
+	)
+	now := time.Now()
+	signal := func(action models.FraudAction, age time.Duration) []models.FraudSignal {
+		return []models.FraudSignal{{UserID: 1, Rule: "conflict_uploads", Action: action, CreatedAt: now.Add(-age)}}
+	}
+
+	tests := []struct {
+		name     string
+		action   models.FraudAction
+		signals  []models.FraudSignal
+		reported bool
+	}{
+		{name: "no signal", action: models.FraudActionThrottle, reported: true},
+		{name: "throttle in force", action: models.FraudActionThrottle, signals: signal(models.FraudActionThrottle, 5*time.Minute), reported: false},
+		{name: "throttle lapsed inside the window", action: models.FraudActionThrottle, signals: signal(models.FraudActionThrottle, 20*time.Minute), reported: true},
+		{name: "flag inside the window", action: models.FraudActionFlag, signals: signal(models.FraudActionFlag, 50*time.Minute), reported: false},
+		{name: "flag outside the window", action: models.FraudActionFlag, signals: signal(models.FraudActionFlag, 2*time.Hour), reported: true},
+		{name: "unresolved block", action: models.FraudActionBlock, signals: signal(models.FraudActionBlock, 2*time.Hour), reported: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &fraudRepo{
+				attempts: []fraudAttempt{{userID: 1, result: models.UploadResultConflict, at: now}},
+				signals:  tt.signals,
+			}
+			engine := &FraudEngine{
+				repo:        repo,
+				rules:       []FraudRule{&conflictRule{limit: 2, action: tt.action, window: window}},
+				window:      window,
+				throttleFor: throttleFor,
+			}
+			event := FraudEvent{UserID: 1, OrderNumber: "12345678903", Result: models.UploadResultConflict, At: now}
+			if err := engine.Observe(context.Background(), event); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if reported := len(repo.created) > 0; reported != tt.reported {
+				t.Errorf("expected reported %v, got %v", tt.reported, reported)
+			}
+		})
+	}
+}
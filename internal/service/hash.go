@@ -0,0 +1,147 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/chestorix/gophermart/internal/config"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"math"
+	"runtime"
+	"strings"
+)
+
+const (
+	argon2idPrefix = "$argon2id$"
+	argon2SaltLen  = 16
+	argon2KeyLen   = 32
+)
+
+var (
+	errMismatchedPassword = errors.New("password does not match")
+	errUnknownHashFormat  = errors.New("unknown password hash format")
+)
+
+// passwordHasher hashes new passwords with argon2id and still verifies
+// bcrypt hashes made before the switch. The algorithm is recognized from
+// the hash prefix; hashes are stored in the PHC string format
+// $argon2id$v=19$m=<KiB>,t=<iterations>,p=<threads>$<salt>$<key>.
+//
+// Every argon2id computation holds its memory cost, so a burst of logins
+// or registrations could exhaust memory; slots bounds how many run at once.
+type passwordHasher struct {
+	params argon2Params
+	slots  chan struct{}
+}
+
+type argon2Params struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+}
+
+func newPasswordHasher(cfg config.PasswordHashConfig) (*passwordHasher, error) {
+	if cfg.Memory == 0 || cfg.Memory > math.MaxUint32 || cfg.Iterations == 0 || cfg.Iterations > math.MaxUint32 ||
+		cfg.Parallelism == 0 || cfg.Parallelism > math.MaxUint8 {
+		return nil, fmt.Errorf("invalid argon2id parameters m=%d t=%d p=%d", cfg.Memory, cfg.Iterations, cfg.Parallelism)
+	}
+	concurrency := cfg.Concurrency
+	if concurrency <= 0 {
+		concurrency = runtime.GOMAXPROCS(0)
+	}
+	return &passwordHasher{
+		params: argon2Params{
+			memory:      uint32(cfg.Memory),
+			iterations:  uint32(cfg.Iterations),
+			parallelism: uint8(cfg.Parallelism),
+		},
+		slots: make(chan struct{}, concurrency),
+	}, nil
+}
+
+// acquire waits for a free hashing slot or until ctx is done.
+func (h *passwordHasher) acquire(ctx context.Context) error {
+	select {
+	case h.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (h *passwordHasher) release() {
+	<-h.slots
+}
+
+func (h *passwordHasher) hash(ctx context.Context, password string) (string, error) {
+	if err := h.acquire(ctx); err != nil {
+		return "", err
+	}
+	defer h.release()
+
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.params.iterations, h.params.memory, h.params.parallelism, argon2KeyLen)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix, argon2.Version, h.params.memory, h.params.iterations, h.params.parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// compare checks password against hash. needsRehash reports that the
+// password matched but the hash was made with another algorithm or other
+// parameters and should be replaced.
+func (h *passwordHasher) compare(ctx context.Context, hash, password string) (needsRehash bool, err error) {
+	if err := h.acquire(ctx); err != nil {
+		return false, err
+	}
+	defer h.release()
+
+	if !strings.HasPrefix(hash, argon2idPrefix) {
+		if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+
+	params, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return false, err
+	}
+	other := argon2.IDKey([]byte(password), salt, params.iterations, params.memory, params.parallelism, uint32(len(key)))
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return false, errMismatchedPassword
+	}
+	return params != h.params || len(salt) != argon2SaltLen || len(key) != argon2KeyLen, nil
+}
+
+func decodeArgon2id(hash string) (argon2Params, []byte, []byte, error) {
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, key
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return argon2Params{}, nil, nil, errUnknownHashFormat
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return argon2Params{}, nil, nil, errUnknownHashFormat
+	}
+	var params argon2Params
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.iterations, &params.parallelism); err != nil {
+		return argon2Params{}, nil, nil, errUnknownHashFormat
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return argon2Params{}, nil, nil, errUnknownHashFormat
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return argon2Params{}, nil, nil, errUnknownHashFormat
+	}
+	return params, salt, key, nil
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/chestorix/gophermart/internal/config"
+	"golang.org/x/crypto/bcrypt"
+	"testing"
+	"time"
+)
+
+func TestPasswordHasher(t *testing.T) {
+	current, err := newPasswordHasher(config.PasswordHashConfig{Memory: 1024, Iterations: 1, Parallelism: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous, err := newPasswordHasher(config.PasswordHashConfig{Memory: 512, Iterations: 1, Parallelism: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	argonHash, err := current.hash(context.Background(), "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldParamsHash, err := previous.hash(context.Background(), "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		hash        string
+		password    string
+		wantErr     bool
+		needsRehash bool
+	}{
+		{name: "current argon2id", hash: argonHash, password: "secret"},
+		{name: "wrong password", hash: argonHash, password: "Secret", wantErr: true},
+		{name: "old parameters", hash: oldParamsHash, password: "secret", needsRehash: true},
+		{name: "bcrypt", hash: string(bcryptHash), password: "secret", needsRehash: true},
+		{name: "bcrypt wrong password", hash: string(bcryptHash), password: "other", wantErr: true},
+		{name: "malformed", hash: "$argon2id$v=19$m=1024$x$y", password: "secret", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			needsRehash, err := current.compare(context.Background(), tt.hash, tt.password)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if needsRehash != tt.needsRehash {
+				t.Errorf("expected needsRehash %v, got %v", tt.needsRehash, needsRehash)
+			}
+		})
+	}
+}
+
+func TestPasswordHasher_Concurrency(t *testing.T) {
+	hasher, err := newPasswordHasher(config.PasswordHashConfig{Memory: 1024, Iterations: 1, Parallelism: 1, Concurrency: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := hasher.acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := hasher.hash(ctx, "secret"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the hash to wait for the busy slot, got %v", err)
+	}
+
+	hasher.release()
+	if _, err := hasher.hash(context.Background(), "secret"); err != nil {
+		t.Errorf("unexpected error after release: %v", err)
+	}
+}
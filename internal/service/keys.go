@@ -0,0 +1,105 @@
+package service
+
+import (
+	"bufio"
+	"crypto/rand"
+	"fmt"
+	"github.com/chestorix/gophermart/internal/config"
+	"os"
+	"strings"
+)
+
+const defaultKeyID = "default"
+
+// keyRing holds the HMAC keys for access tokens. The current key signs,
+// all keys verify.
+type keyRing struct {
+	keys    map[string][]byte
+	current string
+}
+
+func (k *keyRing) add(id, secret string) error {
+	if id == "" || secret == "" {
+		return fmt.Errorf("jwt key %q: empty id or secret", id)
+	}
+	if _, ok := k.keys[id]; ok {
+		return fmt.Errorf("jwt key %q: duplicate id", id)
+	}
+	k.keys[id] = []byte(secret)
+	k.current = id
+	return nil
+}
+
+// newKeyRing combines the configured sources in the order secret, list,
+// file. Without any configured key it generates a random one, so tokens do
+// not survive a restart and are not shared between replicas.
+func newKeyRing(cfg config.JWTConfig) (*keyRing, bool, error) {
+	ring := &keyRing{keys: make(map[string][]byte)}
+
+	if cfg.Secret != "" {
+		if err := ring.add(defaultKeyID, cfg.Secret); err != nil {
+			return nil, false, err
+		}
+	}
+
+	for _, pair := range strings.Split(cfg.Keys, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		id, secret, _ := strings.Cut(pair, ":")
+		if err := ring.add(id, secret); err != nil {
+			return nil, false, err
+		}
+	}
+
+	if cfg.KeyFile != "" {
+		if err := ring.loadFile(cfg.KeyFile); err != nil {
+			return nil, false, err
+		}
+	}
+
+	if len(ring.keys) > 0 {
+		return ring, false, nil
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, false, err
+	}
+	ring.keys[defaultKeyID] = secret
+	ring.current = defaultKeyID
+	return ring, true, nil
+}
+
+func (k *keyRing) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open jwt key file: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return fmt.Errorf("jwt key file %s: expected \"kid secret\", got %d fields", path, len(fields))
+		}
+		if err := k.add(fields[0], fields[1]); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func (k *keyRing) signingKey() (string, []byte) {
+	return k.current, k.keys[k.current]
+}
+
+func (k *keyRing) verificationKey(id string) ([]byte, bool) {
+	key, ok := k.keys[id]
+	return key, ok
+}
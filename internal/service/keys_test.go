@@ -0,0 +1,70 @@
+package service
+
+import (
+	"github.com/chestorix/gophermart/internal/config"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewKeyRing(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(keyFile, []byte("# rotated 2026-10\n2026-10 file-secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	ring, generated, err := newKeyRing(config.JWTConfig{
+		Secret:  "env-secret",
+		Keys:    "2026-09:list-secret",
+		KeyFile: keyFile,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if generated {
+		t.Error("expected configured keys, got a generated one")
+	}
+
+	if kid, key := ring.signingKey(); kid != "2026-10" || string(key) != "file-secret" {
+		t.Errorf("expected the last key to sign, got %q", kid)
+	}
+	for _, kid := range []string{defaultKeyID, "2026-09", "2026-10"} {
+		if _, ok := ring.verificationKey(kid); !ok {
+			t.Errorf("key %q is not accepted for verification", kid)
+		}
+	}
+	if _, ok := ring.verificationKey("unknown"); ok {
+		t.Error("unknown key accepted for verification")
+	}
+}
+
+func TestNewKeyRing_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.JWTConfig
+	}{
+		{name: "duplicate id", cfg: config.JWTConfig{Secret: "a", Keys: "default:b"}},
+		{name: "missing secret", cfg: config.JWTConfig{Keys: "k1:"}},
+		{name: "missing file", cfg: config.JWTConfig{KeyFile: "/nonexistent/keys"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := newKeyRing(tt.cfg); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestNewKeyRing_Generated(t *testing.T) {
+	ring, generated, err := newKeyRing(config.JWTConfig{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !generated {
+		t.Error("expected a generated key")
+	}
+	if _, key := ring.signingKey(); len(key) != 32 {
+		t.Errorf("expected a 32 byte key, got %d bytes", len(key))
+	}
+}
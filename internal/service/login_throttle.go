@@ -0,0 +1,126 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/chestorix/gophermart/internal/config"
+	e "github.com/chestorix/gophermart/internal/errors"
+	"github.com/chestorix/gophermart/internal/tenant"
+	"time"
+)
+
+// loginThrottle keeps password guessing slow: after a few free failures
+// every further failure doubles the wait before the next attempt, and
+// reaching the limit locks the login (or IP) out. State lives in Postgres
+// so that all replicas enforce the same limits.
+type loginThrottle struct {
+	cfg config.LoginThrottleConfig
+}
+
+type throttleKey struct {
+	key   string
+	free  int
+	limit int
+	// delays is the block after each failure, see ReserveLoginAttempt.
+	delays []time.Duration
+}
+
+func (t *loginThrottle) newKey(key string, free, limit int) throttleKey {
+	k := throttleKey{key: key, free: free, limit: limit}
+	k.delays = t.schedule(k)
+	return k
+}
+
+func (t *loginThrottle) keys(ctx context.Context, login, ip string) []throttleKey {
+	keys := []throttleKey{t.newKey(loginThrottleKey(ctx, login), t.cfg.FreeAttempts, t.cfg.MaxFailures)}
+	if ip != "" {
+		keys = append(keys, t.newKey("ip:"+ip, t.cfg.IPMaxFailures, t.cfg.IPMaxFailures))
+	}
+	return keys
+}
+
+func loginThrottleKey(ctx context.Context, login string) string {
+	return fmt.Sprintf("login:%d:%s", tenant.MerchantID(ctx), login)
+}
+
+// delay returns how long the key is blocked after failures failures.
+func (t *loginThrottle) delay(failures int, key throttleKey) time.Duration {
+	if key.limit > 0 && failures >= key.limit {
+		return t.cfg.Lockout
+	}
+	if failures <= key.free || t.cfg.BaseDelay <= 0 {
+		return 0
+	}
+	delay := t.cfg.BaseDelay
+	for i := key.free + 1; i < failures && delay < t.cfg.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > t.cfg.MaxDelay {
+		delay = t.cfg.MaxDelay
+	}
+	return delay
+}
+
+// schedule lists the delays up to the point where they stop changing: the
+// lockout, or the longest progressive delay when there is no lockout.
+func (t *loginThrottle) schedule(key throttleKey) []time.Duration {
+	var delays []time.Duration
+	for failures := 1; ; failures++ {
+		delay := t.delay(failures, key)
+		delays = append(delays, delay)
+		if key.limit > 0 {
+			if failures >= key.limit {
+				return delays
+			}
+			continue
+		}
+		if failures > key.free && (t.cfg.BaseDelay <= 0 || delay >= t.cfg.MaxDelay) {
+			return delays
+		}
+	}
+}
+
+// reserveLoginAttempt counts an attempt against every key before the
+// credentials are checked, and returns a RetryAfterError while any key is
+// blocked. Attempts whose credentials are correct are given back with
+// releaseLoginAttempt.
+func (s *Service) reserveLoginAttempt(ctx context.Context, keys []throttleKey) error {
+	for i, k := range keys {
+		t, err := s.repo.ReserveLoginAttempt(ctx, k.key, s.loginThrottle.cfg.Window, k.delays)
+		if err != nil {
+			s.releaseLoginAttempt(ctx, keys[:i])
+			return err
+		}
+		if t.BlockedUntil != nil {
+			s.releaseLoginAttempt(ctx, keys[:i])
+			return &e.RetryAfterError{Err: e.ErrTooManyLoginAttempts, RetryAfter: time.Until(*t.BlockedUntil)}
+		}
+		if k.limit > 0 && t.Failures == k.limit {
+			s.logger.Warnf("login locked for %s after %d attempts", k.key, t.Failures)
+		}
+	}
+	return nil
+}
+
+func (s *Service) releaseLoginAttempt(ctx context.Context, keys []throttleKey) {
+	for _, k := range keys {
+		if err := s.repo.ReleaseLoginAttempt(ctx, k.key, k.delays); err != nil {
+			s.logger.Errorf("release login attempt failed: %v", err)
+		}
+	}
+}
+
+// UnlockLogin clears the failure counters of a login and/or an IP.
+func (s *Service) UnlockLogin(ctx context.Context, login, ip string) error {
+	if login != "" {
+		if err := s.repo.ClearLoginFailures(ctx, loginThrottleKey(ctx, s.credentials.NormalizeLogin(login))); err != nil {
+			return err
+		}
+	}
+	if ip != "" {
+		if err := s.repo.ClearLoginFailures(ctx, "ip:"+ip); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package service
+
+import (
+	"github.com/chestorix/gophermart/internal/config"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLoginThrottleSchedule(t *testing.T) {
+	throttle := &loginThrottle{cfg: config.LoginThrottleConfig{
+		FreeAttempts: 2,
+		BaseDelay:    time.Second,
+		MaxDelay:     4 * time.Second,
+		Lockout:      time.Hour,
+	}}
+
+	tests := []struct {
+		name  string
+		free  int
+		limit int
+		want  []time.Duration
+	}{
+		{
+			name:  "progressive delays up to the lockout",
+			free:  2,
+			limit: 7,
+			want:  []time.Duration{0, 0, time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second, time.Hour},
+		},
+		{
+			name: "no lockout ends at the longest delay",
+			free: 2,
+			want: []time.Duration{0, 0, time.Second, 2 * time.Second, 4 * time.Second},
+		},
+		{
+			name:  "free attempts up to the lockout",
+			free:  3,
+			limit: 3,
+			want:  []time.Duration{0, 0, time.Hour},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := throttle.newKey("login:1:user", tt.free, tt.limit)
+			if !reflect.DeepEqual(key.delays, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, key.delays)
+			}
+		})
+	}
+}
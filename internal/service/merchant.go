@@ -0,0 +1,99 @@
+package service
+
+import (
+	"context"
+	e "github.com/chestorix/gophermart/internal/errors"
+	"github.com/chestorix/gophermart/internal/models"
+	"github.com/chestorix/gophermart/internal/tenant"
+	"strings"
+	"sync"
+	"time"
+)
+
+// merchantCacheTTL bounds how long a merchant added to the table stays
+// invisible to a running replica.
+const merchantCacheTTL = time.Minute
+
+type merchantCache struct {
+	mu        sync.RWMutex
+	merchants []models.Merchant
+	loadedAt  time.Time
+}
+
+func (s *Service) loadMerchants(ctx context.Context) ([]models.Merchant, error) {
+	s.merchants.mu.RLock()
+	if time.Since(s.merchants.loadedAt) < merchantCacheTTL {
+		merchants := s.merchants.merchants
+		s.merchants.mu.RUnlock()
+		return merchants, nil
+	}
+	s.merchants.mu.RUnlock()
+
+	merchants, err := s.repo.GetMerchants(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s.merchants.mu.Lock()
+	s.merchants.merchants = merchants
+	s.merchants.loadedAt = time.Now()
+	s.merchants.mu.Unlock()
+	return merchants, nil
+}
+
+// ResolveMerchant picks the merchant by the X-Merchant code when one is
+// given, otherwise by host name, and falls back to the default merchant.
+func (s *Service) ResolveMerchant(ctx context.Context, code, host string) (models.Merchant, error) {
+	merchants, err := s.loadMerchants(ctx)
+	if err != nil {
+		return models.Merchant{}, err
+	}
+
+	if code != "" {
+		for _, m := range merchants {
+			if m.Code == code {
+				return m, nil
+			}
+		}
+		return models.Merchant{}, e.ErrUnknownMerchant
+	}
+
+	host = strings.ToLower(host)
+	for _, m := range merchants {
+		if m.Host != "" && strings.ToLower(m.Host) == host {
+			return m, nil
+		}
+	}
+	for _, m := range merchants {
+		if m.ID == tenant.DefaultMerchantID {
+			return m, nil
+		}
+	}
+	return models.Merchant{}, e.ErrUnknownMerchant
+}
+
+// currentMerchant returns the merchant stored in ctx.
+func (s *Service) currentMerchant(ctx context.Context) (models.Merchant, error) {
+	merchants, err := s.loadMerchants(ctx)
+	if err != nil {
+		return models.Merchant{}, err
+	}
+	id := tenant.MerchantID(ctx)
+	for _, m := range merchants {
+		if m.ID == id {
+			return m, nil
+		}
+	}
+	return models.Merchant{}, e.ErrUnknownMerchant
+}
+
+func (s *Service) accrualAddress(ctx context.Context) (string, error) {
+	merchant, err := s.currentMerchant(ctx)
+	if err != nil {
+		return "", err
+	}
+	if merchant.AccrualAddr != "" {
+		return merchant.AccrualAddr, nil
+	}
+	return s.accSysAddr, nil
+}
@@ -0,0 +1,214 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	e "github.com/chestorix/gophermart/internal/errors"
+	"github.com/chestorix/gophermart/internal/models"
+	"github.com/chestorix/gophermart/internal/tenant"
+	"strings"
+	"time"
+)
+
+const recoveryCodeCount = 10
+
+// EnrollTOTP creates a new pending TOTP secret. It takes effect once
+// ConfirmTOTP has seen a code generated from it.
+func (s *Service) EnrollTOTP(ctx context.Context, userID int) (models.TOTPEnrollment, error) {
+	user, err := s.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return models.TOTPEnrollment{}, err
+	}
+	merchant, err := s.currentMerchant(ctx)
+	if err != nil {
+		return models.TOTPEnrollment{}, err
+	}
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return models.TOTPEnrollment{}, err
+	}
+	if err := s.repo.SetTOTPSecret(ctx, userID, secret); err != nil {
+		return models.TOTPEnrollment{}, err
+	}
+	return models.TOTPEnrollment{
+		Secret: secret,
+		URI:    totpURI(merchant.Name, user.Login, secret),
+	}, nil
+}
+
+// ConfirmTOTP enables 2FA after checking a first code and returns the
+// recovery codes, which are only stored hashed.
+func (s *Service) ConfirmTOTP(ctx context.Context, userID int, code string) ([]string, error) {
+	totp, err := s.repo.GetUserTOTP(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if totp.EnabledAt != nil {
+		return nil, e.ErrTOTPAlreadyEnabled
+	}
+	err = s.checkTOTP(ctx, totp, code)
+	if err == e.ErrInvalidMFACode {
+		// The code only proves the authenticator app was set up correctly.
+		return nil, e.ErrInvalidTOTPSetupCode
+	}
+	if err != nil {
+		return nil, err
+	}
+	codes, err := s.newRecoveryCodes(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.EnableTOTP(ctx, userID); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTOTP turns 2FA off; it takes a current TOTP or recovery code.
+func (s *Service) DisableTOTP(ctx context.Context, userID int, code string) error {
+	if err := s.verifyMFACode(ctx, userID, code); err != nil {
+		return err
+	}
+	return s.repo.DisableTOTP(ctx, userID)
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes, used or not.
+func (s *Service) RegenerateRecoveryCodes(ctx context.Context, userID int, code string) ([]string, error) {
+	if err := s.verifyMFACode(ctx, userID, code); err != nil {
+		return nil, err
+	}
+	return s.newRecoveryCodes(ctx, userID)
+}
+
+// CompleteMFALogin is the second login step: it exchanges the challenge
+// token returned by Login and a TOTP or recovery code for tokens.
+func (s *Service) CompleteMFALogin(ctx context.Context, mfaToken, code, ip string) (models.TokenPair, error) {
+	tokenHash := hashToken(mfaToken)
+	challenge, err := s.repo.ReserveMFAChallengeAttempt(ctx, tokenHash, s.mfaChallengeAttempts)
+	if err != nil {
+		return models.TokenPair{}, err
+	}
+	user, err := s.repo.GetUserByID(ctx, challenge.UserID)
+	if err != nil {
+		return models.TokenPair{}, err
+	}
+
+	err = s.verifyMFACode(ctx, user.ID, code)
+	if err == e.ErrInvalidMFACode {
+		// A wrong code fails the login rather than a protected action.
+		return models.TokenPair{}, e.ErrInvalidMFALoginCode
+	}
+	if err != nil {
+		return models.TokenPair{}, err
+	}
+	if err := s.repo.DeleteMFAChallenge(ctx, tokenHash); err != nil {
+		return models.TokenPair{}, err
+	}
+
+	keys := s.loginThrottle.keys(ctx, s.credentials.NormalizeLogin(user.Login), ip)
+	if err := s.repo.ClearLoginFailures(ctx, keys[0].key); err != nil {
+		s.logger.Errorf("clear login failures failed: %v", err)
+	}
+	return s.issueTokens(ctx, user, "")
+}
+
+// startMFAChallenge ends the first login step of a user with 2FA enabled.
+func (s *Service) startMFAChallenge(ctx context.Context, user models.User) error {
+	token, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.CreateMFAChallenge(ctx, user.ID, hashToken(token), time.Now().Add(s.mfaChallengeTTL)); err != nil {
+		return err
+	}
+	return &e.MFAChallengeError{Token: token, ExpiresIn: s.mfaChallengeTTL}
+}
+
+// mfaEnabled reports whether the user has confirmed a TOTP enrollment.
+func (s *Service) mfaEnabled(ctx context.Context, userID int) (bool, error) {
+	totp, err := s.repo.GetUserTOTP(ctx, userID)
+	if err == e.ErrTOTPNotEnrolled {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return totp.EnabledAt != nil, nil
+}
+
+// verifyMFACode accepts a TOTP code or an unused recovery code of a user
+// with 2FA enabled. Wrong codes count against a per-user throttle so that
+// the 6-digit space cannot be searched through any of the endpoints that
+// take a code.
+func (s *Service) verifyMFACode(ctx context.Context, userID int, code string) error {
+	totp, err := s.repo.GetUserTOTP(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if totp.EnabledAt == nil {
+		return e.ErrTOTPNotEnrolled
+	}
+	if code == "" {
+		return e.ErrMFARequired
+	}
+
+	keys := []throttleKey{s.loginThrottle.newKey(
+		fmt.Sprintf("mfa:%d:%d", tenant.MerchantID(ctx), userID),
+		s.loginThrottle.cfg.FreeAttempts,
+		s.loginThrottle.cfg.MaxFailures,
+	)}
+	if err := s.reserveLoginAttempt(ctx, keys); err != nil {
+		return err
+	}
+
+	if len(code) == totpDigits {
+		err = s.checkTOTP(ctx, totp, code)
+	} else {
+		err = s.repo.UseRecoveryCode(ctx, userID, hashToken(normalizeRecoveryCode(code)))
+	}
+	if err != e.ErrInvalidMFACode {
+		s.releaseLoginAttempt(ctx, keys)
+	}
+	return err
+}
+
+// checkTOTP verifies a TOTP code and marks its time step as used.
+func (s *Service) checkTOTP(ctx context.Context, totp models.UserTOTP, code string) error {
+	step, ok := matchTOTP(totp.Secret, code, time.Now())
+	if !ok {
+		return e.ErrInvalidMFACode
+	}
+	fresh, err := s.repo.UseTOTPStep(ctx, totp.UserID, step)
+	if err != nil {
+		return err
+	}
+	if !fresh {
+		return e.ErrInvalidMFACode
+	}
+	return nil
+}
+
+func (s *Service) newRecoveryCodes(ctx context.Context, userID int) ([]string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		secret, err := generateTOTPSecret()
+		if err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(secret[:5] + "-" + secret[5:10])
+		codes = append(codes, code)
+		hashes = append(hashes, hashToken(normalizeRecoveryCode(code)))
+	}
+	if err := s.repo.ReplaceRecoveryCodes(ctx, userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// normalizeRecoveryCode lets users type recovery codes in any case and
+// with or without the dash.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
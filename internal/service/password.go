@@ -0,0 +1,107 @@
+package service
+
+import (
+	"context"
+	"github.com/chestorix/gophermart/internal/config"
+	e "github.com/chestorix/gophermart/internal/errors"
+	"github.com/chestorix/gophermart/internal/models"
+	"github.com/chestorix/gophermart/internal/tenant"
+	"github.com/chestorix/gophermart/internal/validation"
+	"regexp"
+	"time"
+)
+
+// ChangePassword replaces the password after checking the current one. All
+// other sessions are signed out; the caller's session continues with the
+// returned tokens, since the old access token carries a stale version.
+func (s *Service) ChangePassword(ctx context.Context, claims models.TokenClaims, currentPassword, newPassword string) (models.TokenPair, error) {
+	user, err := s.repo.GetUserByID(ctx, claims.UserID)
+	if err != nil {
+		return models.TokenPair{}, err
+	}
+	if err := s.comparePassword(ctx, user.PasswordHash, currentPassword); err != nil {
+		return models.TokenPair{}, e.ErrWrongPassword
+	}
+	if violations := s.credentials.CheckPassword("new_password", newPassword, user.Login); len(violations) > 0 {
+		return models.TokenPair{}, &e.PolicyError{Violations: violations}
+	}
+
+	if err := s.setPassword(ctx, user.ID, newPassword, claims.FamilyID); err != nil {
+		return models.TokenPair{}, err
+	}
+	if err := s.repo.RevokeToken(ctx, claims.ID, claims.ExpiresAt); err != nil {
+		return models.TokenPair{}, err
+	}
+
+	user, err = s.repo.GetUserByID(ctx, claims.UserID)
+	if err != nil {
+		return models.TokenPair{}, err
+	}
+	return s.issueTokens(ctx, user, claims.FamilyID)
+}
+
+// RequestPasswordReset sends a single-use reset token through the notifier.
+// Unknown logins are ignored so the endpoint does not reveal which exist.
+func (s *Service) RequestPasswordReset(ctx context.Context, login string) error {
+	user, err := s.findUserByLogin(ctx, login)
+	if err == e.ErrUserNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	token, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+	expiresAt := time.Now().Add(s.passwordResetTTL)
+	if err := s.repo.CreatePasswordResetToken(ctx, user.ID, hashToken(token), expiresAt); err != nil {
+		return err
+	}
+	return s.notifier.NotifyPasswordReset(ctx, user, token, expiresAt)
+}
+
+// ResetPassword consumes a reset token and signs the user out everywhere.
+func (s *Service) ResetPassword(ctx context.Context, token, newPassword string) error {
+	if violations := s.credentials.CheckPassword("new_password", newPassword, ""); len(violations) > 0 {
+		return &e.PolicyError{Violations: violations}
+	}
+	userID, err := s.repo.UsePasswordResetToken(ctx, hashToken(token))
+	if err != nil {
+		return err
+	}
+	return s.setPassword(ctx, userID, newPassword, "")
+}
+
+// setPassword stores a new hash, bumps the token version and revokes the
+// refresh tokens of every session except keepFamilyID.
+func (s *Service) setPassword(ctx context.Context, userID int, password, keepFamilyID string) error {
+	hash, err := s.hashPassword(ctx, password)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.UpdatePassword(ctx, userID, hash); err != nil {
+		return err
+	}
+	s.userCache.invalidate(userCacheKey{merchantID: tenant.MerchantID(ctx), userID: userID})
+	return s.repo.RevokeUserRefreshTokens(ctx, userID, keepFamilyID)
+}
+
+func newCredentialPolicy(cfg config.CredentialPolicyConfig) (*validation.CredentialPolicy, error) {
+	policy := &validation.CredentialPolicy{
+		LoginMinLength:    cfg.LoginMinLength,
+		LoginMaxLength:    cfg.LoginMaxLength,
+		PasswordMinLength: cfg.PasswordMinLength,
+		PasswordMaxLength: cfg.PasswordMaxLength,
+		RejectCommon:      cfg.RejectCommonPasswords,
+	}
+	if cfg.LoginPattern != "" {
+		pattern, err := regexp.Compile(cfg.LoginPattern)
+		if err != nil {
+			return nil, err
+		}
+		policy.LoginPattern = pattern
+	}
+	return policy, nil
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"context"
+	e "github.com/chestorix/gophermart/internal/errors"
+	"github.com/chestorix/gophermart/internal/models"
+	"github.com/chestorix/gophermart/internal/tenant"
+	"time"
+)
+
+// exportAuditLimit caps the audit entries in a data export: requests the
+// user made as staff and staff requests that targeted the user.
+const exportAuditLimit = 10000
+
+// ExportUserData collects everything stored about the user for the
+// personal data export.
+func (s *Service) ExportUserData(ctx context.Context, userID int) (models.UserExport, error) {
+	export := models.UserExport{ExportedAt: time.Now()}
+
+	var err error
+	if export.User, err = s.repo.GetUserByID(ctx, userID); err != nil {
+		return models.UserExport{}, err
+	}
+	if export.TOTPEnabled, err = s.mfaEnabled(ctx, userID); err != nil {
+		return models.UserExport{}, err
+	}
+	if export.Orders, err = s.repo.GetOrdersByUserID(ctx, userID, models.PageQuery{}); err != nil {
+		return models.UserExport{}, err
+	}
+	if export.Withdrawals, err = s.repo.GetWithdrawalsByUserID(ctx, userID, models.PageQuery{}); err != nil {
+		return models.UserExport{}, err
+	}
+	if export.Vouchers, err = s.repo.GetVouchersByRedeemer(ctx, userID); err != nil {
+		return models.UserExport{}, err
+	}
+	if export.Adjustments, err = s.repo.GetAdjustments(ctx, userID, models.AdjustmentStatusApplied); err != nil {
+		return models.UserExport{}, err
+	}
+	if export.AuditLog, err = s.repo.GetUserAuditLog(ctx, userID, exportAuditLimit); err != nil {
+		return models.UserExport{}, err
+	}
+	return export, nil
+}
+
+// CloseAccount closes the user's own account after checking the password
+// and, with 2FA enabled, a code. The remaining balance is forfeited or held
+// for payout as configured and the account is pseudonymized, atomically.
+func (s *Service) CloseAccount(ctx context.Context, userID int, password, mfaCode string) error {
+	user, err := s.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if err := s.comparePassword(ctx, user.PasswordHash, password); err != nil {
+		return e.ErrWrongPassword
+	}
+	enabled, err := s.mfaEnabled(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if enabled {
+		if err := s.verifyMFACode(ctx, userID, mfaCode); err != nil {
+			return err
+		}
+	}
+
+	pseudonym, err := randomToken(12)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.CloseUser(ctx, userID, "deleted:"+pseudonym, s.closureBalance); err != nil {
+		return err
+	}
+	s.userCache.invalidate(userCacheKey{merchantID: tenant.MerchantID(ctx), userID: userID})
+	if err := s.repo.ClearLoginFailures(ctx, loginThrottleKey(ctx, s.credentials.NormalizeLogin(user.Login))); err != nil {
+		s.logger.Errorf("clear login failures of closed account failed: %v", err)
+	}
+	s.logger.Infof("user %d closed the account", userID)
+	return nil
+}
@@ -3,13 +3,15 @@ package service
 import (
 	"context"
 	"fmt"
+	"github.com/chestorix/gophermart/internal/config"
 	e "github.com/chestorix/gophermart/internal/errors"
 	"github.com/chestorix/gophermart/internal/interfaces"
 	"github.com/chestorix/gophermart/internal/models"
+	"github.com/chestorix/gophermart/internal/notify"
+	"github.com/chestorix/gophermart/internal/tenant"
+	"github.com/chestorix/gophermart/internal/validation"
 	"github.com/go-resty/resty/v2"
-	"github.com/golang-jwt/jwt/v4"
 	"github.com/sirupsen/logrus"
-	"golang.org/x/crypto/bcrypt"
 	"net/http"
 	"time"
 )
@@ -28,8 +30,33 @@ type Service struct {
 	httpClient *resty.Client
 	repo       interfaces.Repository
 	logger     *logrus.Logger
-	jwtSecret  string
+	jwtKeys    *keyRing
 	accSysAddr string
+
+	accessTokenTTL   time.Duration
+	refreshTokenTTL  time.Duration
+	passwordResetTTL time.Duration
+	notifier         interfaces.Notifier
+
+	withdrawalReviewThreshold   float64
+	adjustmentApprovalThreshold float64
+	closureBalance              models.ClosureBalance
+	orderBatchLimit             int
+	adminLogins                 map[adminLogin]struct{}
+	fraud                       *FraudEngine
+	orderValidator              validation.OrderNumberValidator
+	merchants                   merchantCache
+	userCache                   *userCache
+	sessions                    *sessionTracker
+	events                      *eventHub
+	webSocketConnsPerUser       int
+	loginThrottle               *loginThrottle
+	passwords                   *passwordHasher
+	credentials                 *validation.CredentialPolicy
+
+	mfaChallengeTTL        time.Duration
+	mfaChallengeAttempts   int
+	mfaWithdrawalThreshold float64
 }
 
 type AccrualResponse struct {
@@ -38,26 +65,90 @@ type AccrualResponse struct {
 	Accrual float64              `json:"accrual,omitempty"`
 }
 
-func NewService(repo interfaces.Repository, logger *logrus.Logger, jwtSecret string, AccSysAddr string) *Service {
+func NewService(repo interfaces.Repository, logger *logrus.Logger, cfg *config.ServerConfig) (*Service, error) {
+	orderValidator, err := validation.Parse(cfg.OrderValidators)
+	if err != nil {
+		return nil, err
+	}
+	jwtKeys, generated, err := newKeyRing(cfg.JWT)
+	if err != nil {
+		return nil, err
+	}
+	if generated {
+		logger.Warn("no JWT signing key configured, using a random key: tokens will not survive a restart")
+	}
+	notifier, err := notify.New(cfg.Notifier, logger)
+	if err != nil {
+		return nil, err
+	}
+	passwords, err := newPasswordHasher(cfg.PasswordHash)
+	if err != nil {
+		return nil, err
+	}
+	credentials, err := newCredentialPolicy(cfg.CredentialPolicy)
+	if err != nil {
+		return nil, err
+	}
+	closureBalance := models.ClosureBalance(cfg.ClosureBalance)
+	if !closureBalance.Valid() {
+		return nil, e.ErrInvalidClosureBalance
+	}
+	adminLogins := make(map[adminLogin]struct{}, len(cfg.AdminLogins))
+	for _, entry := range cfg.AdminLogins {
+		adminLogins[parseAdminLogin(entry, credentials)] = struct{}{}
+	}
 	return &Service{
 		httpClient: resty.New(),
 		repo:       repo,
 		logger:     logger,
-		jwtSecret:  jwtSecret,
-		accSysAddr: AccSysAddr,
-	}
+		jwtKeys:    jwtKeys,
+		accSysAddr: cfg.AccSysAddr,
+
+		accessTokenTTL:   cfg.JWT.AccessTokenTTL,
+		refreshTokenTTL:  cfg.JWT.RefreshTokenTTL,
+		passwordResetTTL: cfg.PasswordResetTTL,
+		notifier:         notifier,
+
+		withdrawalReviewThreshold:   cfg.WithdrawalReviewThreshold,
+		adjustmentApprovalThreshold: cfg.AdjustmentApprovalThreshold,
+		closureBalance:              closureBalance,
+		orderBatchLimit:             cfg.OrderBatchLimit,
+		adminLogins:                 adminLogins,
+		fraud:                       NewFraudEngine(repo, cfg.Fraud),
+		orderValidator:              orderValidator,
+		userCache:                   newUserCache(),
+		sessions:                    newSessionTracker(),
+		events:                      newEventHub(),
+		webSocketConnsPerUser:       cfg.WebSocketConnsPerUser,
+		loginThrottle:               &loginThrottle{cfg: cfg.LoginThrottle},
+		passwords:                   passwords,
+		credentials:                 credentials,
+
+		mfaChallengeTTL:        cfg.MFA.ChallengeTTL,
+		mfaChallengeAttempts:   cfg.MFA.ChallengeAttempts,
+		mfaWithdrawalThreshold: cfg.MFA.WithdrawalThreshold,
+	}, nil
 }
 
-func (s *Service) Register(ctx context.Context, login, password string) (string, error) {
+func (s *Service) Register(ctx context.Context, login, password string) (models.TokenPair, error) {
+	login = s.credentials.NormalizeLogin(login)
+	violations := s.credentials.CheckLogin(login)
+	violations = append(violations, s.credentials.CheckPassword("password", password, login)...)
+	if len(violations) > 0 {
+		return models.TokenPair{}, &e.PolicyError{Violations: violations}
+	}
 
-	_, err := s.repo.GetUserByLogin(ctx, login)
-	if err == nil {
-		return "", e.ErrUserAlreadyExists
+	taken, err := s.repo.LoginTaken(ctx, login)
+	if err != nil {
+		return models.TokenPair{}, err
+	}
+	if taken {
+		return models.TokenPair{}, e.ErrUserAlreadyExists
 	}
 
-	hashedPassword, err := s.hashPassword(password)
+	hashedPassword, err := s.hashPassword(ctx, password)
 	if err != nil {
-		return "", err
+		return models.TokenPair{}, err
 	}
 
 	user := models.User{
@@ -65,28 +156,54 @@ func (s *Service) Register(ctx context.Context, login, password string) (string,
 		PasswordHash: hashedPassword,
 	}
 
-	if err := s.repo.CreateUser(ctx, user); err != nil {
-		return "", err
+	user.ID, err = s.repo.CreateUser(ctx, user)
+	if err != nil {
+		return models.TokenPair{}, err
 	}
 
-	token, err := s.generateToken(login)
+	return s.issueTokens(ctx, user, "")
+}
+
+func (s *Service) Login(ctx context.Context, login, password, ip string) (models.TokenPair, error) {
+	keys := s.loginThrottle.keys(ctx, s.credentials.NormalizeLogin(login), ip)
+	if err := s.reserveLoginAttempt(ctx, keys); err != nil {
+		return models.TokenPair{}, err
+	}
+
+	var needsRehash bool
+	user, err := s.findUserByLogin(ctx, login)
+	if err == nil {
+		needsRehash, err = s.passwords.compare(ctx, user.PasswordHash, password)
+	}
 	if err != nil {
-		return "", err
+		// The reserved attempt stays counted as a failure.
+		return models.TokenPair{}, e.ErrInvalidCredentials
+	}
+	s.releaseLoginAttempt(ctx, keys)
+	// Checked after the password so that guessing does not reveal which
+	// accounts are disabled.
+	if user.DisabledAt != nil {
+		return models.TokenPair{}, e.ErrUserDisabled
 	}
 
-	return token, nil
-}
+	if needsRehash {
+		s.rehashPassword(ctx, user, password)
+	}
 
-func (s *Service) Login(ctx context.Context, login, password string) (string, error) {
-	user, err := s.repo.GetUserByLogin(ctx, login)
+	// With 2FA the failure counter is only cleared by CompleteMFALogin,
+	// so that a known password does not reset the code throttle.
+	mfa, err := s.mfaEnabled(ctx, user.ID)
 	if err != nil {
-		return "", e.ErrInvalidCredentials
+		return models.TokenPair{}, err
 	}
-	if err := s.comparePassword(user.PasswordHash, password); err != nil {
-		return "", e.ErrInvalidCredentials
+	if mfa {
+		return models.TokenPair{}, s.startMFAChallenge(ctx, user)
 	}
 
-	return s.generateToken(login)
+	if err := s.repo.ClearLoginFailures(ctx, keys[0].key); err != nil {
+		s.logger.Errorf("clear login failures failed: %v", err)
+	}
+	return s.issueTokens(ctx, user, "")
 }
 
 func (s *Service) Test() string {
@@ -94,16 +211,51 @@ func (s *Service) Test() string {
 }
 
 func (s *Service) GetUserByLogin(ctx context.Context, login string) (models.User, error) {
-	return s.repo.GetUserByLogin(ctx, login)
+	return s.findUserByLogin(ctx, login)
+}
+
+// findUserByLogin looks the login up in its normalized form first. Accounts
+// registered before logins were normalized are still found by the exact
+// login they were created with.
+func (s *Service) findUserByLogin(ctx context.Context, login string) (models.User, error) {
+	normalized := s.credentials.NormalizeLogin(login)
+	user, err := s.repo.GetUserByLogin(ctx, normalized)
+	if err == e.ErrUserNotFound && normalized != login {
+		return s.repo.GetUserByLogin(ctx, login)
+	}
+	return user, err
 }
 
 func (s *Service) GetUserBalance(ctx context.Context, userID int) (current, withdrawn float64, err error) {
 	return s.repo.GetUserBalance(ctx, userID)
 }
 
-func (s *Service) UploadOrder(ctx context.Context, userID int, orderNumber string) error {
-	if !isValidLuhn(orderNumber) {
-		return e.ErrInvalidOrderNumber
+func (s *Service) UploadOrder(ctx context.Context, userID int, orderNumber, ip string) error {
+	if err := s.fraud.CheckRestrictions(ctx, userID); err != nil {
+		return err
+	}
+	orderNumber = validation.Normalize(orderNumber)
+
+	err := s.uploadOrder(ctx, userID, orderNumber)
+
+	event := FraudEvent{
+		UserID:      userID,
+		IP:          ip,
+		OrderNumber: orderNumber,
+		Result:      uploadResult(err),
+		At:          time.Now(),
+	}
+	if event.Result != "" {
+		if ferr := s.fraud.Observe(ctx, event); ferr != nil {
+			s.logger.Errorf("fraud check for order %s failed: %v", orderNumber, ferr)
+		}
+	}
+	return err
+}
+
+func (s *Service) uploadOrder(ctx context.Context, userID int, orderNumber string) error {
+	if err := s.validateOrderNumber(orderNumber); err != nil {
+		return err
 	}
 
 	existingOrder, err := s.repo.GetOrderByNumber(ctx, orderNumber)
@@ -124,35 +276,243 @@ func (s *Service) UploadOrder(ctx context.Context, userID int, orderNumber strin
 	return s.repo.CreateOrder(ctx, order)
 }
 
-func (s *Service) GetUserOrders(ctx context.Context, userID int) ([]models.Order, error) {
-	return s.repo.GetOrdersByUserID(ctx, userID)
+// UploadOrders uploads a batch of order numbers and reports a result per
+// number, in input order, with the same meaning as UploadOrder. New orders
+// are inserted with one statement.
+func (s *Service) UploadOrders(ctx context.Context, userID int, numbers []string, ip string) ([]models.OrderUploadItem, error) {
+	if err := s.fraud.CheckRestrictions(ctx, userID); err != nil {
+		return nil, err
+	}
+	if len(numbers) == 0 {
+		return nil, e.ErrEmptyBatch
+	}
+	if s.orderBatchLimit > 0 && len(numbers) > s.orderBatchLimit {
+		return nil, e.ErrBatchTooLarge
+	}
+
+	items := make([]models.OrderUploadItem, len(numbers))
+	first := make(map[string]int, len(numbers))
+	var candidates []string
+	for i, number := range numbers {
+		number = validation.Normalize(number)
+		items[i].Number = number
+		if err := s.validateOrderNumber(number); err != nil {
+			items[i].Result = models.UploadResultInvalid
+			continue
+		}
+		// A number repeated inside the batch is answered once the first
+		// occurrence's result is known.
+		if _, seen := first[number]; seen {
+			continue
+		}
+		first[number] = i
+		candidates = append(candidates, number)
+	}
+
+	existing, err := s.repo.GetOrdersByNumbers(ctx, candidates)
+	if err != nil {
+		return nil, err
+	}
+	taken := make(map[string]struct{}, len(existing))
+	for _, order := range existing {
+		taken[order.Number] = struct{}{}
+		if order.UserID == userID {
+			items[first[order.Number]].Result = models.UploadResultDuplicate
+		} else {
+			items[first[order.Number]].Result = models.UploadResultConflict
+		}
+	}
+
+	fresh := make([]string, 0, len(candidates)-len(taken))
+	for _, number := range candidates {
+		if _, ok := taken[number]; !ok {
+			fresh = append(fresh, number)
+		}
+	}
+	inserted, err := s.repo.CreateOrders(ctx, userID, fresh)
+	if err != nil {
+		return nil, err
+	}
+	for _, number := range fresh {
+		items[first[number]].Result = models.UploadResultConflict
+	}
+	for _, number := range inserted {
+		items[first[number]].Result = models.UploadResultAccepted
+	}
+	// As with UploadOrder called twice, a repeat of a number that is now
+	// the caller's is a duplicate; a conflict stays a conflict.
+	for i := range items {
+		j, ok := first[items[i].Number]
+		if !ok || j == i {
+			continue
+		}
+		items[i].Result = models.UploadResultDuplicate
+		if items[j].Result == models.UploadResultConflict {
+			items[i].Result = models.UploadResultConflict
+		}
+	}
+
+	// The fraud rules see each number once, so repeats inside one batch
+	// cannot trip them.
+	now := time.Now()
+	events := make([]FraudEvent, 0, len(items))
+	observed := make(map[string]bool, len(items))
+	for _, item := range items {
+		if observed[item.Number] {
+			continue
+		}
+		observed[item.Number] = true
+		events = append(events, FraudEvent{
+			UserID:      userID,
+			IP:          ip,
+			OrderNumber: item.Number,
+			Result:      item.Result,
+			At:          now,
+		})
+	}
+	if err := s.fraud.ObserveBatch(ctx, events); err != nil {
+		s.logger.Errorf("fraud check for order batch failed: %v", err)
+	}
+	return items, nil
+}
+
+// uploadResult maps an upload outcome to the value the fraud rules see.
+// Internal failures return an empty result and are not recorded.
+func uploadResult(err error) models.UploadResult {
+	switch err {
+	case nil:
+		return models.UploadResultAccepted
+	case e.ErrOrderAlreadyUploadedByUser:
+		return models.UploadResultDuplicate
+	case e.ErrOrderAlreadyUploadedByAnotherUser:
+		return models.UploadResultConflict
+	case e.ErrInvalidOrderNumber:
+		return models.UploadResultInvalid
+	default:
+		return ""
+	}
+}
+
+// GetUserOrder returns one of the user's orders. Orders of other users are
+// reported as not found, so that order numbers cannot be probed.
+func (s *Service) GetUserOrder(ctx context.Context, userID int, orderNumber string) (models.Order, error) {
+	order, err := s.repo.GetOrderByNumber(ctx, validation.Normalize(orderNumber))
+	if err != nil {
+		return models.Order{}, err
+	}
+	if order.UserID != userID {
+		return models.Order{}, e.ErrOrderNotFound
+	}
+	return order, nil
 }
 
-func (s *Service) Withdraw(ctx context.Context, userID int, orderNumber string, sum float64) error {
+// GetUserOrders returns a page of the user's orders and the cursor of the
+// next page, which is nil on the last one.
+func (s *Service) GetUserOrders(ctx context.Context, userID int, q models.PageQuery) ([]models.Order, *models.PageCursor, error) {
+	if q.Limit > 0 {
+		q.Limit++
+	}
+	orders, err := s.repo.GetOrdersByUserID(ctx, userID, q)
+	if err != nil {
+		return nil, nil, err
+	}
+	if q.Limit == 0 || len(orders) < q.Limit {
+		return orders, nil, nil
+	}
+	orders = orders[:len(orders)-1]
+	last := orders[len(orders)-1]
+	return orders, &models.PageCursor{At: last.UploadedAt, Number: last.Number}, nil
+}
+
+// Withdraw spends points on an order. Above the MFA threshold a TOTP or
+// recovery code is required, so users without 2FA cannot make such
+// withdrawals at all.
+func (s *Service) Withdraw(ctx context.Context, userID int, orderNumber string, sum float64, mfaCode string) (models.WithdrawalStatus, error) {
 	current, _, err := s.repo.GetUserBalance(ctx, userID)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	if current < sum {
-		return e.ErrInsufficientFunds
+		return "", e.ErrInsufficientFunds
 	}
 
-	if !isValidLuhn(orderNumber) {
-		return e.ErrInvalidOrderNumber
+	orderNumber = validation.Normalize(orderNumber)
+	if err := s.validateOrderNumber(orderNumber); err != nil {
+		return "", err
+	}
+
+	if s.mfaWithdrawalThreshold > 0 && sum > s.mfaWithdrawalThreshold {
+		err := s.verifyMFACode(ctx, userID, mfaCode)
+		if err == e.ErrTOTPNotEnrolled {
+			// The withdrawal needs a second factor the user never set up.
+			return "", e.ErrWithdrawalNeedsMFA
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	status := models.WithdrawalStatusProcessed
+	if s.withdrawalReviewThreshold > 0 && sum > s.withdrawalReviewThreshold {
+		status = models.WithdrawalStatusPendingReview
 	}
 
 	withdrawal := models.Withdrawal{
 		Order:  orderNumber,
 		UserID: userID,
 		Sum:    sum,
+		Status: status,
+	}
+
+	if err := s.repo.CreateWithdrawal(ctx, withdrawal); err != nil {
+		return "", err
 	}
+	s.publishBalance(ctx, userID)
+	return status, nil
+}
 
-	return s.repo.CreateWithdrawal(ctx, withdrawal)
+// GetUserWithdrawals returns a page of the user's withdrawals and the
+// cursor of the next page, which is nil on the last one.
+func (s *Service) GetUserWithdrawals(ctx context.Context, userID int, q models.PageQuery) ([]models.Withdrawal, *models.PageCursor, error) {
+	if q.Limit > 0 {
+		q.Limit++
+	}
+	withdrawals, err := s.repo.GetWithdrawalsByUserID(ctx, userID, q)
+	if err != nil {
+		return nil, nil, err
+	}
+	if q.Limit == 0 || len(withdrawals) < q.Limit {
+		return withdrawals, nil, nil
+	}
+	withdrawals = withdrawals[:len(withdrawals)-1]
+	last := withdrawals[len(withdrawals)-1]
+	return withdrawals, &models.PageCursor{At: last.ProcessedAt, Number: last.Order}, nil
 }
 
-func (s *Service) GetUserWithdrawals(ctx context.Context, userID int) ([]models.Withdrawal, error) {
-	return s.repo.GetWithdrawalsByUserID(ctx, userID)
+func (s *Service) GetPendingWithdrawals(ctx context.Context) ([]models.Withdrawal, error) {
+	return s.repo.GetWithdrawalsByStatus(ctx, models.WithdrawalStatusPendingReview)
+}
+
+func (s *Service) ReviewWithdrawal(ctx context.Context, reviewerID int, orderNumber string, decision models.ReviewDecision, reason string) error {
+	if err := s.repo.ReviewWithdrawal(ctx, models.WithdrawalReview{
+		Order:      orderNumber,
+		ReviewerID: reviewerID,
+		Decision:   decision,
+		Reason:     reason,
+	}); err != nil {
+		return err
+	}
+	s.logger.Infof("withdrawal %s %s by user %d", orderNumber, decision, reviewerID)
+	return nil
+}
+
+func (s *Service) GetFraudSignals(ctx context.Context, userID int, unresolvedOnly bool) ([]models.FraudSignal, error) {
+	return s.repo.GetFraudSignals(ctx, userID, unresolvedOnly)
+}
+
+func (s *Service) ResolveFraudSignal(ctx context.Context, id, reviewerID int, resolution string) error {
+	return s.repo.ResolveFraudSignal(ctx, id, reviewerID, resolution)
 }
 
 func (s *Service) ProcessOrders(ctx context.Context) error {
@@ -162,6 +522,8 @@ func (s *Service) ProcessOrders(ctx context.Context) error {
 	}
 
 	for _, order := range orders {
+		ctx := tenant.WithMerchant(ctx, order.MerchantID)
+		previous := order
 		maxRetries := 3
 		for i := 0; i < maxRetries; i++ {
 			accrualResp, err := s.GetAccrual(ctx, order.Number)
@@ -177,17 +539,7 @@ func (s *Service) ProcessOrders(ctx context.Context) error {
 				continue
 			}
 
-			switch accrualResp.Status {
-			case models.AccrualStatusRegistered:
-				order.Status = models.OrderStatusNew
-			case models.AccrualStatusProcessing:
-				order.Status = models.OrderStatusProcessing
-			case models.AccrualStatusProcessed:
-				order.Status = models.OrderStatusProcessed
-				order.Accrual = accrualResp.Accrual
-			case models.AccrualStatusInvalid:
-				order.Status = models.OrderStatusInvalid
-			}
+			applyAccrual(&order, accrualResp)
 			break
 		}
 
@@ -197,15 +549,47 @@ func (s *Service) ProcessOrders(ctx context.Context) error {
 			time.AfterFunc(5*time.Minute, func() {
 				s.ProcessOrders(context.Background())
 			})
+			continue
+		}
+		if order.Status != previous.Status || order.Accrual != previous.Accrual {
+			s.publishEvent(ctx, models.UserEvent{
+				Type:   models.EventOrder,
+				UserID: order.UserID,
+				Order: &models.OrderEvent{
+					Number:  order.Number,
+					Status:  order.Status,
+					Accrual: order.Accrual,
+				},
+			})
+		}
+		if order.Accrual != previous.Accrual {
+			s.publishBalance(ctx, order.UserID)
 		}
 	}
 
 	return nil
 }
 
-func (s *Service) GetAccrual(ctx context.Context, orderNumber string) (AccrualResponse, error) {
+func applyAccrual(order *models.Order, accrualResp AccrualResponse) {
+	switch accrualResp.Status {
+	case models.AccrualStatusRegistered:
+		order.Status = models.OrderStatusNew
+	case models.AccrualStatusProcessing:
+		order.Status = models.OrderStatusProcessing
+	case models.AccrualStatusProcessed:
+		order.Status = models.OrderStatusProcessed
+		order.Accrual = accrualResp.Accrual
+	case models.AccrualStatusInvalid:
+		order.Status = models.OrderStatusInvalid
+	}
+}
 
-	url := fmt.Sprintf("%s/api/orders/%s", s.accSysAddr, orderNumber)
+func (s *Service) GetAccrual(ctx context.Context, orderNumber string) (AccrualResponse, error) {
+	accSysAddr, err := s.accrualAddress(ctx)
+	if err != nil {
+		return AccrualResponse{}, err
+	}
+	url := fmt.Sprintf("%s/api/orders/%s", accSysAddr, orderNumber)
 
 	var accrualResp AccrualResponse
 	resp, err := s.httpClient.R().
@@ -230,67 +614,31 @@ func (s *Service) GetAccrual(ctx context.Context, orderNumber string) (AccrualRe
 	}
 }
 
-func (s *Service) hashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	return string(bytes), err
-}
-
-func (s *Service) generateToken(login string) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"login": login,
-		"exp":   time.Now().Add(time.Hour * 24).Unix(),
-	})
-	return token.SignedString([]byte(s.jwtSecret))
+func (s *Service) hashPassword(ctx context.Context, password string) (string, error) {
+	return s.passwords.hash(ctx, password)
 }
 
-func (s *Service) comparePassword(hashedPassword, password string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
+func (s *Service) comparePassword(ctx context.Context, hashedPassword, password string) error {
+	_, err := s.passwords.compare(ctx, hashedPassword, password)
+	return err
 }
 
-func (s *Service) ValidateToken(tokenString string) (string, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, e.ErrUnexpectedSignMethod
-		}
-		return []byte(s.jwtSecret), nil
-	})
-
-	if err != nil {
-		return "", err
+// rehashPassword upgrades a hash made with an old algorithm or old
+// parameters. Failures are only logged: the old hash keeps working.
+func (s *Service) rehashPassword(ctx context.Context, user models.User, password string) {
+	hash, err := s.hashPassword(ctx, password)
+	if err == nil {
+		err = s.repo.RehashPassword(ctx, user.ID, user.PasswordHash, hash)
 	}
-
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		login, ok := claims["login"].(string)
-		if !ok {
-			return "", e.ErrInvalidTokenClaim
-		}
-		return login, nil
+	if err != nil {
+		s.logger.Errorf("rehash password of user %d failed: %v", user.ID, err)
 	}
-
-	return "", e.ErrInvalidToken
 }
 
-func isValidLuhn(number string) bool {
-	// Реализация алгоритма Луна
-	sum := 0
-	alternate := false
-
-	for i := len(number) - 1; i >= 0; i-- {
-		digit := int(number[i] - '0')
-		if digit < 0 || digit > 9 {
-			return false
-		}
-
-		if alternate {
-			digit *= 2
-			if digit > 9 {
-				digit = (digit % 10) + 1
-			}
-		}
-
-		sum += digit
-		alternate = !alternate
+func (s *Service) validateOrderNumber(number string) error {
+	if err := s.orderValidator.Validate(number); err != nil {
+		s.logger.Debugf("order number %q rejected: %v", number, err)
+		return e.ErrInvalidOrderNumber
 	}
-
-	return sum%10 == 0
+	return nil
 }
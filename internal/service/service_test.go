@@ -0,0 +1,184 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/chestorix/gophermart/internal/config"
+	e "github.com/chestorix/gophermart/internal/errors"
+	"github.com/chestorix/gophermart/internal/interfaces"
+	"github.com/chestorix/gophermart/internal/models"
+	"github.com/chestorix/gophermart/internal/validation"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"testing"
+)
+
+type registerRepo struct {
+	interfaces.Repository
+	logins []string
+}
+
+func (r *registerRepo) LoginTaken(ctx context.Context, login string) (bool, error) {
+	for _, existing := range r.logins {
+		if strings.EqualFold(existing, login) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func TestRegister_LegacyMixedCaseLogin(t *testing.T) {
+	s := &Service{
+		repo:        &registerRepo{logins: []string{"Ivan"}},
+		credentials: &validation.CredentialPolicy{},
+	}
+
+	for _, login := range []string{"ivan", "IVAN", "Ivan"} {
+		if _, err := s.Register(context.Background(), login, "secret-password"); !errors.Is(err, e.ErrUserAlreadyExists) {
+			t.Errorf("%s: expected ErrUserAlreadyExists, got %v", login, err)
+		}
+	}
+}
+
+// uploadRepo stores orders by number and loses the insert of every number
+// in raced, as if another user committed it first. It keeps the attempts
+// the fraud rules were shown.
+type uploadRepo struct {
+	interfaces.Repository
+	owners   map[string]int
+	raced    map[string]bool
+	attempts []models.UploadAttempt
+}
+
+func (r *uploadRepo) GetFraudSignals(ctx context.Context, userID int, unresolvedOnly bool) ([]models.FraudSignal, error) {
+	return nil, nil
+}
+
+func (r *uploadRepo) RecordUploadAttempts(ctx context.Context, attempts []models.UploadAttempt) error {
+	r.attempts = append(r.attempts, attempts...)
+	return nil
+}
+
+func (r *uploadRepo) GetOrdersByNumbers(ctx context.Context, numbers []string) ([]models.Order, error) {
+	var orders []models.Order
+	for _, number := range numbers {
+		if owner, ok := r.owners[number]; ok {
+			orders = append(orders, models.Order{Number: number, UserID: owner})
+		}
+	}
+	return orders, nil
+}
+
+func (r *uploadRepo) CreateOrders(ctx context.Context, userID int, numbers []string) ([]string, error) {
+	var inserted []string
+	for _, number := range numbers {
+		if !r.raced[number] {
+			inserted = append(inserted, number)
+		}
+	}
+	return inserted, nil
+}
+
+func TestUploadOrders_Repeats(t *testing.T) {
+	const userID = 1
+	repo := &uploadRepo{
+		owners: map[string]int{"12345678903": userID, "79927398713": 2},
+		raced:  map[string]bool{"4561261212345467": true},
+	}
+	s := &Service{
+		repo:           repo,
+		logger:         logrus.New(),
+		fraud:          &FraudEngine{repo: repo},
+		orderValidator: validation.Luhn{},
+	}
+
+	numbers := []string{
+		"12345678903", "12345678903",
+		"79927398713", "79927398713",
+		"4561261212345467", "4561261212345467",
+		"49927398716", "49927398716",
+		"12345678900", "12345678900",
+	}
+	want := []models.UploadResult{
+		models.UploadResultDuplicate, models.UploadResultDuplicate,
+		models.UploadResultConflict, models.UploadResultConflict,
+		models.UploadResultConflict, models.UploadResultConflict,
+		models.UploadResultAccepted, models.UploadResultDuplicate,
+		models.UploadResultInvalid, models.UploadResultInvalid,
+	}
+
+	items, err := s.UploadOrders(context.Background(), userID, numbers, "10.0.0.1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i, item := range items {
+		if item.Result != want[i] {
+			t.Errorf("%d %s: expected %s, got %s", i, item.Number, want[i], item.Result)
+		}
+	}
+
+	observed := make(map[string]models.UploadResult)
+	for _, attempt := range repo.attempts {
+		if _, ok := observed[attempt.OrderNumber]; ok {
+			t.Errorf("%s recorded more than once", attempt.OrderNumber)
+		}
+		observed[attempt.OrderNumber] = attempt.Result
+	}
+	for i := 0; i < len(numbers); i += 2 {
+		if observed[numbers[i]] != want[i] {
+			t.Errorf("%s: expected recorded %s, got %s", numbers[i], want[i], observed[numbers[i]])
+		}
+	}
+}
+
+// closeRepo closes a user without 2FA and records the cleared throttle keys.
+type closeRepo struct {
+	interfaces.Repository
+	user    models.User
+	cleared []string
+}
+
+func (r *closeRepo) GetUserByID(ctx context.Context, userID int) (models.User, error) {
+	return r.user, nil
+}
+
+func (r *closeRepo) GetUserTOTP(ctx context.Context, userID int) (models.UserTOTP, error) {
+	return models.UserTOTP{}, e.ErrTOTPNotEnrolled
+}
+
+func (r *closeRepo) CloseUser(ctx context.Context, userID int, pseudonym string, settlement models.ClosureBalance) error {
+	return nil
+}
+
+func (r *closeRepo) ClearLoginFailures(ctx context.Context, key string) error {
+	r.cleared = append(r.cleared, key)
+	return nil
+}
+
+func TestCloseAccount_ClearsNormalizedThrottleKey(t *testing.T) {
+	passwords, err := newPasswordHasher(config.PasswordHashConfig{Memory: 1024, Iterations: 1, Parallelism: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := passwords.hash(context.Background(), "secret-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A login stored before normalization, in mixed case.
+	repo := &closeRepo{user: models.User{ID: 1, Login: "Ivan", PasswordHash: hash}}
+	s := &Service{
+		repo:        repo,
+		logger:      logrus.New(),
+		passwords:   passwords,
+		credentials: &validation.CredentialPolicy{},
+		userCache:   newUserCache(),
+	}
+
+	if err := s.CloseAccount(context.Background(), 1, "secret-password", ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := loginThrottleKey(context.Background(), "ivan")
+	if len(repo.cleared) != 1 || repo.cleared[0] != want {
+		t.Errorf("expected %q cleared, got %q", want, repo.cleared)
+	}
+}
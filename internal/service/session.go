@@ -0,0 +1,113 @@
+package service
+
+import (
+	"context"
+	"github.com/chestorix/gophermart/internal/client"
+	e "github.com/chestorix/gophermart/internal/errors"
+	"github.com/chestorix/gophermart/internal/models"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// sessionTouchInterval is how often a session's last-seen time is
+	// written; requests in between are served from sessionTracker.
+	sessionTouchInterval = time.Minute
+	sessionTrackerSize   = 10000
+	maxUserAgentLength   = 512
+)
+
+type sessionSeen struct {
+	at      time.Time
+	revoked bool
+}
+
+// sessionTracker remembers, per replica, when a session was last written
+// and whether it was revoked then. Revocations made on this replica apply
+// at once; other replicas see them within sessionTouchInterval.
+type sessionTracker struct {
+	mu   sync.Mutex
+	seen map[string]sessionSeen
+}
+
+func newSessionTracker() *sessionTracker {
+	return &sessionTracker{seen: make(map[string]sessionSeen)}
+}
+
+func (t *sessionTracker) get(id string) (sessionSeen, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	seen, ok := t.seen[id]
+	if !ok || time.Since(seen.at) > sessionTouchInterval {
+		return sessionSeen{}, false
+	}
+	return seen, true
+}
+
+func (t *sessionTracker) put(id string, seen sessionSeen) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.seen) >= sessionTrackerSize {
+		for k, s := range t.seen {
+			if time.Since(s.at) > sessionTouchInterval {
+				delete(t.seen, k)
+			}
+		}
+		if len(t.seen) >= sessionTrackerSize {
+			t.seen = make(map[string]sessionSeen)
+		}
+	}
+	t.seen[id] = seen
+}
+
+// newSession describes a session opened by the request in ctx.
+func newSession(ctx context.Context, userID int, id string) models.Session {
+	info := client.FromContext(ctx)
+	userAgent := info.UserAgent
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+		for !utf8.ValidString(userAgent) {
+			userAgent = userAgent[:len(userAgent)-1]
+		}
+	}
+	return models.Session{ID: id, UserID: userID, IP: info.IP, UserAgent: userAgent}
+}
+
+// TouchSession updates the last-seen time of the token's session, at most
+// once per sessionTouchInterval, and rejects tokens of revoked sessions.
+func (s *Service) TouchSession(ctx context.Context, claims models.TokenClaims) error {
+	if claims.FamilyID == "" {
+		return nil
+	}
+	if seen, ok := s.sessions.get(claims.FamilyID); ok {
+		if seen.revoked {
+			return e.ErrSessionRevoked
+		}
+		return nil
+	}
+
+	revoked, err := s.repo.TouchSession(ctx, newSession(ctx, claims.UserID, claims.FamilyID))
+	if err != nil {
+		return err
+	}
+	s.sessions.put(claims.FamilyID, sessionSeen{at: time.Now(), revoked: revoked})
+	if revoked {
+		return e.ErrSessionRevoked
+	}
+	return nil
+}
+
+func (s *Service) GetSessions(ctx context.Context, userID int) ([]models.Session, error) {
+	return s.repo.GetSessions(ctx, userID)
+}
+
+// RevokeSession signs one of the user's sessions out: its refresh tokens
+// stop working and so do its access tokens.
+func (s *Service) RevokeSession(ctx context.Context, userID int, id string) error {
+	if err := s.repo.RevokeSession(ctx, userID, id); err != nil {
+		return err
+	}
+	s.sessions.put(id, sessionSeen{at: time.Now(), revoked: true})
+	return nil
+}
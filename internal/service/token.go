@@ -0,0 +1,246 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	e "github.com/chestorix/gophermart/internal/errors"
+	"github.com/chestorix/gophermart/internal/models"
+	"github.com/chestorix/gophermart/internal/tenant"
+	"github.com/golang-jwt/jwt/v4"
+	"time"
+)
+
+// issueTokens creates an access token and a refresh token for user. An
+// empty familyID starts a new refresh token family, i.e. a new session.
+func (s *Service) issueTokens(ctx context.Context, user models.User, familyID string) (models.TokenPair, error) {
+	if familyID == "" {
+		var err error
+		if familyID, err = randomToken(16); err != nil {
+			return models.TokenPair{}, err
+		}
+		if err := s.repo.CreateSession(ctx, newSession(ctx, user.ID, familyID)); err != nil {
+			return models.TokenPair{}, err
+		}
+	}
+
+	accessToken, err := s.generateToken(ctx, user, familyID)
+	if err != nil {
+		return models.TokenPair{}, err
+	}
+
+	refreshToken, err := randomToken(32)
+	if err != nil {
+		return models.TokenPair{}, err
+	}
+	if err := s.repo.CreateRefreshToken(ctx, models.RefreshToken{
+		UserID:    user.ID,
+		FamilyID:  familyID,
+		TokenHash: hashToken(refreshToken),
+		ExpiresAt: time.Now().Add(s.refreshTokenTTL),
+	}); err != nil {
+		return models.TokenPair{}, err
+	}
+
+	return models.TokenPair{
+		AccessToken:      accessToken,
+		RefreshToken:     refreshToken,
+		ExpiresIn:        s.accessTokenTTL,
+		RefreshExpiresIn: s.refreshTokenTTL,
+	}, nil
+}
+
+func (s *Service) generateToken(ctx context.Context, user models.User, familyID string) (string, error) {
+	merchant, err := s.currentMerchant(ctx)
+	if err != nil {
+		return "", err
+	}
+	jti, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"login": user.Login,
+		"uid":   user.ID,
+		"ver":   user.TokenVersion,
+		"iss":   merchant.JWTIssuer,
+		"mid":   merchant.ID,
+		"jti":   jti,
+		"fam":   familyID,
+		"exp":   time.Now().Add(s.accessTokenTTL).Unix(),
+	})
+	kid, key := s.jwtKeys.signingKey()
+	token.Header["kid"] = kid
+	return token.SignedString(key)
+}
+
+// RefreshToken exchanges a refresh token for a new pair. Every refresh
+// token works once; presenting a used one means it leaked, so the whole
+// family is revoked and the legitimate holder has to log in again.
+func (s *Service) RefreshToken(ctx context.Context, refreshToken string) (models.TokenPair, error) {
+	stored, err := s.repo.UseRefreshToken(ctx, hashToken(refreshToken))
+	switch err {
+	case nil:
+	case e.ErrRefreshTokenReused:
+		s.logger.Warnf("refresh token reuse detected for user %d, revoking family %s", stored.UserID, stored.FamilyID)
+		if err := s.repo.RevokeRefreshTokenFamily(ctx, stored.FamilyID); err != nil {
+			return models.TokenPair{}, err
+		}
+		return models.TokenPair{}, e.ErrRefreshTokenReused
+	default:
+		return models.TokenPair{}, err
+	}
+
+	user, err := s.repo.GetUserByID(ctx, stored.UserID)
+	if err != nil {
+		return models.TokenPair{}, e.ErrInvalidRefreshToken
+	}
+	return s.issueTokens(ctx, user, stored.FamilyID)
+}
+
+// Logout revokes the presented access token and the refresh token family
+// it belongs to.
+func (s *Service) Logout(ctx context.Context, claims models.TokenClaims) error {
+	if err := s.repo.RevokeToken(ctx, claims.ID, claims.ExpiresAt); err != nil {
+		return err
+	}
+	if claims.FamilyID == "" {
+		return nil
+	}
+	return s.repo.RevokeRefreshTokenFamily(ctx, claims.FamilyID)
+}
+
+// ValidateToken checks the token signature, that it was issued for the
+// merchant in ctx, by issuer and merchant ID, so a token from one store
+// cannot be replayed on another, that it has not been revoked and that its
+// user is still active.
+func (s *Service) ValidateToken(ctx context.Context, tokenString string) (models.TokenClaims, error) {
+	merchant, err := s.currentMerchant(ctx)
+	if err != nil {
+		return models.TokenClaims{}, err
+	}
+
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, e.ErrUnexpectedSignMethod
+		}
+		kid, _ := token.Header["kid"].(string)
+		key, ok := s.jwtKeys.verificationKey(kid)
+		if !ok {
+			return nil, e.ErrUnknownSigningKey
+		}
+		return key, nil
+	})
+
+	if err != nil {
+		return models.TokenClaims{}, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return models.TokenClaims{}, e.ErrInvalidToken
+	}
+	if !claims.VerifyIssuer(merchant.JWTIssuer, true) {
+		return models.TokenClaims{}, e.ErrInvalidToken
+	}
+	// Merchants may share an issuer, so the merchant ID decides.
+	if mid, ok := claims["mid"].(float64); !ok || int(mid) != merchant.ID {
+		return models.TokenClaims{}, e.ErrInvalidToken
+	}
+
+	login, ok := claims["login"].(string)
+	if !ok {
+		return models.TokenClaims{}, e.ErrInvalidTokenClaim
+	}
+	jti, ok := claims["jti"].(string)
+	if !ok {
+		return models.TokenClaims{}, e.ErrInvalidTokenClaim
+	}
+	userID, ok := claims["uid"].(float64)
+	if !ok {
+		return models.TokenClaims{}, e.ErrInvalidTokenClaim
+	}
+	version, _ := claims["ver"].(float64)
+	familyID, _ := claims["fam"].(string)
+	exp, _ := claims["exp"].(float64)
+
+	result := models.TokenClaims{
+		UserID:       int(userID),
+		Login:        login,
+		TokenVersion: int(version),
+		ID:           jti,
+		FamilyID:     familyID,
+		ExpiresAt:    time.Unix(int64(exp), 0),
+	}
+	if err := s.checkTokenState(ctx, result); err != nil {
+		return models.TokenClaims{}, err
+	}
+	return result, nil
+}
+
+// RevalidateToken repeats the checks of ValidateToken whose outcome can
+// change after the token was accepted: expiry, revocation, the user's state
+// and the session. Long-lived streams call it periodically.
+func (s *Service) RevalidateToken(ctx context.Context, claims models.TokenClaims) error {
+	if !time.Now().Before(claims.ExpiresAt) {
+		return e.ErrInvalidToken
+	}
+	if err := s.checkTokenState(ctx, claims); err != nil {
+		return err
+	}
+	return s.TouchSession(ctx, claims)
+}
+
+// checkTokenState verifies that the token is not on the revocation list and
+// that its user still accepts it.
+func (s *Service) checkTokenState(ctx context.Context, claims models.TokenClaims) error {
+	revoked, err := s.repo.IsTokenRevoked(ctx, claims.ID)
+	if err != nil {
+		return err
+	}
+	if revoked {
+		return e.ErrTokenRevoked
+	}
+	return s.checkTokenUser(ctx, claims)
+}
+
+// checkTokenUser verifies that the token's user exists, is not disabled and
+// has not bumped its token version since the token was issued.
+func (s *Service) checkTokenUser(ctx context.Context, claims models.TokenClaims) error {
+	key := userCacheKey{merchantID: tenant.MerchantID(ctx), userID: claims.UserID}
+	entry, ok := s.userCache.get(key)
+	if !ok {
+		user, err := s.repo.GetUserByID(ctx, claims.UserID)
+		if err != nil {
+			return err
+		}
+		entry = userCacheEntry{
+			tokenVersion: user.TokenVersion,
+			disabled:     user.DisabledAt != nil,
+		}
+		s.userCache.put(key, entry)
+	}
+
+	if entry.disabled {
+		return e.ErrUserDisabled
+	}
+	if entry.tokenVersion != claims.TokenVersion {
+		return e.ErrTokenRevoked
+	}
+	return nil
+}
+
+func randomToken(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
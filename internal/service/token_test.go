@@ -0,0 +1,239 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/chestorix/gophermart/internal/config"
+	e "github.com/chestorix/gophermart/internal/errors"
+	"github.com/chestorix/gophermart/internal/interfaces"
+	"github.com/chestorix/gophermart/internal/models"
+	"github.com/chestorix/gophermart/internal/tenant"
+	"github.com/golang-jwt/jwt/v4"
+	"testing"
+	"time"
+)
+
+// tokenRepo serves the lookups of ValidateToken and counts the user loads,
+// so tests can tell a cache hit from a miss.
+type tokenRepo struct {
+	interfaces.Repository
+	merchants []models.Merchant
+	users     map[int]models.User
+	revoked   map[string]bool
+	loads     int
+}
+
+func (r *tokenRepo) GetMerchants(ctx context.Context) ([]models.Merchant, error) {
+	return r.merchants, nil
+}
+
+func (r *tokenRepo) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	return r.revoked[jti], nil
+}
+
+func (r *tokenRepo) GetUserByID(ctx context.Context, userID int) (models.User, error) {
+	r.loads++
+	user, ok := r.users[userID]
+	if !ok {
+		return models.User{}, e.ErrUserNotFound
+	}
+	return user, nil
+}
+
+func newTokenService(t *testing.T, repo *tokenRepo) *Service {
+	t.Helper()
+	keys, _, err := newKeyRing(config.JWTConfig{Secret: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Service{
+		repo:           repo,
+		jwtKeys:        keys,
+		accessTokenTTL: time.Minute,
+		userCache:      newUserCache(),
+	}
+}
+
+func TestValidateToken_Merchant(t *testing.T) {
+	const shopID = 2
+	repo := &tokenRepo{
+		merchants: []models.Merchant{
+			{ID: tenant.DefaultMerchantID, JWTIssuer: "gophermart"},
+			{ID: shopID, JWTIssuer: "gophermart"},
+		},
+		users: map[int]models.User{1: {ID: 1, Login: "ivan"}},
+	}
+	s := newTokenService(t, repo)
+	home := tenant.WithMerchant(context.Background(), tenant.DefaultMerchantID)
+	shop := tenant.WithMerchant(context.Background(), shopID)
+
+	token, err := s.generateToken(home, repo.users[1], "family")
+	if err != nil {
+		t.Fatal(err)
+	}
+	kid, key := s.jwtKeys.signingKey()
+	unscoped := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"login": "ivan",
+		"uid":   1,
+		"iss":   "gophermart",
+		"jti":   "jti",
+		"exp":   time.Now().Add(time.Minute).Unix(),
+	})
+	unscoped.Header["kid"] = kid
+	withoutMID, err := unscoped.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		ctx   context.Context
+		token string
+		want  error
+	}{
+		{name: "issuing merchant", ctx: home, token: token},
+		{name: "merchant with the same issuer", ctx: shop, token: token, want: e.ErrInvalidToken},
+		{name: "missing merchant ID", ctx: home, token: withoutMID, want: e.ErrInvalidToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := s.ValidateToken(tt.ctx, tt.token); !errors.Is(err, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestRevalidateToken(t *testing.T) {
+	disabledAt := time.Now()
+	repo := &tokenRepo{
+		users: map[int]models.User{
+			1: {ID: 1, TokenVersion: 2},
+			2: {ID: 2, DisabledAt: &disabledAt},
+		},
+		revoked: map[string]bool{"logged-out": true},
+	}
+	s := newTokenService(t, repo)
+	valid := time.Now().Add(time.Minute)
+
+	tests := []struct {
+		name   string
+		claims models.TokenClaims
+		want   error
+	}{
+		{name: "valid", claims: models.TokenClaims{UserID: 1, TokenVersion: 2, ID: "jti", ExpiresAt: valid}},
+		{name: "expired", claims: models.TokenClaims{UserID: 1, TokenVersion: 2, ID: "jti", ExpiresAt: time.Now()}, want: e.ErrInvalidToken},
+		{name: "logged out", claims: models.TokenClaims{UserID: 1, TokenVersion: 2, ID: "logged-out", ExpiresAt: valid}, want: e.ErrTokenRevoked},
+		{name: "password changed", claims: models.TokenClaims{UserID: 1, TokenVersion: 1, ID: "jti", ExpiresAt: valid}, want: e.ErrTokenRevoked},
+		{name: "user disabled", claims: models.TokenClaims{UserID: 2, ID: "jti", ExpiresAt: valid}, want: e.ErrUserDisabled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := s.RevalidateToken(context.Background(), tt.claims); !errors.Is(err, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestValidateToken_TokenVersion(t *testing.T) {
+	repo := &tokenRepo{
+		merchants: []models.Merchant{{ID: tenant.DefaultMerchantID, JWTIssuer: "gophermart"}},
+		users:     map[int]models.User{1: {ID: 1, Login: "ivan", TokenVersion: 1}},
+	}
+	s := newTokenService(t, repo)
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		version int
+		want    error
+	}{
+		{name: "current version", version: 1},
+		{name: "issued before a password change", version: 0, want: e.ErrTokenRevoked},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := s.generateToken(ctx, models.User{ID: 1, Login: "ivan", TokenVersion: tt.version}, "family")
+			if err != nil {
+				t.Fatal(err)
+			}
+			claims, err := s.ValidateToken(ctx, token)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, err)
+			}
+			if err == nil && claims.TokenVersion != tt.version {
+				t.Errorf("expected version %d, got %d", tt.version, claims.TokenVersion)
+			}
+		})
+	}
+}
+
+func TestCheckTokenUser_Cache(t *testing.T) {
+	key := userCacheKey{merchantID: tenant.DefaultMerchantID, userID: 1}
+	claims := models.TokenClaims{UserID: 1, TokenVersion: 0}
+
+	tests := []struct {
+		name string
+		// between changes the user or the cache between the two checks.
+		between   func(s *Service, repo *tokenRepo)
+		wantLoads int
+		want      error
+	}{
+		{
+			name:      "hit",
+			between:   func(s *Service, repo *tokenRepo) {},
+			wantLoads: 1,
+		},
+		{
+			name: "hit keeps the cached version",
+			between: func(s *Service, repo *tokenRepo) {
+				repo.users[1] = models.User{ID: 1, TokenVersion: 1}
+			},
+			wantLoads: 1,
+		},
+		{
+			name: "expired entry is reloaded",
+			between: func(s *Service, repo *tokenRepo) {
+				entry := s.userCache.entries[key]
+				entry.expiresAt = time.Now().Add(-time.Second)
+				s.userCache.entries[key] = entry
+				repo.users[1] = models.User{ID: 1, TokenVersion: 1}
+			},
+			wantLoads: 2,
+			want:      e.ErrTokenRevoked,
+		},
+		{
+			name: "invalidated entry is reloaded",
+			between: func(s *Service, repo *tokenRepo) {
+				disabledAt := time.Now()
+				repo.users[1] = models.User{ID: 1, DisabledAt: &disabledAt}
+				s.userCache.invalidate(key)
+			},
+			wantLoads: 2,
+			want:      e.ErrUserDisabled,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &tokenRepo{users: map[int]models.User{1: {ID: 1}}}
+			s := newTokenService(t, repo)
+			ctx := context.Background()
+
+			if err := s.checkTokenUser(ctx, claims); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			tt.between(s, repo)
+			if err := s.checkTokenUser(ctx, claims); !errors.Is(err, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, err)
+			}
+			if repo.loads != tt.wantLoads {
+				t.Errorf("expected %d user loads, got %d", tt.wantLoads, repo.loads)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// Test vectors from RFC 6238, appendix B, truncated to 6 digits.
+	secret := []byte("12345678901234567890")
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{unix: 59, code: "287082"},
+		{unix: 1111111109, code: "081804"},
+		{unix: 1234567890, code: "005924"},
+		{unix: 2000000000, code: "279037"},
+	}
+
+	for _, tt := range tests {
+		if got := totpCode(secret, tt.unix/totpPeriod); got != tt.code {
+			t.Errorf("at %d expected %s, got %s", tt.unix, tt.code, got)
+		}
+	}
+}
+
+func TestMatchTOTP(t *testing.T) {
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(1111111109, 0)
+
+	if step, ok := matchTOTP(secret, "081804", now); !ok || step != 1111111109/totpPeriod {
+		t.Errorf("current code rejected")
+	}
+	if _, ok := matchTOTP(strings.ToLower(secret), "081804", now.Add(totpPeriod*time.Second)); !ok {
+		t.Errorf("code of the previous step rejected")
+	}
+	if _, ok := matchTOTP(secret, "081804", now.Add(3*totpPeriod*time.Second)); ok {
+		t.Errorf("stale code accepted")
+	}
+	if _, ok := matchTOTP(secret, "000000", now); ok {
+		t.Errorf("wrong code accepted")
+	}
+}
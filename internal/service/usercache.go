@@ -0,0 +1,69 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	userCacheTTL  = 30 * time.Second
+	userCacheSize = 10000
+)
+
+type userCacheKey struct {
+	merchantID int
+	userID     int
+}
+
+type userCacheEntry struct {
+	tokenVersion int
+	disabled     bool
+	expiresAt    time.Time
+}
+
+// userCache remembers, per replica, whether a user still exists, whether it
+// is disabled and its token version, so authenticated requests do not have
+// to load the user row. Changes made on this replica invalidate the entry
+// right away; other replicas pick them up within userCacheTTL.
+type userCache struct {
+	mu      sync.Mutex
+	entries map[userCacheKey]userCacheEntry
+}
+
+func newUserCache() *userCache {
+	return &userCache{entries: make(map[userCacheKey]userCacheEntry)}
+}
+
+func (c *userCache) get(key userCacheKey) (userCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return userCacheEntry{}, false
+	}
+	return entry, true
+}
+
+func (c *userCache) put(key userCacheKey, entry userCacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= userCacheSize {
+		now := time.Now()
+		for k, e := range c.entries {
+			if now.After(e.expiresAt) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= userCacheSize {
+			c.entries = make(map[userCacheKey]userCacheEntry)
+		}
+	}
+	entry.expiresAt = time.Now().Add(userCacheTTL)
+	c.entries[key] = entry
+}
+
+func (c *userCache) invalidate(key userCacheKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+}
@@ -0,0 +1,92 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	e "github.com/chestorix/gophermart/internal/errors"
+	"github.com/chestorix/gophermart/internal/models"
+	"strings"
+	"time"
+)
+
+const (
+	maxVoucherBatch = 10000
+	voucherCodeLen  = 12
+	// voucherAlphabet leaves out 0/O and 1/I so codes survive being read aloud.
+	voucherAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+)
+
+func (s *Service) GenerateVouchers(ctx context.Context, creatorID, count int, amount float64, expiresAt *time.Time) (string, []models.Voucher, error) {
+	if count < 1 || count > maxVoucherBatch || amount <= 0 {
+		return "", nil, e.ErrInvalidVoucherBatch
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return "", nil, e.ErrInvalidVoucherBatch
+	}
+
+	batch := make([]byte, 8)
+	if _, err := rand.Read(batch); err != nil {
+		return "", nil, err
+	}
+	batchID := hex.EncodeToString(batch)
+
+	vouchers := make([]models.Voucher, 0, count)
+	for i := 0; i < count; i++ {
+		code, err := generateVoucherCode()
+		if err != nil {
+			return "", nil, err
+		}
+		vouchers = append(vouchers, models.Voucher{
+			Code:      code,
+			BatchID:   batchID,
+			Amount:    amount,
+			ExpiresAt: expiresAt,
+			CreatedBy: creatorID,
+		})
+	}
+
+	if err := s.repo.CreateVouchers(ctx, vouchers); err != nil {
+		return "", nil, err
+	}
+	s.logger.Infof("voucher batch %s of %d x %.2f created by user %d", batchID, count, amount, creatorID)
+	return batchID, vouchers, nil
+}
+
+func (s *Service) GetVoucherBatch(ctx context.Context, batchID string) ([]models.Voucher, error) {
+	return s.repo.GetVouchersByBatch(ctx, batchID)
+}
+
+func (s *Service) RedeemVoucher(ctx context.Context, userID int, code string) (models.Voucher, error) {
+	code = normalizeVoucherCode(code)
+	if code == "" {
+		return models.Voucher{}, e.ErrVoucherNotFound
+	}
+	return s.repo.RedeemVoucher(ctx, code, userID)
+}
+
+func (s *Service) GetUserVouchers(ctx context.Context, userID int) ([]models.Voucher, error) {
+	return s.repo.GetVouchersByRedeemer(ctx, userID)
+}
+
+func generateVoucherCode() (string, error) {
+	buf := make([]byte, voucherCodeLen)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	for i, b := range buf {
+		buf[i] = voucherAlphabet[int(b)%len(voucherAlphabet)]
+	}
+	return string(buf), nil
+}
+
+// normalizeVoucherCode upper-cases the code and drops the dashes and spaces
+// of the printed XXXX-XXXX-XXXX form.
+func normalizeVoucherCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(strings.TrimSpace(code)))
+}
@@ -0,0 +1,22 @@
+// Package tenant carries the merchant a request belongs to through the context.
+package tenant
+
+import "context"
+
+// DefaultMerchantID is the merchant that existed before multi-tenancy; rows
+// created by older versions belong to it.
+const DefaultMerchantID = 1
+
+type contextKey struct{}
+
+func WithMerchant(ctx context.Context, merchantID int) context.Context {
+	return context.WithValue(ctx, contextKey{}, merchantID)
+}
+
+// MerchantID returns the merchant stored in ctx, or DefaultMerchantID.
+func MerchantID(ctx context.Context) int {
+	if id, ok := ctx.Value(contextKey{}).(int); ok {
+		return id
+	}
+	return DefaultMerchantID
+}
@@ -0,0 +1,113 @@
+package validation
+
+import (
+	_ "embed"
+	"fmt"
+	e "github.com/chestorix/gophermart/internal/errors"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Rules reported in policy violations.
+const (
+	RuleLoginLength     = "login_length"
+	RuleLoginCharset    = "login_charset"
+	RulePasswordLength  = "password_length"
+	RuleCommonPassword  = "common_password"
+	RulePasswordIsLogin = "password_is_login"
+)
+
+//go:embed common_passwords.txt
+var commonPasswordList string
+
+var commonPasswords = func() map[string]struct{} {
+	set := make(map[string]struct{})
+	for _, line := range strings.Split(commonPasswordList, "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			set[strings.ToLower(line)] = struct{}{}
+		}
+	}
+	return set
+}()
+
+// CredentialPolicy checks logins and passwords at registration and on
+// password changes. Lengths are counted in runes; a zero limit disables
+// the check.
+type CredentialPolicy struct {
+	LoginMinLength    int
+	LoginMaxLength    int
+	LoginPattern      *regexp.Regexp
+	PasswordMinLength int
+	PasswordMaxLength int
+	RejectCommon      bool
+}
+
+var loginFolder = cases.Fold()
+
+// NormalizeLogin brings a login to the form it is stored in: NFC with
+// case folded, so that "Ivan" and "ivan" are the same account.
+func (p *CredentialPolicy) NormalizeLogin(login string) string {
+	return norm.NFC.String(loginFolder.String(strings.TrimSpace(login)))
+}
+
+// CheckLogin validates a normalized login.
+func (p *CredentialPolicy) CheckLogin(login string) []e.PolicyViolation {
+	var violations []e.PolicyViolation
+	if v, ok := checkLength("login", RuleLoginLength, login, p.LoginMinLength, p.LoginMaxLength); !ok {
+		violations = append(violations, v)
+	}
+	if p.LoginPattern != nil && !p.LoginPattern.MatchString(login) {
+		violations = append(violations, e.PolicyViolation{
+			Field:   "login",
+			Rule:    RuleLoginCharset,
+			Message: fmt.Sprintf("login must match %s", p.LoginPattern),
+		})
+	}
+	return violations
+}
+
+// CheckPassword validates a password for field. login may be empty when it
+// is not known, which skips the password_is_login rule.
+func (p *CredentialPolicy) CheckPassword(field, password, login string) []e.PolicyViolation {
+	var violations []e.PolicyViolation
+	if v, ok := checkLength(field, RulePasswordLength, password, p.PasswordMinLength, p.PasswordMaxLength); !ok {
+		violations = append(violations, v)
+	}
+	if p.RejectCommon {
+		if _, ok := commonPasswords[strings.ToLower(password)]; ok {
+			violations = append(violations, e.PolicyViolation{
+				Field:   field,
+				Rule:    RuleCommonPassword,
+				Message: "password is too common",
+			})
+		}
+	}
+	if login != "" && p.NormalizeLogin(password) == login {
+		violations = append(violations, e.PolicyViolation{
+			Field:   field,
+			Rule:    RulePasswordIsLogin,
+			Message: "password must differ from the login",
+		})
+	}
+	return violations
+}
+
+func checkLength(field, rule, value string, minLen, maxLen int) (e.PolicyViolation, bool) {
+	n := utf8.RuneCountInString(value)
+	if (minLen > 0 && n < minLen) || (maxLen > 0 && n > maxLen) {
+		var msg string
+		switch {
+		case maxLen <= 0:
+			msg = fmt.Sprintf("%s must be at least %d characters long", field, minLen)
+		case minLen <= 0:
+			msg = fmt.Sprintf("%s must be at most %d characters long", field, maxLen)
+		default:
+			msg = fmt.Sprintf("%s must be %d to %d characters long", field, minLen, maxLen)
+		}
+		return e.PolicyViolation{Field: field, Rule: rule, Message: msg}, false
+	}
+	return e.PolicyViolation{}, true
+}
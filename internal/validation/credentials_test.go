@@ -0,0 +1,60 @@
+package validation
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestCredentialPolicy(t *testing.T) {
+	policy := &CredentialPolicy{
+		LoginMinLength:    3,
+		LoginMaxLength:    16,
+		LoginPattern:      regexp.MustCompile(`^[\p{L}\p{N}._@-]+$`),
+		PasswordMinLength: 8,
+		PasswordMaxLength: 64,
+		RejectCommon:      true,
+	}
+
+	tests := []struct {
+		name     string
+		login    string
+		password string
+		want     []string
+	}{
+		{name: "valid", login: "Ivan.Petrov", password: "correct horse"},
+		{name: "unicode login", login: "Müller", password: "correct horse"},
+		{name: "short login and password", login: "ab", password: "x1", want: []string{RuleLoginLength, RulePasswordLength}},
+		{name: "control character", login: "ivan\x00", password: "correct horse", want: []string{RuleLoginCharset}},
+		{name: "long login", login: "abcdefghijklmnopq", password: "correct horse", want: []string{RuleLoginLength}},
+		{name: "common password", login: "ivan", password: "Password123", want: []string{RuleCommonPassword}},
+		{name: "password is login", login: "ivanpetrov", password: "IvanPetrov", want: []string{RulePasswordIsLogin}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			login := policy.NormalizeLogin(tt.login)
+			violations := append(policy.CheckLogin(login), policy.CheckPassword("password", tt.password, login)...)
+
+			var got []string
+			for _, v := range violations {
+				got = append(got, v.Rule)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected rules %v, got %v", tt.want, got)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("expected rules %v, got %v", tt.want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestNormalizeLogin(t *testing.T) {
+	policy := &CredentialPolicy{}
+	// "e" followed by a combining acute accent composes to "é".
+	if got := policy.NormalizeLogin(" Amélie "); got != "amélie" {
+		t.Errorf("expected %q, got %q", "amélie", got)
+	}
+}
@@ -0,0 +1,220 @@
+// Package validation holds the order number validators and the registry
+// used to build a validator chain from configuration, and the credential
+// policy applied to logins and passwords.
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var ErrEmptyNumber = errors.New("order number is empty")
+
+// OrderNumberValidator checks an already normalized order number.
+type OrderNumberValidator interface {
+	Name() string
+	Validate(number string) error
+}
+
+// Factory builds a validator from the argument that follows the colon in
+// the configuration, e.g. "10-19" in "length:10-19".
+type Factory func(arg string) (OrderNumberValidator, error)
+
+var registry = map[string]Factory{
+	"luhn": func(arg string) (OrderNumberValidator, error) {
+		if arg != "" {
+			return nil, fmt.Errorf("luhn takes no argument")
+		}
+		return Luhn{}, nil
+	},
+	"length": newLength,
+	"prefix": newPrefix,
+	"regex":  newRegex,
+}
+
+// Register adds a validator factory under name. It panics on duplicates,
+// like http.Handle, since registration happens at init time.
+func Register(name string, factory Factory) {
+	if _, ok := registry[name]; ok {
+		panic("validation: duplicate validator " + name)
+	}
+	registry[name] = factory
+}
+
+// Parse builds a chain from a spec such as "luhn;length:10-19;prefix:2|5".
+// Validators are separated by ";" so that regular expressions may contain commas.
+func Parse(spec string) (OrderNumberValidator, error) {
+	var chain Chain
+	for _, item := range strings.Split(spec, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, arg, _ := strings.Cut(item, ":")
+		factory, ok := registry[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown order number validator %q", name)
+		}
+		validator, err := factory(arg)
+		if err != nil {
+			return nil, fmt.Errorf("order number validator %q: %w", name, err)
+		}
+		chain = append(chain, validator)
+	}
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("no order number validators configured")
+	}
+	return chain, nil
+}
+
+// Normalize removes the space, dash and dot separators people use when
+// copying numbers from receipts, then trims any remaining Unicode spaces.
+func Normalize(number string) string {
+	return strings.TrimSpace(strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\t', '\n', '\r', '-', '.', '_':
+			return -1
+		}
+		return r
+	}, number))
+}
+
+// Chain passes only when every validator in it passes.
+type Chain []OrderNumberValidator
+
+func (c Chain) Name() string {
+	names := make([]string, 0, len(c))
+	for _, v := range c {
+		names = append(names, v.Name())
+	}
+	return strings.Join(names, ";")
+}
+
+func (c Chain) Validate(number string) error {
+	if number == "" {
+		return ErrEmptyNumber
+	}
+	for _, v := range c {
+		if err := v.Validate(number); err != nil {
+			return fmt.Errorf("%s: %w", v.Name(), err)
+		}
+	}
+	return nil
+}
+
+type Luhn struct{}
+
+func (Luhn) Name() string { return "luhn" }
+
+func (Luhn) Validate(number string) error {
+	if number == "" {
+		return ErrEmptyNumber
+	}
+	sum := 0
+	alternate := false
+
+	for i := len(number) - 1; i >= 0; i-- {
+		digit := int(number[i]) - '0'
+		if digit < 0 || digit > 9 {
+			return fmt.Errorf("non-digit character %q", number[i])
+		}
+
+		if alternate {
+			digit *= 2
+			if digit > 9 {
+				digit = (digit % 10) + 1
+			}
+		}
+
+		sum += digit
+		alternate = !alternate
+	}
+
+	if sum%10 != 0 {
+		return errors.New("checksum mismatch")
+	}
+	return nil
+}
+
+type Length struct {
+	Min, Max int
+}
+
+func newLength(arg string) (OrderNumberValidator, error) {
+	minArg, maxArg, found := strings.Cut(arg, "-")
+	lower, err := strconv.Atoi(minArg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid minimum length %q", minArg)
+	}
+	upper := lower
+	if found {
+		if upper, err = strconv.Atoi(maxArg); err != nil {
+			return nil, fmt.Errorf("invalid maximum length %q", maxArg)
+		}
+	}
+	if lower < 1 || upper < lower {
+		return nil, fmt.Errorf("invalid length range %q", arg)
+	}
+	return Length{Min: lower, Max: upper}, nil
+}
+
+func (l Length) Name() string { return "length" }
+
+func (l Length) Validate(number string) error {
+	if n := len(number); n < l.Min || n > l.Max {
+		return fmt.Errorf("length %d is outside %d-%d", n, l.Min, l.Max)
+	}
+	return nil
+}
+
+type Prefix struct {
+	Prefixes []string
+}
+
+func newPrefix(arg string) (OrderNumberValidator, error) {
+	var prefixes []string
+	for _, prefix := range strings.Split(arg, "|") {
+		if prefix != "" {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	if len(prefixes) == 0 {
+		return nil, fmt.Errorf("at least one prefix is required")
+	}
+	return Prefix{Prefixes: prefixes}, nil
+}
+
+func (p Prefix) Name() string { return "prefix" }
+
+func (p Prefix) Validate(number string) error {
+	for _, prefix := range p.Prefixes {
+		if strings.HasPrefix(number, prefix) {
+			return nil
+		}
+	}
+	return errors.New("unknown prefix")
+}
+
+type Regex struct {
+	re *regexp.Regexp
+}
+
+func newRegex(arg string) (OrderNumberValidator, error) {
+	re, err := regexp.Compile(arg)
+	if err != nil {
+		return nil, err
+	}
+	return Regex{re: re}, nil
+}
+
+func (r Regex) Name() string { return "regex" }
+
+func (r Regex) Validate(number string) error {
+	if !r.re.MatchString(number) {
+		return fmt.Errorf("does not match %s", r.re)
+	}
+	return nil
+}
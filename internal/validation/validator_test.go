@@ -0,0 +1,145 @@
+package validation
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		number  string
+		wantErr bool
+		badSpec bool
+	}{
+		{name: "luhn valid", spec: "luhn", number: "79927398713"},
+		{name: "luhn invalid", spec: "luhn", number: "79927398710", wantErr: true},
+		{name: "empty number", spec: "luhn", number: "", wantErr: true},
+		{name: "chain valid", spec: "luhn;length:10-12;prefix:7|8", number: "79927398713"},
+		{name: "chain length", spec: "luhn;length:12-19", number: "79927398713", wantErr: true},
+		{name: "chain prefix", spec: "luhn;prefix:5", number: "79927398713", wantErr: true},
+		{name: "regex with comma", spec: "regex:^[0-9]{5,}$", number: "12345"},
+		{name: "unknown validator", spec: "crc32", badSpec: true},
+		{name: "bad length", spec: "length:9-3", badSpec: true},
+		{name: "empty spec", spec: " ; ", badSpec: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			validator, err := Parse(tt.spec)
+			if tt.badSpec {
+				if err == nil {
+					t.Fatalf("expected error for spec %q", tt.spec)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := validator.Validate(tt.number); (err != nil) != tt.wantErr {
+				t.Errorf("Validate(%q) error = %v, wantErr %v", tt.number, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	if got := Normalize("  7992-7398 713\n"); got != "79927398713" {
+		t.Errorf("expected 79927398713, got %q", got)
+	}
+}
+
+func FuzzNormalize(f *testing.F) {
+	f.Add("  7992-7398 713 ")
+	f.Add("")
+	f.Add("\t-.-\n")
+	f.Fuzz(func(t *testing.T, input string) {
+		once := Normalize(input)
+		if Normalize(once) != once {
+			t.Errorf("Normalize is not idempotent for %q", input)
+		}
+		if strings.ContainsAny(once, " \t\n\r-._") {
+			t.Errorf("Normalize(%q) = %q still contains separators", input, once)
+		}
+	})
+}
+
+func FuzzLuhn(f *testing.F) {
+	f.Add("79927398713")
+	f.Add("0")
+	f.Add("")
+	f.Add(" 79927398713")
+	f.Fuzz(func(t *testing.T, number string) {
+		if (Luhn{}).Validate(number) != nil {
+			return
+		}
+		if number == "" {
+			t.Fatal("empty number accepted")
+		}
+		for _, r := range number {
+			if r < '0' || r > '9' {
+				t.Fatalf("non-digit number %q accepted", number)
+			}
+		}
+		// Changing the check digit must break the checksum.
+		last := number[len(number)-1]
+		altered := number[:len(number)-1] + string('0'+(last-'0'+1)%10)
+		if (Luhn{}).Validate(altered) == nil {
+			t.Fatalf("both %q and %q accepted", number, altered)
+		}
+	})
+}
+
+func FuzzLength(f *testing.F) {
+	f.Add("12345", 3, 6)
+	f.Add("", 1, 1)
+	f.Fuzz(func(t *testing.T, number string, lower, upper int) {
+		if lower < 1 || upper < lower {
+			return
+		}
+		err := Length{Min: lower, Max: upper}.Validate(number)
+		inRange := len(number) >= lower && len(number) <= upper
+		if (err == nil) != inRange {
+			t.Errorf("Length{%d, %d}.Validate(%q) = %v", lower, upper, number, err)
+		}
+	})
+}
+
+func FuzzPrefix(f *testing.F) {
+	f.Add("2377225624", "2|5")
+	f.Add("", "|")
+	f.Fuzz(func(t *testing.T, number, arg string) {
+		validator, err := newPrefix(arg)
+		if err != nil {
+			return
+		}
+		matched := false
+		for _, prefix := range validator.(Prefix).Prefixes {
+			matched = matched || strings.HasPrefix(number, prefix)
+		}
+		if (validator.Validate(number) == nil) != matched {
+			t.Errorf("prefix %q on %q disagrees with strings.HasPrefix", arg, number)
+		}
+	})
+}
+
+func FuzzRegex(f *testing.F) {
+	f.Add("12345", `^[0-9]{5,}$`)
+	f.Add("abc", `(`)
+	f.Fuzz(func(t *testing.T, number, pattern string) {
+		if !utf8.ValidString(pattern) {
+			return
+		}
+		validator, err := newRegex(pattern)
+		if err != nil {
+			return
+		}
+		want := regexp.MustCompile(pattern).MatchString(number)
+		if (validator.Validate(number) == nil) != want {
+			t.Errorf("regex %q on %q disagrees with regexp.MatchString", pattern, number)
+		}
+	})
+}